@@ -5,6 +5,32 @@ const (
 	ModeNodePort = "nodePort"
 )
 
+const (
+	// LabelKeyApp 生成的资源上用来选择 pod 的标签，值为 MyDeployment 的名称
+	LabelKeyApp = "app"
+)
+
+const (
+	// VolumeNameConfigFiles spec.configFiles 生成的 ConfigMap 挂载使用的卷名称
+	VolumeNameConfigFiles = "config-files"
+	// VolumeNameStorage spec.storage 生成的 PVC 挂载使用的卷名称
+	VolumeNameStorage = "storage"
+
+	DefaultConfigFilesMountPath = "/etc/config"
+
+	// AnnotationConfigChecksum pod template 上记录 spec.configFiles 内容摘要的注解，
+	// 内容变化后 pod template 随之变化，从而触发滚动更新
+	AnnotationConfigChecksum = "apps.shudong.com/config-checksum"
+	// AnnotationReferenceChecksum pod template 上记录 spec 中引用的 ConfigMap / Secret 内容摘要的注解
+	AnnotationReferenceChecksum = "apps.shudong.com/reference-checksum"
+	// AnnotationRolloutOnChange 设置为 "false" 时，引用的 ConfigMap / Secret 变化不触发滚动更新，
+	// 可以设置在 MyDeployment 上，也可以设置在单个 ConfigMap / Secret 上
+	AnnotationRolloutOnChange = "apps.shudong.com/rollout-on-change"
+	// AnnotationV1Expose 转换为 v2 时保存 v2 中无法表示的 spec.expose，比如 mode 为空时设置的 ingressDomain，
+	// 转换回 v1 时恢复，保证转换不丢失配置
+	AnnotationV1Expose = "apps.shudong.com/v1-expose"
+)
+
 const (
 	ConditionStatusTrue  = "True"
 	ConditionStatusFalse = "False"
@@ -12,6 +38,8 @@ const (
 	ConditionTypeDeployment = "Deployment"
 	ConditionTypeService    = "Service"
 	ConditionTypeIngress    = "Ingress"
+	ConditionTypeConfigMap  = "ConfigMap"
+	ConditionTypeStorage    = "Storage"
 
 	ConditionMessageDeploymentOKFmt    = "Deployment %s is ready"
 	ConditionMessageDeploymentNotOKFmt = "Deployment %s is not ready"
@@ -19,6 +47,10 @@ const (
 	ConditionMessageServiceNotOKFmt    = "Service %s is not ready"
 	ConditionMessageIngressOKFmt       = "Ingress %s is ready"
 	ConditionMessageIngressNotOKFmt    = "Ingress %s is not ready"
+	ConditionMessageConfigMapOKFmt     = "ConfigMap %s is ready"
+	ConditionMessageConfigMapNotOKFmt  = "ConfigMap %s is not ready"
+	ConditionMessageStorageOKFmt       = "PersistentVolumeClaim %s is bound"
+	ConditionMessageStorageNotOKFmt    = "PersistentVolumeClaim %s is not bound"
 
 	ConditionReasonDeploymentReady    = "DeploymentReady"
 	ConditionReasonDeploymentNotReady = "DeploymentNotReady"
@@ -26,6 +58,111 @@ const (
 	ConditionReasonServiceNotReady    = "ServiceNotReady"
 	ConditionReasonIngressReady       = "IngressReady"
 	ConditionReasonIngressNotReady    = "IngressNotReady"
+	ConditionReasonConfigMapReady     = "ConfigMapReady"
+	ConditionReasonConfigMapNotReady  = "ConfigMapNotReady"
+	ConditionReasonStorageReady       = "StorageReady"
+	ConditionReasonStorageNotReady    = "StorageNotReady"
+)
+
+const (
+	// ConditionMessageDeploymentFailedFmt Deployment 创建 pod 失败时的信息，依次为名称、原因、详细信息
+	ConditionMessageDeploymentFailedFmt = "Deployment %s is not ready, %s: %s"
+	// ConditionReasonDeploymentReplicaFailure ReplicaSet 创建 pod 失败，比如被准入策略拒绝
+	ConditionReasonDeploymentReplicaFailure = "DeploymentReplicaFailure"
+	// ConditionReasonDeploymentImagePullFailure pod 拉取镜像失败，比如 ErrImagePull、ImagePullBackOff
+	ConditionReasonDeploymentImagePullFailure = "DeploymentImagePullFailure"
+)
+
+const (
+	ConditionTypeServiceAccount = "ServiceAccount"
+
+	ConditionMessageServiceAccountOKFmt = "ServiceAccount %s is ready"
+
+	ConditionReasonServiceAccountReady    = "ServiceAccountReady"
+	ConditionReasonServiceAccountNotReady = "ServiceAccountNotReady"
+)
+
+const (
+	// LabelKeyTrack 区分主 Deployment 和金丝雀 pod 的标签，两个 Deployment 的 selector 不会重叠
+	LabelKeyTrack    = "track"
+	LabelValueStable = "stable"
+	LabelValueCanary = "canary"
+
+	// AnnotationNginxCanary 标记 Ingress 为 nginx 的金丝雀 Ingress
+	AnnotationNginxCanary = "nginx.ingress.kubernetes.io/canary"
+	// AnnotationNginxCanaryWeight 分配给金丝雀 Ingress 的流量百分比
+	AnnotationNginxCanaryWeight = "nginx.ingress.kubernetes.io/canary-weight"
+)
+
+const (
+	ConditionTypeCanary = "Canary"
+
+	ConditionMessageCanaryAnalysisFmt   = "Canary %s is under analysis until %s"
+	ConditionMessageCanaryOKFmt         = "Canary %s passed analysis, set spec.canary.promote to promote or roll back"
+	ConditionMessageCanaryPromotedFmt   = "Canary image %s is promoted, update spec.image and remove spec.canary"
+	ConditionMessageCanaryRolledBackFmt = "Canary image %s is rolled back, remove spec.canary"
+
+	ConditionReasonCanaryNotReady   = "CanaryNotReady"
+	ConditionReasonCanaryAnalysis   = "CanaryAnalysis"
+	ConditionReasonCanaryReady      = "CanaryReady"
+	ConditionReasonCanaryPromoted   = "CanaryPromoted"
+	ConditionReasonCanaryRolledBack = "CanaryRolledBack"
+)
+
+const (
+	StrategyRollingUpdate = "RollingUpdate"
+	StrategyBlueGreen     = "BlueGreen"
+
+	// LabelKeyColor 蓝绿发布时 pod 上区分颜色的标签
+	LabelKeyColor = "color"
+	ColorBlue     = "blue"
+	ColorGreen    = "green"
+
+	// AnnotationTemplateHash 蓝绿发布的 Deployment 上记录 pod template 摘要的注解，用来判断是否需要发布新版本
+	AnnotationTemplateHash = "apps.shudong.com/template-hash"
+	// AnnotationPromoteColor 设置在 MyDeployment 上，值为 preview 的颜色时，preview 全部就绪后切换，
+	// 用于 spec.strategy.blueGreen.autoPromote 为 false 时手动确认切换
+	AnnotationPromoteColor = "apps.shudong.com/promote-color"
+)
+
+const (
+	ConditionTypeBlueGreen = "BlueGreen"
+
+	ConditionMessageBlueGreenOKFmt             = "Active color is %s"
+	ConditionMessageBlueGreenPreviewNotOKFmt   = "Preview color %s is not ready"
+	ConditionMessageBlueGreenPreviewWaitingFmt = "Preview color %s is ready, set annotation %s=%s to switch"
+	ConditionMessageBlueGreenSwitchingFmt      = "Waiting for color %s to be ready before switching the Service to it"
+
+	ConditionReasonBlueGreenActive          = "BlueGreenActive"
+	ConditionReasonBlueGreenPreviewNotReady = "BlueGreenPreviewNotReady"
+	ConditionReasonBlueGreenPreviewReady    = "BlueGreenPreviewReady"
+	ConditionReasonBlueGreenSwitching       = "BlueGreenSwitching"
+)
+
+const (
+	ConditionTypeRolledBack = "RolledBack"
+
+	// ConditionMessageRolledBackFmt 依次为 Deployment 名称、失败的摘要、回滚到的摘要
+	ConditionMessageRolledBackFmt = "Deployment %s exceeded its progress deadline with pod template %s, rolled back to %s"
+
+	ConditionReasonRolledBack = "RolledBack"
+
+	// LabelKeyKnownGood ControllerRevision 上的标签，标记保存的是最后一次全部就绪的 pod template
+	LabelKeyKnownGood = "apps.shudong.com/known-good"
+	// EventReasonRolledBack 自动回滚时产生的事件原因
+	EventReasonRolledBack = "RolledBack"
+)
+
+const (
+	// LabelKeySpecRevision ControllerRevision 上的标签，标记保存的是 MyDeployment 的 spec 历史版本
+	LabelKeySpecRevision = "apps.shudong.com/spec-revision"
+
+	ConditionTypeRevision = "Revision"
+
+	// ConditionMessageRevisionNotFoundFmt 依次为版本号、MyDeployment 名称
+	ConditionMessageRevisionNotFoundFmt = "Revision %d of MyDeployment %s not found"
+
+	ConditionReasonRevisionNotFound = "RevisionNotFound"
 )
 
 const (
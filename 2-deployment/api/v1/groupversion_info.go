@@ -33,4 +33,12 @@ var (
 
 	// AddToScheme adds the types in this group-version to the given scheme.
 	AddToScheme = SchemeBuilder.AddToScheme
+
+	// SchemeGroupVersion 和 GroupVersion 相同，pkg/client 中生成的 clientset 使用这个名字
+	SchemeGroupVersion = GroupVersion
 )
+
+// Resource 返回这个 group 中资源的 GroupResource，pkg/client 中生成的 lister 使用
+func Resource(resource string) schema.GroupResource {
+	return SchemeGroupVersion.WithResource(resource).GroupResource()
+}
@@ -0,0 +1,205 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	v2 "deployment/api/v2"
+)
+
+var _ conversion.Convertible = &MyDeployment{}
+
+// ConvertTo 把 v1 转换为存储版本 v2
+// 两个版本中结构相同的类型直接转换，v1 中的指针为空时转换后仍然为空
+func (myDeployment *MyDeployment) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v2.MyDeployment)
+	if !ok {
+		return fmt.Errorf("expected a v2 MyDeployment but got %T", dstRaw)
+	}
+	src := myDeployment.DeepCopy()
+	dst.ObjectMeta = src.ObjectMeta
+
+	// 1. 改名的字段
+	dst.Spec.Image = src.Spec.Image
+	dst.Spec.Replicas = src.Spec.Replicas
+	dst.Spec.Command = src.Spec.StartCmd
+	dst.Spec.Args = src.Spec.Args
+	dst.Spec.Env = src.Spec.Environments
+
+	// 2. port 和 expose.servicePort 合并为 ports
+	dst.Spec.Ports = []v2.Port{{ContainerPort: src.Spec.Port}}
+	dst.Spec.Expose = nil
+	if src.Spec.Expose != nil {
+		dst.Spec.Ports[0].ServicePort = src.Spec.Expose.ServicePort
+	}
+	// 3. 不同模式的配置拆分到各自的结构中，和 mode 不一致的配置也保留，保证转换回 v1 后不丢失
+	// v2 中无法表示的配置保存在注解中
+	var lossy bool
+	dst.Spec.Expose, lossy = convertExposeToV2(src.Spec.Expose)
+	delete(dst.Annotations, AnnotationV1Expose)
+	if lossy {
+		data, err := json.Marshal(&Expose{Mode: src.Spec.Expose.Mode, Tls: src.Spec.Expose.Tls,
+			IngressDomain: src.Spec.Expose.IngressDomain, NodePort: src.Spec.Expose.NodePort})
+		if err != nil {
+			return err
+		}
+		if dst.Annotations == nil {
+			dst.Annotations = map[string]string{}
+		}
+		dst.Annotations[AnnotationV1Expose] = string(data)
+	}
+
+	// 4. 结构相同的字段
+	dst.Spec.Volumes = src.Spec.Volumes
+	dst.Spec.VolumeMounts = src.Spec.VolumeMounts
+	dst.Spec.ConfigFiles = src.Spec.ConfigFiles
+	dst.Spec.ConfigFilesMountPath = src.Spec.ConfigFilesMountPath
+	dst.Spec.Storage = (*v2.Storage)(src.Spec.Storage)
+	dst.Spec.Scheduling = (*v2.Scheduling)(src.Spec.Scheduling)
+	dst.Spec.SecurityContext = (*v2.SecurityContext)(src.Spec.SecurityContext)
+	dst.Spec.ServiceAccount = (*v2.ServiceAccount)(src.Spec.ServiceAccount)
+	dst.Spec.Canary = (*v2.Canary)(src.Spec.Canary)
+	dst.Spec.Strategy = nil
+	if src.Spec.Strategy != nil {
+		dst.Spec.Strategy = &v2.Strategy{
+			Type:      src.Spec.Strategy.Type,
+			BlueGreen: (*v2.BlueGreenStrategy)(src.Spec.Strategy.BlueGreen),
+		}
+	}
+	dst.Spec.Rollback = (*v2.Rollback)(src.Spec.Rollback)
+	dst.Spec.RevisionHistoryLimit = src.Spec.RevisionHistoryLimit
+	dst.Spec.RollbackTo = src.Spec.RollbackTo
+
+	dst.Status.Phase = src.Status.Phase
+	dst.Status.Message = src.Status.Message
+	dst.Status.Reason = src.Status.Reason
+	dst.Status.ObservedGeneration = src.Status.ObservedGeneration
+	dst.Status.Canary = (*v2.CanaryStatus)(src.Status.Canary)
+	dst.Status.ActiveColor = src.Status.ActiveColor
+	dst.Status.PreviewColor = src.Status.PreviewColor
+	dst.Status.Rollback = (*v2.RollbackStatus)(src.Status.Rollback)
+	dst.Status.CurrentRevision = src.Status.CurrentRevision
+	dst.Status.UpdateRevision = src.Status.UpdateRevision
+	dst.Status.Conditions = nil
+	for _, condition := range src.Status.Conditions {
+		dst.Status.Conditions = append(dst.Status.Conditions, v2.Condition(condition))
+	}
+	return nil
+}
+
+// ConvertFrom 把存储版本 v2 转换为 v1
+// v1 只有一个端口，ports 由 v2 的校验保证只有一个元素
+func (myDeployment *MyDeployment) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v2.MyDeployment)
+	if !ok {
+		return fmt.Errorf("expected a v2 MyDeployment but got %T", srcRaw)
+	}
+	src = src.DeepCopy()
+	myDeployment.ObjectMeta = src.ObjectMeta
+
+	// 1. 改名的字段
+	myDeployment.Spec.Image = src.Spec.Image
+	myDeployment.Spec.Replicas = src.Spec.Replicas
+	myDeployment.Spec.StartCmd = src.Spec.Command
+	myDeployment.Spec.Args = src.Spec.Args
+	myDeployment.Spec.Environments = src.Spec.Env
+
+	// 2. ports 拆分为 port 和 expose.servicePort
+	expose := &Expose{}
+	myDeployment.Spec.Port = 0
+	if len(src.Spec.Ports) != 0 {
+		myDeployment.Spec.Port = src.Spec.Ports[0].ContainerPort
+		expose.ServicePort = src.Spec.Ports[0].ServicePort
+	}
+	// 3. 各模式的配置合并到 expose 中，v2 中没有 expose 并且没有 servicePort 时 v1 中也不设置
+	if src.Spec.Expose != nil {
+		expose.Mode = src.Spec.Expose.Mode
+		if src.Spec.Expose.Ingress != nil {
+			expose.IngressDomain = src.Spec.Expose.Ingress.Domain
+			expose.Tls = src.Spec.Expose.Ingress.TLS != nil
+		}
+		if src.Spec.Expose.NodePort != nil {
+			expose.NodePort = src.Spec.Expose.NodePort.Port
+		}
+	}
+	// 恢复注解中保存的配置，v2 中的 expose 修改过时注解已经过期，忽略注解
+	restored := false
+	if data, ok := myDeployment.Annotations[AnnotationV1Expose]; ok {
+		delete(myDeployment.Annotations, AnnotationV1Expose)
+		saved := &Expose{}
+		if json.Unmarshal([]byte(data), saved) == nil {
+			if converted, _ := convertExposeToV2(saved); reflect.DeepEqual(converted, src.Spec.Expose) {
+				expose.Mode, expose.Tls = saved.Mode, saved.Tls
+				expose.IngressDomain, expose.NodePort = saved.IngressDomain, saved.NodePort
+				restored = true
+			}
+		}
+	}
+	myDeployment.Spec.Expose = nil
+	if src.Spec.Expose != nil || expose.ServicePort != 0 || restored {
+		myDeployment.Spec.Expose = expose
+	}
+
+	// 4. 结构相同的字段
+	myDeployment.Spec.Volumes = src.Spec.Volumes
+	myDeployment.Spec.VolumeMounts = src.Spec.VolumeMounts
+	myDeployment.Spec.ConfigFiles = src.Spec.ConfigFiles
+	myDeployment.Spec.ConfigFilesMountPath = src.Spec.ConfigFilesMountPath
+	myDeployment.Spec.Storage = (*Storage)(src.Spec.Storage)
+	myDeployment.Spec.Scheduling = (*Scheduling)(src.Spec.Scheduling)
+	myDeployment.Spec.SecurityContext = (*SecurityContext)(src.Spec.SecurityContext)
+	myDeployment.Spec.ServiceAccount = (*ServiceAccount)(src.Spec.ServiceAccount)
+	myDeployment.Spec.Canary = (*Canary)(src.Spec.Canary)
+	myDeployment.Spec.Strategy = nil
+	if src.Spec.Strategy != nil {
+		myDeployment.Spec.Strategy = &Strategy{
+			Type:      src.Spec.Strategy.Type,
+			BlueGreen: (*BlueGreenStrategy)(src.Spec.Strategy.BlueGreen),
+		}
+	}
+	myDeployment.Spec.Rollback = (*Rollback)(src.Spec.Rollback)
+	myDeployment.Spec.RevisionHistoryLimit = src.Spec.RevisionHistoryLimit
+	myDeployment.Spec.RollbackTo = src.Spec.RollbackTo
+
+	myDeployment.Status.Phase = src.Status.Phase
+	myDeployment.Status.Message = src.Status.Message
+	myDeployment.Status.Reason = src.Status.Reason
+	myDeployment.Status.ObservedGeneration = src.Status.ObservedGeneration
+	myDeployment.Status.Canary = (*CanaryStatus)(src.Status.Canary)
+	myDeployment.Status.ActiveColor = src.Status.ActiveColor
+	myDeployment.Status.PreviewColor = src.Status.PreviewColor
+	myDeployment.Status.Rollback = (*RollbackStatus)(src.Status.Rollback)
+	myDeployment.Status.CurrentRevision = src.Status.CurrentRevision
+	myDeployment.Status.UpdateRevision = src.Status.UpdateRevision
+	myDeployment.Status.Conditions = nil
+	for _, condition := range src.Status.Conditions {
+		myDeployment.Status.Conditions = append(myDeployment.Status.Conditions, Condition(condition))
+	}
+	return nil
+}
+
+// 把 v1 的 expose 转换为 v2，servicePort 已经合并到 ports 中，不在这里处理，
+// 返回的 lossy 为 true 时有 v2 中无法表示的配置：
+// 1. mode 为空时只在集群内部访问，v2 中不设置 expose，mode 必填，设置的 ingressDomain、tls 和 nodePort 无法保存
+// 2. 没有域名时开启的 tls，v2 中 tls 属于 ingress，ingress 的域名必填
+func convertExposeToV2(expose *Expose) (*v2.Expose, bool) {
+	if expose == nil || (expose.Mode == "" && expose.IngressDomain == "" && !expose.Tls && expose.NodePort == 0) {
+		return nil, false
+	}
+	if expose.Mode == "" {
+		return nil, true
+	}
+	dst := &v2.Expose{Mode: expose.Mode}
+	if expose.IngressDomain != "" {
+		dst.Ingress = &v2.IngressExpose{Domain: expose.IngressDomain}
+		if expose.Tls {
+			dst.Ingress.TLS = &v2.TLS{}
+		}
+	}
+	if expose.NodePort != 0 {
+		dst.NodePort = &v2.NodePortExpose{Port: expose.NodePort}
+	}
+	return dst, expose.Tls && expose.IngressDomain == ""
+}
@@ -0,0 +1,230 @@
+package v1
+
+import (
+	fuzz "github.com/google/gofuzz"
+	"k8s.io/apimachinery/pkg/api/apitesting/fuzzer"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metafuzzer "k8s.io/apimachinery/pkg/apis/meta/fuzzer"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	v2 "deployment/api/v2"
+)
+
+// 随机生成的对象需要满足 CRD 的校验，否则转换本身就是有损的
+// 1. v1 中空的 expose 和不设置 expose 等价，转换后为空；mode 只能为空、ingress 或 nodePort，
+// 为空时 ingressDomain、tls 和 nodePort 仍然可以设置，只是不生效
+// 2. v2 的 ports 只有一个元素，设置了 expose 时 mode 不会为空，ingress 的域名和 nodePort 的端口不会为空
+func conversionFuzzerFuncs(_ serializer.CodecFactory) []interface{} {
+	return []interface{}{
+		func(spec *MyDeploymentSpec, c fuzz.Continue) {
+			c.FuzzNoCustom(spec)
+			if spec.Expose == nil {
+				return
+			}
+			spec.Expose.Mode = []string{"", ModeIngress, ModeNodePort}[c.Intn(3)]
+			if spec.Expose.Mode == ModeIngress && spec.Expose.IngressDomain == "" {
+				spec.Expose.IngressDomain = "www.shudong-test.com"
+			}
+			if spec.Expose.Mode == ModeNodePort && spec.Expose.NodePort == 0 {
+				spec.Expose.NodePort = 30080
+			}
+			if *spec.Expose == (Expose{}) {
+				spec.Expose = nil
+			}
+		},
+		func(spec *v2.MyDeploymentSpec, c fuzz.Continue) {
+			c.FuzzNoCustom(spec)
+			port := v2.Port{}
+			c.Fuzz(&port)
+			spec.Ports = []v2.Port{port}
+			if spec.Expose == nil {
+				return
+			}
+			if spec.Expose.Mode == "" {
+				spec.Expose.Mode = v2.ModeIngress
+			}
+			if spec.Expose.Ingress != nil && spec.Expose.Ingress.Domain == "" {
+				spec.Expose.Ingress.Domain = "www.shudong-test.com"
+			}
+			if spec.Expose.NodePort != nil && spec.Expose.NodePort.Port == 0 {
+				spec.Expose.NodePort.Port = 30080
+			}
+		},
+	}
+}
+
+func checkV2Expose(t *testing.T, expose *v2.Expose) {
+	t.Helper()
+	if expose == nil {
+		return
+	}
+	if expose.Mode == "" {
+		t.Fatalf("v2 expose = %+v, want mode set", expose)
+	}
+	if expose.Ingress != nil && expose.Ingress.Domain == "" {
+		t.Fatalf("v2 expose ingress = %+v, want domain set", expose.Ingress)
+	}
+	if expose.NodePort != nil && expose.NodePort.Port == 0 {
+		t.Fatalf("v2 expose nodePort = %+v, want port set", expose.NodePort)
+	}
+}
+
+func newConversionFuzzer(t *testing.T, seed int64) *fuzz.Fuzzer {
+	scheme := runtime.NewScheme()
+	if err := AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v2.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	funcs := fuzzer.MergeFuzzerFuncs(metafuzzer.Funcs, conversionFuzzerFuncs)
+	return fuzzer.FuzzerFor(funcs, rand.NewSource(seed), serializer.NewCodecFactory(scheme))
+}
+
+func TestFuzzyConversion(t *testing.T) {
+	const rounds = 1000
+
+	t.Run("测试 v1 转换为 v2 再转换回 v1，结果不变", func(t *testing.T) {
+		f := newConversionFuzzer(t, 1)
+		for i := 0; i < rounds; i++ {
+			want := new(MyDeployment)
+			f.Fuzz(want)
+			hub := new(v2.MyDeployment)
+			if err := want.DeepCopy().ConvertTo(hub); err != nil {
+				t.Fatalf("ConvertTo() error = %v", err)
+			}
+			// 转换后的 v2 需要满足 v2 的校验，否则无法保存
+			checkV2Expose(t, hub.Spec.Expose)
+			got := new(MyDeployment)
+			if err := got.ConvertFrom(hub); err != nil {
+				t.Fatalf("ConvertFrom() error = %v", err)
+			}
+			if !equality.Semantic.DeepEqual(got, want) {
+				t.Fatalf("v1 -> v2 -> v1 mismatch:\n%s", diff.ObjectReflectDiff(want, got))
+			}
+		}
+	})
+
+	t.Run("测试 v2 转换为 v1 再转换回 v2，结果不变", func(t *testing.T) {
+		f := newConversionFuzzer(t, 2)
+		for i := 0; i < rounds; i++ {
+			want := new(v2.MyDeployment)
+			f.Fuzz(want)
+			spoke := new(MyDeployment)
+			if err := spoke.ConvertFrom(want.DeepCopy()); err != nil {
+				t.Fatalf("ConvertFrom() error = %v", err)
+			}
+			got := new(v2.MyDeployment)
+			if err := spoke.ConvertTo(got); err != nil {
+				t.Fatalf("ConvertTo() error = %v", err)
+			}
+			if !equality.Semantic.DeepEqual(got, want) {
+				t.Fatalf("v2 -> v1 -> v2 mismatch:\n%s", diff.ObjectReflectDiff(want, got))
+			}
+		}
+	})
+}
+
+func TestConvertExpose(t *testing.T) {
+	tests := []struct {
+		name   string
+		expose *Expose
+		// 期望转换后的 v2 expose 和注解
+		want           *v2.Expose
+		wantAnnotation string
+	}{
+		{
+			name:   "测试不设置 expose，v2 中也不设置",
+			expose: nil,
+			want:   nil,
+		},
+		{
+			name:   "测试只设置 servicePort，只在集群内部访问，v2 中不设置 expose",
+			expose: &Expose{ServicePort: 8080},
+			want:   nil,
+		},
+		{
+			name:   "测试 ingress 模式开启 tls，转换为 ingress 的配置",
+			expose: &Expose{Mode: ModeIngress, IngressDomain: "www.shudong-test.com", Tls: true},
+			want: &v2.Expose{Mode: ModeIngress, Ingress: &v2.IngressExpose{
+				Domain: "www.shudong-test.com", TLS: &v2.TLS{}}},
+		},
+		{
+			name:   "测试 nodePort 模式保留和模式不一致的 ingressDomain",
+			expose: &Expose{Mode: ModeNodePort, NodePort: 30080, IngressDomain: "www.shudong-test.com"},
+			want: &v2.Expose{Mode: ModeNodePort, NodePort: &v2.NodePortExpose{Port: 30080},
+				Ingress: &v2.IngressExpose{Domain: "www.shudong-test.com"}},
+		},
+		{
+			name:           "测试 mode 为空时设置 ingressDomain，只在集群内部访问，v2 中不设置 expose，配置保存在注解中",
+			expose:         &Expose{IngressDomain: "www.shudong-test.com"},
+			want:           nil,
+			wantAnnotation: `{"ingressDomain":"www.shudong-test.com"}`,
+		},
+		{
+			name:           "测试 mode 为空时只开启 tls，v2 中不生成没有域名的 ingress，配置保存在注解中",
+			expose:         &Expose{Tls: true, ServicePort: 8080},
+			want:           nil,
+			wantAnnotation: `{"tls":true}`,
+		},
+		{
+			name:           "测试 mode 为空时设置 nodePort，v2 中不设置 expose，配置保存在注解中",
+			expose:         &Expose{NodePort: 30080},
+			want:           nil,
+			wantAnnotation: `{"nodePort":30080}`,
+		},
+		{
+			name:           "测试 nodePort 模式开启 tls 但没有域名，tls 保存在注解中",
+			expose:         &Expose{Mode: ModeNodePort, NodePort: 30080, Tls: true},
+			want:           &v2.Expose{Mode: ModeNodePort, NodePort: &v2.NodePortExpose{Port: 30080}},
+			wantAnnotation: `{"mode":"nodePort","tls":true,"nodePort":30080}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := &MyDeployment{Spec: MyDeploymentSpec{Port: 80, Expose: tt.expose}}
+			hub := new(v2.MyDeployment)
+			if err := want.DeepCopy().ConvertTo(hub); err != nil {
+				t.Fatalf("ConvertTo() error = %v", err)
+			}
+			if !reflect.DeepEqual(hub.Spec.Expose, tt.want) {
+				t.Errorf("ConvertTo() expose = %+v, want %+v", hub.Spec.Expose, tt.want)
+			}
+			if got := hub.Annotations[AnnotationV1Expose]; got != tt.wantAnnotation {
+				t.Errorf("ConvertTo() annotation = %q, want %q", got, tt.wantAnnotation)
+			}
+			// 转换回 v1 后恢复原来的配置，不保留注解
+			got := new(MyDeployment)
+			if err := got.ConvertFrom(hub); err != nil {
+				t.Fatalf("ConvertFrom() error = %v", err)
+			}
+			if _, ok := got.Annotations[AnnotationV1Expose]; ok {
+				t.Errorf("ConvertFrom() annotations = %v, want no %s", got.Annotations, AnnotationV1Expose)
+			}
+			if !reflect.DeepEqual(got.Spec.Expose, tt.expose) {
+				t.Errorf("ConvertFrom() expose = %+v, want %+v", got.Spec.Expose, tt.expose)
+			}
+		})
+	}
+
+	// v2 中修改了 expose 后注解已经过期，转换为 v1 时使用 v2 中的配置
+	t.Run("测试 v2 中修改了 expose，忽略过期的注解", func(t *testing.T) {
+		hub := &v2.MyDeployment{}
+		hub.Annotations = map[string]string{AnnotationV1Expose: `{"ingressDomain":"www.shudong-test.com"}`}
+		hub.Spec.Ports = []v2.Port{{ContainerPort: 80}}
+		hub.Spec.Expose = &v2.Expose{Mode: ModeNodePort, NodePort: &v2.NodePortExpose{Port: 30080}}
+		got := new(MyDeployment)
+		if err := got.ConvertFrom(hub); err != nil {
+			t.Fatalf("ConvertFrom() error = %v", err)
+		}
+		want := &Expose{Mode: ModeNodePort, NodePort: 30080}
+		if !reflect.DeepEqual(got.Spec.Expose, want) {
+			t.Errorf("ConvertFrom() expose = %+v, want %+v", got.Spec.Expose, want)
+		}
+	})
+}
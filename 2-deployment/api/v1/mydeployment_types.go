@@ -17,9 +17,14 @@ limitations under the License.
 package v1
 
 import (
+	"fmt"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	"reflect"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -43,14 +48,202 @@ type MyDeploymentSpec struct {
 	// Environments 存储环境变量，直接使用 pod 中的定义方式
 	// +optional
 	Environments []corev1.EnvVar `json:"environments,omitempty"`
-	// Expose service 要暴露的端口
-	Expose *Expose `json:"expose"`
+	// Expose service 要暴露的端口，不填时只创建 ClusterIP 类型的 service，只能在集群内部访问
+	// +optional
+	Expose *Expose `json:"expose,omitempty"`
+	// Volumes 存储 pod 的卷定义，直接使用 pod 中的定义方式
+	// +optional
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+	// VolumeMounts 存储容器的挂载点，直接使用 container 中的定义方式
+	// +optional
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+	// ConfigFiles 存储配置文件，key 为文件名，value 为文件内容，
+	// 会生成一个 ConfigMap 并挂载到 ConfigFilesMountPath 目录下
+	// +optional
+	ConfigFiles map[string]string `json:"configFiles,omitempty"`
+	// ConfigFilesMountPath 配置文件的挂载目录，不填使用 /etc/config
+	// +optional
+	ConfigFilesMountPath string `json:"configFilesMountPath,omitempty"`
+	// Storage 持久化存储，会生成一个 PVC 并挂载
+	// +optional
+	Storage *Storage `json:"storage,omitempty"`
+	// Scheduling 存储 pod 的调度配置
+	// +optional
+	Scheduling *Scheduling `json:"scheduling,omitempty"`
+	// SecurityContext 存储 pod 和容器的安全配置
+	// +optional
+	SecurityContext *SecurityContext `json:"securityContext,omitempty"`
+	// ServiceAccount pod 使用的 ServiceAccount，不填使用 namespace 的 default
+	// +optional
+	ServiceAccount *ServiceAccount `json:"serviceAccount,omitempty"`
+	// Canary 金丝雀发布，设置后使用金丝雀镜像额外创建一个 Deployment，和当前版本同时提供服务
+	// +optional
+	Canary *Canary `json:"canary,omitempty"`
+	// Strategy 发布策略，不填使用 Deployment 的滚动更新
+	// +optional
+	Strategy *Strategy `json:"strategy,omitempty"`
+	// Rollback 自动回滚，不填时 Deployment 更新失败后保持失败的状态
+	// +optional
+	Rollback *Rollback `json:"rollback,omitempty"`
+	// RevisionHistoryLimit 保存的 spec 历史版本数量，不包括 currentRevision 和 updateRevision，不填为 10
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// RollbackTo 使用指定历史版本的 spec 生成子资源，版本号为 ControllerRevision 的 revision。
+	// 设置后 spec 中其他字段的修改不再生效，删除后恢复使用当前的 spec
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	RollbackTo *int64 `json:"rollbackTo,omitempty"`
+}
+
+// Rollback defines the desired state of Rollback
+type Rollback struct {
+	// Enabled 为 true 时，Deployment 超过 progressDeadlineSeconds 仍然没有完成更新，
+	// 回滚到最后一次全部就绪的 pod template，只在滚动更新时使用
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+	// ProgressDeadlineSeconds 设置到 Deployment 上，不填使用 Deployment 的默认值 600
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+}
+
+// Strategy defines the desired state of Strategy
+// +kubebuilder:validation:XValidation:rule="self.type == 'BlueGreen' || !has(self.blueGreen)",message="只有 `spec.strategy.type` 是 `BlueGreen` 时，才能设置 `spec.strategy.blueGreen`"
+type Strategy struct {
+	// Type 发布策略 RollingUpdate or BlueGreen。
+	// BlueGreen 维护 <name>-blue 和 <name>-green 两个 Deployment，service 只指向其中一个颜色，
+	// 新版本部署到另一个颜色，全部就绪后再切换，新旧版本不会同时接收流量
+	// +kubebuilder:validation:Enum=RollingUpdate;BlueGreen
+	Type string `json:"type"`
+	// BlueGreen 蓝绿发布的配置，只在 Type 为 BlueGreen 时使用
+	// +optional
+	BlueGreen *BlueGreenStrategy `json:"blueGreen,omitempty"`
+}
+
+// BlueGreenStrategy defines the desired state of BlueGreenStrategy
+type BlueGreenStrategy struct {
+	// AutoPromote 新版本的颜色全部就绪后是否自动切换，不填为 true。
+	// 为 false 时需要在 MyDeployment 上设置 apps.shudong.com/promote-color 注解，值为要切换到的颜色
+	// +optional
+	AutoPromote *bool `json:"autoPromote,omitempty"`
+}
+
+// Canary defines the desired state of Canary
+type Canary struct {
+	// Image 金丝雀版本的镜像
+	// +kubebuilder:validation:MinLength=1
+	Image string `json:"image"`
+	// Replicas 金丝雀 Deployment 的副本数，不填为 1。
+	// 金丝雀 pod 和当前版本的 pod 使用相同的 app 标签，主 service 按副本数的比例把流量分给金丝雀版本
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Weight 分配给金丝雀版本的流量百分比，只能在 ingress 模式下使用，
+	// 会额外生成带有 nginx canary-weight 注解的 Ingress，主 Ingress 的流量仍然按副本数分配
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	Weight *int32 `json:"weight,omitempty"`
+	// AnalysisDuration 金丝雀 Deployment 全部就绪后需要观察的时间，之后才能推广，不填时就绪后即可推广
+	// +optional
+	AnalysisDuration *metav1.Duration `json:"analysisDuration,omitempty"`
+	// Promote 不填时保持金丝雀发布；true 时推广，主 Deployment 使用金丝雀镜像，删除金丝雀的资源；
+	// false 时回滚，删除金丝雀的资源。完成后需要修改 spec.image 并删除 spec.canary
+	// +optional
+	Promote *bool `json:"promote,omitempty"`
+}
+
+// ServiceAccount defines the desired state of ServiceAccount
+// +kubebuilder:validation:XValidation:rule="(has(self.create) && self.create) || (has(self.name) && size(self.name) != 0)",message="如果 `spec.serviceAccount.create` 是 `false`，那么 `spec.serviceAccount.name` 不能为空"
+// +kubebuilder:validation:XValidation:rule="(has(self.create) && self.create) || !has(self.rules) || size(self.rules) == 0",message="只有 `spec.serviceAccount.create` 是 `true` 时，才能设置 `spec.serviceAccount.rules`"
+// +kubebuilder:validation:XValidation:rule="!(has(oldSelf.create) && oldSelf.create) || !(has(self.create) && self.create) || (has(self.name) == has(oldSelf.name) && (!has(self.name) || self.name == oldSelf.name))",message="生成的 ServiceAccount 不能改名，`spec.serviceAccount.name` 不可修改"
+type ServiceAccount struct {
+	// Name ServiceAccount 的名称，Create 为 false 时引用已经存在的 ServiceAccount，此项为必填；
+	// Create 为 true 时为生成的 ServiceAccount 的名称，不填使用 MyDeployment 的名称
+	// +optional
+	Name string `json:"name,omitempty"`
+	// Create 是否生成 ServiceAccount
+	// +optional
+	Create bool `json:"create,omitempty"`
+	// Rules 生成 ServiceAccount 时，同时生成 Role 和 RoleBinding 授予的权限，只能在 Create 为 true 时使用
+	// +optional
+	Rules []rbacv1.PolicyRule `json:"rules,omitempty"`
+	// AutomountServiceAccountToken 是否自动挂载 token，直接使用 pod 中的定义方式
+	// +optional
+	AutomountServiceAccountToken *bool `json:"automountServiceAccountToken,omitempty"`
+}
+
+// SecurityContext defines the desired state of SecurityContext
+type SecurityContext struct {
+	// Hardened 是否使用加固的默认配置，不填默认开启，满足 restricted Pod Security Standard：
+	// runAsNonRoot、禁止提权、丢弃所有 capabilities、只读根文件系统、seccomp 使用 RuntimeDefault。
+	// Pod 和 Container 中显式设置的字段优先于加固的默认配置
+	// +optional
+	Hardened *bool `json:"hardened,omitempty"`
+	// Pod pod 级别的安全配置，直接使用 pod 中的定义方式
+	// +optional
+	Pod *corev1.PodSecurityContext `json:"pod,omitempty"`
+	// Container 容器级别的安全配置，直接使用 container 中的定义方式
+	// +optional
+	Container *corev1.SecurityContext `json:"container,omitempty"`
+}
+
+// IsHardened 是否使用加固的默认安全配置，没有设置时默认开启
+func (securityContext *SecurityContext) IsHardened() bool {
+	if securityContext == nil || securityContext.Hardened == nil {
+		return true
+	}
+	return *securityContext.Hardened
+}
+
+// Scheduling defines the desired state of Scheduling
+type Scheduling struct {
+	// NodeSelector 节点选择器，直接使用 pod 中的定义方式
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations 容忍，直接使用 pod 中的定义方式
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// Affinity 亲和性，直接使用 pod 中的定义方式
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+	// PriorityClassName 优先级类名称
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// TopologySpreadConstraints 拓扑分布约束，直接使用 pod 中的定义方式
+	// +optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+}
+
+// Storage defines the desired state of Storage
+// PVC 的存储类和访问模式创建后不可修改，容量只能扩不能缩，
+// 下面的规则在 webhook 关闭时由 apiserver 保证
+// +kubebuilder:validation:XValidation:rule="quantity(string(self.size)).isGreaterThan(quantity('0'))",message="`spec.storage.size` 必须大于 0"
+// +kubebuilder:validation:XValidation:rule="quantity(string(self.size)).compareTo(quantity(string(oldSelf.size))) >= 0",message="`spec.storage.size` 只能扩容，不能缩小"
+// +kubebuilder:validation:XValidation:rule="has(self.storageClassName) == has(oldSelf.storageClassName) && (!has(self.storageClassName) || self.storageClassName == oldSelf.storageClassName)",message="`spec.storage.storageClassName` 不可修改"
+// +kubebuilder:validation:XValidation:rule="(has(self.accessModes) && size(self.accessModes) != 0 ? self.accessModes : ['ReadWriteOnce']) == (has(oldSelf.accessModes) && size(oldSelf.accessModes) != 0 ? oldSelf.accessModes : ['ReadWriteOnce'])",message="`spec.storage.accessModes` 不可修改"
+type Storage struct {
+	// Size 存储的大小，如 1Gi
+	Size resource.Quantity `json:"size"`
+	// MountPath 挂载到容器中的目录
+	MountPath string `json:"mountPath"`
+	// StorageClassName 存储类名称，不填使用集群默认的存储类
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// AccessModes 访问模式，不填使用 ReadWriteOnce
+	// +optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
 }
 
 // Expose defines the desired state of Expose
+// +kubebuilder:validation:XValidation:rule="!has(self.mode) || self.mode != 'ingress' || (has(self.ingressDomain) && size(self.ingressDomain) != 0)",message="如果 `spec.expose.mode` 是 `ingress`，那么 `spec.expose.ingressDomain` 不能为空"
+// +kubebuilder:validation:XValidation:rule="!has(self.mode) || self.mode != 'nodePort' || (has(self.nodePort) && self.nodePort >= 30000 && self.nodePort <= 32767)",message="如果 `spec.expose.mode` 是 `nodePort`，那么 `spec.expose.nodePort` 取值范围 `30000-32767`"
 type Expose struct {
-	// Mode 模式 nodePort or ingress
-	Mode string `json:"mode"`
+	// Mode 模式 nodePort or ingress，不填时只在集群内部访问，只使用 ServicePort
+	// +kubebuilder:validation:Enum=ingress;nodePort
+	// +optional
+	Mode string `json:"mode,omitempty"`
 	// Tls 是否开启https
 	// +optional
 	Tls bool `json:"tls,omitempty"`
@@ -79,6 +272,24 @@ type MyDeploymentStatus struct {
 	// Conditions 这个字段的子资源状态
 	// +optional
 	Conditions []Condition `json:"conditions,omitempty"`
+	// Canary 金丝雀发布的进度
+	// +optional
+	Canary *CanaryStatus `json:"canary,omitempty"`
+	// ActiveColor 蓝绿发布时 service 指向的颜色，为空表示还没有颜色全部就绪过
+	// +optional
+	ActiveColor string `json:"activeColor,omitempty"`
+	// PreviewColor 蓝绿发布时 preview service 指向的颜色，新版本部署在这个颜色上
+	// +optional
+	PreviewColor string `json:"previewColor,omitempty"`
+	// Rollback 自动回滚使用的 pod template 摘要
+	// +optional
+	Rollback *RollbackStatus `json:"rollback,omitempty"`
+	// CurrentRevision 子资源全部就绪的 spec 版本，为 ControllerRevision 的名称
+	// +optional
+	CurrentRevision string `json:"currentRevision,omitempty"`
+	// UpdateRevision 正在使用的 spec 版本，设置了 spec.rollbackTo 时为指定的历史版本
+	// +optional
+	UpdateRevision string `json:"updateRevision,omitempty"`
 
 	// ObservedGeneration 观测一次 Reconcile 产生的变化，如果有变化自加，最终判断是否变更，
 	// 如果变更，则请求 apiserver 真正的更新，否则不做任何更新
@@ -86,6 +297,26 @@ type MyDeploymentStatus struct {
 	ObservedGeneration int32 `json:"observedGeneration,omitempty"`
 }
 
+// CanaryStatus defines the observed state of Canary.
+type CanaryStatus struct {
+	// Image 正在观察的金丝雀镜像，镜像变化后重新开始观察
+	// +optional
+	Image string `json:"image,omitempty"`
+	// ReadyTime 金丝雀 Deployment 全部就绪的时间，观察时间从这里开始计算
+	// +optional
+	ReadyTime *metav1.Time `json:"readyTime,omitempty"`
+}
+
+// RollbackStatus defines the observed state of Rollback.
+type RollbackStatus struct {
+	// KnownGoodHash 最后一次全部就绪的 pod template 摘要，pod template 保存在 <name>-known-good-<摘要前 10 位> ControllerRevision 中
+	// +optional
+	KnownGoodHash string `json:"knownGoodHash,omitempty"`
+	// FailedHash 超过 progressDeadlineSeconds 后被回滚的 pod template 摘要，spec 没有变化时不会再次使用
+	// +optional
+	FailedHash string `json:"failedHash,omitempty"`
+}
+
 // Condition defines the observed state of Condition.
 type Condition struct {
 	// Type 子资源类型
@@ -105,6 +336,7 @@ type Condition struct {
 	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
 }
 
+// +genclient
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 
@@ -118,26 +350,268 @@ type MyDeployment struct {
 }
 
 func (myDeployment *MyDeployment) ValidateCreateAndUpdate() error {
+	return myDeployment.validateSpec().ToAggregate()
+}
+
+// ValidateUpdate 校验更新，除了和创建相同的校验外，拒绝会导致子资源无法更新或者残留的修改，
+// 对会造成服务中断的修改返回警告，允许更新但提示用户
+func (myDeployment *MyDeployment) ValidateUpdate(old *MyDeployment) ([]string, error) {
+	errs := myDeployment.validateSpec()
+	var warnings []string
+
+	// 1. PVC 的存储类和访问模式创建后不可修改，容量只能扩不能缩，
+	// 修改后 PVC 更新失败，新旧配置都不会生效
+	storage, oldStorage := myDeployment.Spec.Storage, old.Spec.Storage
+	storagePath := field.NewPath("spec", "storage")
+	if storage != nil && oldStorage != nil {
+		if !reflect.DeepEqual(storage.StorageClassName, oldStorage.StorageClassName) {
+			errs = append(errs, field.Forbidden(storagePath.Child("storageClassName"),
+				"`spec.storage.storageClassName` 不可修改"))
+		}
+		if !reflect.DeepEqual(storage.EffectiveAccessModes(), oldStorage.EffectiveAccessModes()) {
+			errs = append(errs, field.Forbidden(storagePath.Child("accessModes"),
+				"`spec.storage.accessModes` 不可修改"))
+		}
+		if storage.Size.Cmp(oldStorage.Size) < 0 {
+			errs = append(errs, field.Forbidden(storagePath.Child("size"),
+				fmt.Sprintf("`spec.storage.size` 只能扩容，不能从 %s 缩小到 %s",
+					oldStorage.Size.String(), storage.Size.String())))
+		}
+	}
+	// 2. PVC 不会随着 spec.storage 的删除而删除，数据保留
+	if storage == nil && oldStorage != nil {
+		warnings = append(warnings, "删除 `spec.storage` 后卷不再挂载，但 PersistentVolumeClaim 不会被删除，需要手动清理")
+	}
+
+	// 3. 生成的 ServiceAccount 改名后，旧的 ServiceAccount / Role / RoleBinding 会残留
+	serviceAccount, oldServiceAccount := myDeployment.Spec.ServiceAccount, old.Spec.ServiceAccount
+	if serviceAccount != nil && serviceAccount.Create && oldServiceAccount != nil && oldServiceAccount.Create &&
+		serviceAccount.Name != oldServiceAccount.Name {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "serviceAccount", "name"),
+			"生成的 ServiceAccount 不能改名，`spec.serviceAccount.name` 不可修改"))
+	}
+
+	// 4. 暴露方式的修改会导致访问地址变化，允许修改但给出警告
+	expose, oldExpose := myDeployment.Spec.Expose, old.Spec.Expose
+	mode, oldMode := myDeployment.Spec.ExposeMode(), old.Spec.ExposeMode()
+	switch {
+	case mode != oldMode && mode == "":
+		warnings = append(warnings, fmt.Sprintf(
+			"`spec.expose` 修改为只在集群内部访问，原有的访问方式 `%s` 会失效", oldMode))
+	case mode != oldMode && oldMode != "":
+		warnings = append(warnings, fmt.Sprintf(
+			"`spec.expose.mode` 从 `%s` 修改为 `%s`，原有的访问方式会失效", oldMode, mode))
+	case mode != oldMode:
+		// 从只在集群内部访问改为对外暴露，原有的访问方式不受影响
+	case mode == ModeIngress && expose.IngressDomain != oldExpose.IngressDomain:
+		warnings = append(warnings, fmt.Sprintf(
+			"`spec.expose.ingressDomain` 从 `%s` 修改为 `%s`，原有的域名会失效", oldExpose.IngressDomain, expose.IngressDomain))
+	case mode == ModeNodePort && expose.NodePort != oldExpose.NodePort:
+		warnings = append(warnings, fmt.Sprintf(
+			"`spec.expose.nodePort` 从 `%d` 修改为 `%d`，原有的端口会失效", oldExpose.NodePort, expose.NodePort))
+	}
+	if mode == ModeIngress && oldExpose != nil && expose.Tls != oldExpose.Tls {
+		if expose.Tls {
+			warnings = append(warnings, "开启 `spec.expose.tls`，证书签发完成前 https 无法访问")
+		} else {
+			warnings = append(warnings, "关闭 `spec.expose.tls`，https 访问会失效，已签发的证书和 Issuer 不会被删除")
+		}
+	}
+
+	// 5. 设置了 spec.rollbackTo 时子资源使用历史版本的 spec 生成，其他字段的修改不会生效
+	if rollbackTo, oldRollbackTo := myDeployment.Spec.RollbackTo, old.Spec.RollbackTo; rollbackTo != nil {
+		if oldRollbackTo == nil || *rollbackTo != *oldRollbackTo {
+			warnings = append(warnings, fmt.Sprintf(
+				"`spec.rollbackTo` 设置为 `%d`，子资源恢复为这个版本的 spec，删除 `spec.rollbackTo` 后才会使用当前的 spec", *rollbackTo))
+		} else if !reflect.DeepEqual(myDeployment.Spec, old.Spec) {
+			warnings = append(warnings, fmt.Sprintf(
+				"`spec.rollbackTo` 是 `%d`，本次修改在删除 `spec.rollbackTo` 之前不会生效", *rollbackTo))
+		}
+	}
+	return warnings, errs.ToAggregate()
+}
+
+// StableLabels 返回主 Deployment 的 pod 上的标签，app 加上 track=stable，不会选择到金丝雀 pod，
+// 主 Deployment 的 selector 和默认的拓扑分布约束都使用这组标签
+func (myDeployment *MyDeployment) StableLabels() map[string]string {
+	return map[string]string{
+		LabelKeyApp:   myDeployment.Name,
+		LabelKeyTrack: LabelValueStable,
+	}
+}
+
+// EffectiveAccessModes 返回 PVC 实际使用的访问模式，不填时为 ReadWriteOnce
+func (storage *Storage) EffectiveAccessModes() []corev1.PersistentVolumeAccessMode {
+	if len(storage.AccessModes) == 0 {
+		return []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+	}
+	return storage.AccessModes
+}
+
+// ExposeMode 返回服务的暴露方式，没有设置 spec.expose 或者 mode 时返回空，只在集群内部访问
+func (spec *MyDeploymentSpec) ExposeMode() string {
+	if spec.Expose == nil {
+		return ""
+	}
+	return spec.Expose.Mode
+}
+
+// ServicePort 返回 service 的端口，没有设置 spec.expose.servicePort 时使用 spec.port
+func (spec *MyDeploymentSpec) ServicePort() int32 {
+	if spec.Expose == nil || spec.Expose.ServicePort == 0 {
+		return spec.Port
+	}
+	return spec.Expose.ServicePort
+}
+
+// TLSEnabled ingress 模式并且开启了 https
+func (spec *MyDeploymentSpec) TLSEnabled() bool {
+	return spec.ExposeMode() == ModeIngress && spec.Expose.Tls
+}
+
+// DeploymentImage 返回主 Deployment 使用的镜像，金丝雀推广后使用金丝雀镜像
+func (spec *MyDeploymentSpec) DeploymentImage() string {
+	if spec.Canary != nil && spec.Canary.Promote != nil && *spec.Canary.Promote {
+		return spec.Canary.Image
+	}
+	return spec.Image
+}
+
+// CanaryInProgress 设置了 spec.canary，并且还没有推广或者回滚
+func (spec *MyDeploymentSpec) CanaryInProgress() bool {
+	return spec.Canary != nil && spec.Canary.Promote == nil
+}
+
+// EffectiveReplicas 返回金丝雀 Deployment 的副本数，不填时为 1
+func (canary *Canary) EffectiveReplicas() int32 {
+	if canary.Replicas == nil {
+		return 1
+	}
+	return *canary.Replicas
+}
+
+// IsBlueGreen 使用蓝绿发布
+func (spec *MyDeploymentSpec) IsBlueGreen() bool {
+	return spec.Strategy != nil && spec.Strategy.Type == StrategyBlueGreen
+}
+
+// AutoPromote 蓝绿发布时新版本的颜色全部就绪后是否自动切换，不填为 true
+func (strategy *Strategy) AutoPromote() bool {
+	if strategy.BlueGreen == nil || strategy.BlueGreen.AutoPromote == nil {
+		return true
+	}
+	return *strategy.BlueGreen.AutoPromote
+}
+
+// EffectiveRevisionHistoryLimit 返回保存的 spec 历史版本数量，不填时为 10
+func (spec *MyDeploymentSpec) EffectiveRevisionHistoryLimit() int32 {
+	if spec.RevisionHistoryLimit == nil {
+		return 10
+	}
+	return *spec.RevisionHistoryLimit
+}
+
+// RollbackEnabled 开启了自动回滚
+func (spec *MyDeploymentSpec) RollbackEnabled() bool {
+	return spec.Rollback != nil && spec.Rollback.Enabled
+}
+
+func (myDeployment *MyDeployment) validateSpec() field.ErrorList {
 	// 定义错误切片，在后续出现错误的时候，不断的向其中追加，最后合并返回
 	errs := field.ErrorList{}
 	exposePath := field.NewPath("spec", "expose")
-	// 1. 传入的 spec.expose.mode 值是否为 ingress 或 nodeport
-	if myDeployment.Spec.Expose.Mode != ModeIngress && myDeployment.Spec.Expose.Mode != ModeNodePort {
+	// 1. 传入的 spec.expose.mode 值是否为 ingress 或 nodeport，不填时只在集群内部访问
+	mode := myDeployment.Spec.ExposeMode()
+	if mode != "" && mode != ModeIngress && mode != ModeNodePort {
 		errs = append(errs, field.NotSupported(exposePath,
-			myDeployment.Spec.Expose.Mode, []string{ModeIngress, ModeNodePort}))
+			mode, []string{ModeIngress, ModeNodePort}))
 	}
 	// 2. 如果 spec.expose.mode 是 ingress，那么 spec.expose.ingressDomain 不能为空
-	if myDeployment.Spec.Expose.Mode == ModeIngress && myDeployment.Spec.Expose.IngressDomain == "" {
-		errs = append(errs, field.Invalid(exposePath, myDeployment.Spec.Expose.Mode,
+	if mode == ModeIngress && myDeployment.Spec.Expose.IngressDomain == "" {
+		errs = append(errs, field.Invalid(exposePath, mode,
 			"如果 `spec.expose.mode` 是 `ingress`，那么 `spec.expose.ingressDomain` 不能为空"))
 	}
 	// 3. 如果 spec.expose.mode 是 nodeport，那么 spec.expose.nodePort 取值范围 30000-32767
-	if myDeployment.Spec.Expose.Mode == ModeNodePort &&
+	if mode == ModeNodePort &&
 		(myDeployment.Spec.Expose.NodePort < 30000 || myDeployment.Spec.Expose.NodePort > 32767) {
-		errs = append(errs, field.Invalid(exposePath, myDeployment.Spec.Expose.Mode,
+		errs = append(errs, field.Invalid(exposePath, mode,
 			"如果 `spec.expose.mode` 是 `nodeport`，那么 `spec.expose.nodePort` 取值范围 `30000-32767`"))
 	}
-	return errs.ToAggregate()
+	// 4. spec.configFiles 的 key 会作为 ConfigMap 的 key 和文件名，必须合法
+	configFilesPath := field.NewPath("spec", "configFiles")
+	for key := range myDeployment.Spec.ConfigFiles {
+		for _, msg := range validation.IsConfigMapKey(key) {
+			errs = append(errs, field.Invalid(configFilesPath.Key(key), key, msg))
+		}
+	}
+	// 5. 如果设置了 spec.storage，那么 size 必须大于 0，mountPath 不能为空
+	if myDeployment.Spec.Storage != nil {
+		storagePath := field.NewPath("spec", "storage")
+		if myDeployment.Spec.Storage.Size.Sign() <= 0 {
+			errs = append(errs, field.Invalid(storagePath.Child("size"),
+				myDeployment.Spec.Storage.Size.String(), "`spec.storage.size` 必须大于 0"))
+		}
+		if myDeployment.Spec.Storage.MountPath == "" {
+			errs = append(errs, field.Required(storagePath.Child("mountPath"),
+				"如果设置了 `spec.storage`，那么 `spec.storage.mountPath` 不能为空"))
+		}
+	}
+	// 6. 如果设置了 spec.serviceAccount，引用已有的 ServiceAccount 时 name 不能为空，rules 只能在生成时使用
+	if myDeployment.Spec.ServiceAccount != nil {
+		serviceAccountPath := field.NewPath("spec", "serviceAccount")
+		serviceAccount := myDeployment.Spec.ServiceAccount
+		if !serviceAccount.Create && serviceAccount.Name == "" {
+			errs = append(errs, field.Required(serviceAccountPath.Child("name"),
+				"如果 `spec.serviceAccount.create` 是 `false`，那么 `spec.serviceAccount.name` 不能为空"))
+		}
+		if !serviceAccount.Create && len(serviceAccount.Rules) != 0 {
+			errs = append(errs, field.Forbidden(serviceAccountPath.Child("rules"),
+				"只有 `spec.serviceAccount.create` 是 `true` 时，才能设置 `spec.serviceAccount.rules`"))
+		}
+		if serviceAccount.Name != "" {
+			for _, msg := range validation.IsDNS1123Subdomain(serviceAccount.Name) {
+				errs = append(errs, field.Invalid(serviceAccountPath.Child("name"), serviceAccount.Name, msg))
+			}
+		}
+	}
+	// 7. 用户自定义的卷不能和生成的卷重名
+	volumesPath := field.NewPath("spec", "volumes")
+	for i, volume := range myDeployment.Spec.Volumes {
+		if volume.Name == VolumeNameConfigFiles || volume.Name == VolumeNameStorage {
+			errs = append(errs, field.Invalid(volumesPath.Index(i).Child("name"), volume.Name,
+				"卷名称被保留，不能使用"))
+		}
+	}
+	// 8. 如果设置了 spec.canary，金丝雀镜像不能为空，weight 依赖 nginx 的 canary 注解，只能在 ingress 模式下使用
+	if myDeployment.Spec.Canary != nil {
+		canaryPath := field.NewPath("spec", "canary")
+		if myDeployment.Spec.Canary.Image == "" {
+			errs = append(errs, field.Required(canaryPath.Child("image"),
+				"如果设置了 `spec.canary`，那么 `spec.canary.image` 不能为空"))
+		}
+		if myDeployment.Spec.Canary.Weight != nil && mode != ModeIngress {
+			errs = append(errs, field.Forbidden(canaryPath.Child("weight"),
+				"只有 `spec.expose.mode` 是 `ingress` 时，才能设置 `spec.canary.weight`"))
+		}
+	}
+	// 9. 蓝绿发布时 service 只指向一个颜色，金丝雀 pod 无法接收流量，不能同时使用
+	if myDeployment.Spec.Strategy != nil {
+		strategyPath := field.NewPath("spec", "strategy")
+		if myDeployment.Spec.Strategy.Type != StrategyBlueGreen && myDeployment.Spec.Strategy.BlueGreen != nil {
+			errs = append(errs, field.Forbidden(strategyPath.Child("blueGreen"),
+				"只有 `spec.strategy.type` 是 `BlueGreen` 时，才能设置 `spec.strategy.blueGreen`"))
+		}
+		if myDeployment.Spec.IsBlueGreen() && myDeployment.Spec.Canary != nil {
+			errs = append(errs, field.Forbidden(field.NewPath("spec", "canary"),
+				"`spec.strategy.type` 是 `BlueGreen` 时，不能设置 `spec.canary`"))
+		}
+	}
+	// 10. 蓝绿发布时新版本就绪后才会切换，失败的版本不会接收流量，不需要自动回滚
+	if myDeployment.Spec.IsBlueGreen() && myDeployment.Spec.RollbackEnabled() {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "rollback", "enabled"),
+			"`spec.strategy.type` 是 `BlueGreen` 时，不能开启 `spec.rollback.enabled`"))
+	}
+	return errs
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,4 @@
+package v2
+
+// Hub v2 是存储版本，其他版本都和 v2 互相转换
+func (*MyDeployment) Hub() {}
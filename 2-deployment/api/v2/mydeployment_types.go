@@ -0,0 +1,387 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ModeIngress  = "ingress"
+	ModeNodePort = "nodePort"
+)
+
+// MyDeploymentSpec defines the desired state of MyDeployment.
+// 和 v1 相比：
+// 1. startCmd、environments 改名为 command、env，和 pod 中的定义保持一致
+// 2. port 和 expose.servicePort 合并为 ports，为以后支持多个端口预留
+// 3. 不同模式的配置拆分到 expose.ingress 和 expose.nodePort 中，
+// tls 由布尔值改为结构体，为以后增加证书相关的配置预留
+type MyDeploymentSpec struct {
+	// Image 存储镜像地址
+	Image string `json:"image"`
+	// Replicas 存储要部署多少个副本
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+	// Command 存储启动命令
+	// +optional
+	Command []string `json:"command,omitempty"`
+	// Args 存储启动命令参数
+	// +optional
+	Args []string `json:"args,omitempty"`
+	// Env 存储环境变量，直接使用 pod 中的定义方式
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// Ports 存储服务提供的端口，目前只支持一个端口
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=1
+	Ports []Port `json:"ports"`
+	// Expose 服务的暴露方式，不填时只创建 ClusterIP 类型的 service，只能在集群内部访问
+	// +optional
+	Expose *Expose `json:"expose,omitempty"`
+	// Volumes 存储 pod 的卷定义，直接使用 pod 中的定义方式
+	// +optional
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+	// VolumeMounts 存储容器的挂载点，直接使用 container 中的定义方式
+	// +optional
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+	// ConfigFiles 存储配置文件，key 为文件名，value 为文件内容，
+	// 会生成一个 ConfigMap 并挂载到 ConfigFilesMountPath 目录下
+	// +optional
+	ConfigFiles map[string]string `json:"configFiles,omitempty"`
+	// ConfigFilesMountPath 配置文件的挂载目录，不填使用 /etc/config
+	// +optional
+	ConfigFilesMountPath string `json:"configFilesMountPath,omitempty"`
+	// Storage 持久化存储，会生成一个 PVC 并挂载
+	// +optional
+	Storage *Storage `json:"storage,omitempty"`
+	// Scheduling 存储 pod 的调度配置
+	// +optional
+	Scheduling *Scheduling `json:"scheduling,omitempty"`
+	// SecurityContext 存储 pod 和容器的安全配置
+	// +optional
+	SecurityContext *SecurityContext `json:"securityContext,omitempty"`
+	// ServiceAccount pod 使用的 ServiceAccount，不填使用 namespace 的 default
+	// +optional
+	ServiceAccount *ServiceAccount `json:"serviceAccount,omitempty"`
+	// Canary 金丝雀发布，设置后使用金丝雀镜像额外创建一个 Deployment，和当前版本同时提供服务
+	// +optional
+	Canary *Canary `json:"canary,omitempty"`
+	// Strategy 发布策略，不填使用 Deployment 的滚动更新
+	// +optional
+	Strategy *Strategy `json:"strategy,omitempty"`
+	// Rollback 自动回滚，不填时 Deployment 更新失败后保持失败的状态
+	// +optional
+	Rollback *Rollback `json:"rollback,omitempty"`
+	// RevisionHistoryLimit 保存的 spec 历史版本数量，不包括 currentRevision 和 updateRevision，不填为 10
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// RollbackTo 使用指定历史版本的 spec 生成子资源，版本号为 ControllerRevision 的 revision。
+	// 设置后 spec 中其他字段的修改不再生效，删除后恢复使用当前的 spec
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	RollbackTo *int64 `json:"rollbackTo,omitempty"`
+}
+
+// Rollback defines the desired state of Rollback
+type Rollback struct {
+	// Enabled 为 true 时，Deployment 超过 progressDeadlineSeconds 仍然没有完成更新，
+	// 回滚到最后一次全部就绪的 pod template，只在滚动更新时使用
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+	// ProgressDeadlineSeconds 设置到 Deployment 上，不填使用 Deployment 的默认值 600
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+}
+
+// Strategy defines the desired state of Strategy
+// +kubebuilder:validation:XValidation:rule="self.type == 'BlueGreen' || !has(self.blueGreen)",message="只有 `spec.strategy.type` 是 `BlueGreen` 时，才能设置 `spec.strategy.blueGreen`"
+type Strategy struct {
+	// Type 发布策略 RollingUpdate or BlueGreen。
+	// BlueGreen 维护 <name>-blue 和 <name>-green 两个 Deployment，service 只指向其中一个颜色，
+	// 新版本部署到另一个颜色，全部就绪后再切换，新旧版本不会同时接收流量
+	// +kubebuilder:validation:Enum=RollingUpdate;BlueGreen
+	Type string `json:"type"`
+	// BlueGreen 蓝绿发布的配置，只在 Type 为 BlueGreen 时使用
+	// +optional
+	BlueGreen *BlueGreenStrategy `json:"blueGreen,omitempty"`
+}
+
+// BlueGreenStrategy defines the desired state of BlueGreenStrategy
+type BlueGreenStrategy struct {
+	// AutoPromote 新版本的颜色全部就绪后是否自动切换，不填为 true。
+	// 为 false 时需要在 MyDeployment 上设置 apps.shudong.com/promote-color 注解，值为要切换到的颜色
+	// +optional
+	AutoPromote *bool `json:"autoPromote,omitempty"`
+}
+
+// Canary defines the desired state of Canary
+type Canary struct {
+	// Image 金丝雀版本的镜像
+	// +kubebuilder:validation:MinLength=1
+	Image string `json:"image"`
+	// Replicas 金丝雀 Deployment 的副本数，不填为 1。
+	// 金丝雀 pod 和当前版本的 pod 使用相同的 app 标签，主 service 按副本数的比例把流量分给金丝雀版本
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Weight 分配给金丝雀版本的流量百分比，只能在 ingress 模式下使用，
+	// 会额外生成带有 nginx canary-weight 注解的 Ingress，主 Ingress 的流量仍然按副本数分配
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	Weight *int32 `json:"weight,omitempty"`
+	// AnalysisDuration 金丝雀 Deployment 全部就绪后需要观察的时间，之后才能推广，不填时就绪后即可推广
+	// +optional
+	AnalysisDuration *metav1.Duration `json:"analysisDuration,omitempty"`
+	// Promote 不填时保持金丝雀发布；true 时推广，主 Deployment 使用金丝雀镜像，删除金丝雀的资源；
+	// false 时回滚，删除金丝雀的资源。完成后需要修改 spec.image 并删除 spec.canary
+	// +optional
+	Promote *bool `json:"promote,omitempty"`
+}
+
+// Port defines the desired state of Port
+type Port struct {
+	// ContainerPort 容器监听的端口
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	ContainerPort int32 `json:"containerPort"`
+	// ServicePort service 的端口，不填使用 ContainerPort
+	// +optional
+	ServicePort int32 `json:"servicePort,omitempty"`
+}
+
+// Expose defines the desired state of Expose
+// +kubebuilder:validation:XValidation:rule="self.mode != 'ingress' || has(self.ingress)",message="如果 `spec.expose.mode` 是 `ingress`，那么 `spec.expose.ingress` 不能为空"
+// +kubebuilder:validation:XValidation:rule="self.mode != 'nodePort' || has(self.nodePort)",message="如果 `spec.expose.mode` 是 `nodePort`，那么 `spec.expose.nodePort` 不能为空"
+type Expose struct {
+	// Mode 模式 nodePort or ingress
+	// +kubebuilder:validation:Enum=ingress;nodePort
+	Mode string `json:"mode"`
+	// Ingress ingress 模式的配置，在 Mode 为 ingress 的时候，此项为必填
+	// +optional
+	Ingress *IngressExpose `json:"ingress,omitempty"`
+	// NodePort nodePort 模式的配置，在 Mode 为 nodePort 的时候，此项为必填
+	// +optional
+	NodePort *NodePortExpose `json:"nodePort,omitempty"`
+}
+
+// IngressExpose defines the desired state of IngressExpose
+type IngressExpose struct {
+	// Domain 域名
+	// +kubebuilder:validation:MinLength=1
+	Domain string `json:"domain"`
+	// TLS 设置后开启 https，证书由 cert-manager 签发
+	// +optional
+	TLS *TLS `json:"tls,omitempty"`
+}
+
+// TLS defines the desired state of TLS
+// 目前没有可配置的字段，设置为 {} 即开启 https
+type TLS struct {
+}
+
+// NodePortExpose defines the desired state of NodePortExpose
+type NodePortExpose struct {
+	// Port nodePort 端口
+	// +kubebuilder:validation:Minimum=30000
+	// +kubebuilder:validation:Maximum=32767
+	Port int32 `json:"port"`
+}
+
+// ServiceAccount defines the desired state of ServiceAccount
+// +kubebuilder:validation:XValidation:rule="(has(self.create) && self.create) || (has(self.name) && size(self.name) != 0)",message="如果 `spec.serviceAccount.create` 是 `false`，那么 `spec.serviceAccount.name` 不能为空"
+// +kubebuilder:validation:XValidation:rule="(has(self.create) && self.create) || !has(self.rules) || size(self.rules) == 0",message="只有 `spec.serviceAccount.create` 是 `true` 时，才能设置 `spec.serviceAccount.rules`"
+// +kubebuilder:validation:XValidation:rule="!(has(oldSelf.create) && oldSelf.create) || !(has(self.create) && self.create) || (has(self.name) == has(oldSelf.name) && (!has(self.name) || self.name == oldSelf.name))",message="生成的 ServiceAccount 不能改名，`spec.serviceAccount.name` 不可修改"
+type ServiceAccount struct {
+	// Name ServiceAccount 的名称，Create 为 false 时引用已经存在的 ServiceAccount，此项为必填；
+	// Create 为 true 时为生成的 ServiceAccount 的名称，不填使用 MyDeployment 的名称
+	// +optional
+	Name string `json:"name,omitempty"`
+	// Create 是否生成 ServiceAccount
+	// +optional
+	Create bool `json:"create,omitempty"`
+	// Rules 生成 ServiceAccount 时，同时生成 Role 和 RoleBinding 授予的权限，只能在 Create 为 true 时使用
+	// +optional
+	Rules []rbacv1.PolicyRule `json:"rules,omitempty"`
+	// AutomountServiceAccountToken 是否自动挂载 token，直接使用 pod 中的定义方式
+	// +optional
+	AutomountServiceAccountToken *bool `json:"automountServiceAccountToken,omitempty"`
+}
+
+// SecurityContext defines the desired state of SecurityContext
+type SecurityContext struct {
+	// Hardened 是否使用加固的默认配置，不填默认开启，满足 restricted Pod Security Standard：
+	// runAsNonRoot、禁止提权、丢弃所有 capabilities、只读根文件系统、seccomp 使用 RuntimeDefault。
+	// Pod 和 Container 中显式设置的字段优先于加固的默认配置
+	// +optional
+	Hardened *bool `json:"hardened,omitempty"`
+	// Pod pod 级别的安全配置，直接使用 pod 中的定义方式
+	// +optional
+	Pod *corev1.PodSecurityContext `json:"pod,omitempty"`
+	// Container 容器级别的安全配置，直接使用 container 中的定义方式
+	// +optional
+	Container *corev1.SecurityContext `json:"container,omitempty"`
+}
+
+// Scheduling defines the desired state of Scheduling
+type Scheduling struct {
+	// NodeSelector 节点选择器，直接使用 pod 中的定义方式
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations 容忍，直接使用 pod 中的定义方式
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// Affinity 亲和性，直接使用 pod 中的定义方式
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+	// PriorityClassName 优先级类名称
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// TopologySpreadConstraints 拓扑分布约束，直接使用 pod 中的定义方式
+	// +optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+}
+
+// Storage defines the desired state of Storage
+// PVC 的存储类和访问模式创建后不可修改，容量只能扩不能缩，
+// 下面的规则在 webhook 关闭时由 apiserver 保证
+// +kubebuilder:validation:XValidation:rule="quantity(string(self.size)).isGreaterThan(quantity('0'))",message="`spec.storage.size` 必须大于 0"
+// +kubebuilder:validation:XValidation:rule="quantity(string(self.size)).compareTo(quantity(string(oldSelf.size))) >= 0",message="`spec.storage.size` 只能扩容，不能缩小"
+// +kubebuilder:validation:XValidation:rule="has(self.storageClassName) == has(oldSelf.storageClassName) && (!has(self.storageClassName) || self.storageClassName == oldSelf.storageClassName)",message="`spec.storage.storageClassName` 不可修改"
+// +kubebuilder:validation:XValidation:rule="(has(self.accessModes) && size(self.accessModes) != 0 ? self.accessModes : ['ReadWriteOnce']) == (has(oldSelf.accessModes) && size(oldSelf.accessModes) != 0 ? oldSelf.accessModes : ['ReadWriteOnce'])",message="`spec.storage.accessModes` 不可修改"
+type Storage struct {
+	// Size 存储的大小，如 1Gi
+	Size resource.Quantity `json:"size"`
+	// MountPath 挂载到容器中的目录
+	MountPath string `json:"mountPath"`
+	// StorageClassName 存储类名称，不填使用集群默认的存储类
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// AccessModes 访问模式，不填使用 ReadWriteOnce
+	// +optional
+	AccessModes []corev1.PersistentVolumeAccessMode `json:"accessModes,omitempty"`
+}
+
+// MyDeploymentStatus defines the observed state of MyDeployment.
+type MyDeploymentStatus struct {
+	// Phase 处于什么阶段
+	// +optional
+	Phase string `json:"phase,omitempty"`
+	// Message 这个阶段的信息
+	// +optional
+	Message string `json:"message,omitempty"`
+	// Reason 处于这个阶段的原因
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Conditions 这个字段的子资源状态
+	// +optional
+	Conditions []Condition `json:"conditions,omitempty"`
+	// Canary 金丝雀发布的进度
+	// +optional
+	Canary *CanaryStatus `json:"canary,omitempty"`
+	// ActiveColor 蓝绿发布时 service 指向的颜色，为空表示还没有颜色全部就绪过
+	// +optional
+	ActiveColor string `json:"activeColor,omitempty"`
+	// PreviewColor 蓝绿发布时 preview service 指向的颜色，新版本部署在这个颜色上
+	// +optional
+	PreviewColor string `json:"previewColor,omitempty"`
+	// Rollback 自动回滚使用的 pod template 摘要
+	// +optional
+	Rollback *RollbackStatus `json:"rollback,omitempty"`
+	// CurrentRevision 子资源全部就绪的 spec 版本，为 ControllerRevision 的名称
+	// +optional
+	CurrentRevision string `json:"currentRevision,omitempty"`
+	// UpdateRevision 正在使用的 spec 版本，设置了 spec.rollbackTo 时为指定的历史版本
+	// +optional
+	UpdateRevision string `json:"updateRevision,omitempty"`
+
+	// ObservedGeneration 观测一次 Reconcile 产生的变化，如果有变化自加，最终判断是否变更，
+	// 如果变更，则请求 apiserver 真正的更新，否则不做任何更新
+	// +optional
+	ObservedGeneration int32 `json:"observedGeneration,omitempty"`
+}
+
+// CanaryStatus defines the observed state of Canary.
+type CanaryStatus struct {
+	// Image 正在观察的金丝雀镜像，镜像变化后重新开始观察
+	// +optional
+	Image string `json:"image,omitempty"`
+	// ReadyTime 金丝雀 Deployment 全部就绪的时间，观察时间从这里开始计算
+	// +optional
+	ReadyTime *metav1.Time `json:"readyTime,omitempty"`
+}
+
+// RollbackStatus defines the observed state of Rollback.
+type RollbackStatus struct {
+	// KnownGoodHash 最后一次全部就绪的 pod template 摘要，pod template 保存在 <name>-known-good-<摘要前 10 位> ControllerRevision 中
+	// +optional
+	KnownGoodHash string `json:"knownGoodHash,omitempty"`
+	// FailedHash 超过 progressDeadlineSeconds 后被回滚的 pod template 摘要，spec 没有变化时不会再次使用
+	// +optional
+	FailedHash string `json:"failedHash,omitempty"`
+}
+
+// Condition defines the observed state of Condition.
+type Condition struct {
+	// Type 子资源类型
+	// +optional
+	Type string `json:"type,omitempty"`
+	// Message 这个子资源状态的信息
+	// +optional
+	Message string `json:"message,omitempty"`
+	// Status 这个子资源的状态名称
+	// +optional
+	Status string `json:"status,omitempty"`
+	// Reason 处于这个状态的原因
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// LastTransitionTime 最后创建 / 更新的时间
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+
+// MyDeployment is the Schema for the mydeployments API.
+type MyDeployment struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MyDeploymentSpec   `json:"spec,omitempty"`
+	Status MyDeploymentStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MyDeploymentList contains a list of MyDeployment.
+type MyDeploymentList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MyDeployment `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MyDeployment{}, &MyDeploymentList{})
+}
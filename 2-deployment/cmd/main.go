@@ -21,6 +21,7 @@ import (
 	"flag"
 	"k8s.io/client-go/dynamic"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -37,6 +38,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	appsv1 "deployment/api/v1"
+	appsv2 "deployment/api/v2"
 	"deployment/internal/controller"
 	webhookappsv1 "deployment/internal/webhook/v1"
 	// +kubebuilder:scaffold:imports
@@ -51,6 +53,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(appsv1.AddToScheme(scheme))
+	utilruntime.Must(appsv2.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -60,6 +63,10 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var defaultTopologySpread bool
+	var imageRequireDigest bool
+	var imageForbidLatest bool
+	var imageAllowedRegistries string
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -71,6 +78,16 @@ func main() {
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.BoolVar(&defaultTopologySpread, "default-topology-spread", false,
+		"If set, the defaulting webhook spreads replicas across zones and nodes "+
+			"when replicas > 1 and no topology spread constraints are given.")
+	flag.BoolVar(&imageRequireDigest, "image-require-digest", false,
+		"If set, the validating webhook only admits images referenced by digest.")
+	flag.BoolVar(&imageForbidLatest, "image-forbid-latest", false,
+		"If set, the validating webhook rejects images tagged latest or without a tag.")
+	flag.StringVar(&imageAllowedRegistries, "image-allowed-registries", "",
+		"Comma separated list of registries (optionally with a path prefix, e.g. registry.example.com/team) "+
+			"images must come from. Empty allows any registry.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -149,13 +166,29 @@ func main() {
 		Scheme: mgr.GetScheme(),
 		// https 1. 创建动态 client
 		DynamicClient: dynamic.NewForConfigOrDie(ctrl.GetConfigOrDie()),
+		// 不经过缓存读取 pod，只在 Deployment 未就绪时使用，避免缓存集群中所有的 pod
+		APIReader: mgr.GetAPIReader(),
+		// 自动回滚时在 MyDeployment 上产生事件
+		Recorder: mgr.GetEventRecorderFor("mydeployment-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "MyDeployment")
 		os.Exit(1)
 	}
 	// nolint:goconst
+	// CRD 的存储版本是 v2，并且使用 webhook 转换，关闭准入 webhook 时也要提供 /convert，否则无法读写 v1
+	if err = ctrl.NewWebhookManagedBy(mgr).For(&appsv2.MyDeployment{}).Complete(); err != nil {
+		setupLog.Error(err, "unable to create conversion webhook", "webhook", "MyDeployment")
+		os.Exit(1)
+	}
 	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
-		if err = webhookappsv1.SetupMyDeploymentWebhookWithManager(mgr); err != nil {
+		if err = webhookappsv1.SetupMyDeploymentWebhookWithManager(mgr, webhookappsv1.Options{
+			DefaultTopologySpread: defaultTopologySpread,
+			ImagePolicy: webhookappsv1.ImagePolicy{
+				RequireDigest:     imageRequireDigest,
+				ForbidLatest:      imageForbidLatest,
+				AllowedRegistries: splitList(imageAllowedRegistries),
+			},
+		}); err != nil {
 			setupLog.Error(err, "unable to create webhook", "webhook", "MyDeployment")
 			os.Exit(1)
 		}
@@ -177,3 +210,14 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// 按逗号分割命令行参数，去掉空白和空项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,49 @@
+#!/usr/bin/env bash
+
+# 生成 apps.shudong.com/v1 的 typed clientset、lister 和 informer 到 pkg/client
+# 使用方法：make generate-client，或者在项目根目录执行 hack/update-codegen.sh
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+SCRIPT_ROOT=$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)
+cd "${SCRIPT_ROOT}"
+
+LOCALBIN=${LOCALBIN:-${SCRIPT_ROOT}/bin}
+CODE_GENERATOR_VERSION=${CODE_GENERATOR_VERSION:-v0.31.0}
+MODULE=deployment
+OUTPUT_PKG=${MODULE}/pkg/client
+OUTPUT_DIR=pkg/client
+HEADER=hack/boilerplate.go.txt
+
+for gen in client-gen lister-gen informer-gen; do
+  if [ ! -x "${LOCALBIN}/${gen}" ]; then
+    GOBIN="${LOCALBIN}" go install "k8s.io/code-generator/cmd/${gen}@${CODE_GENERATOR_VERSION}"
+  fi
+done
+
+rm -rf "${OUTPUT_DIR}/clientset" "${OUTPUT_DIR}/listers" "${OUTPUT_DIR}/informers"
+
+# api/v1 没有 group 目录，group 的包名取 +groupName 的第一段，也就是 apps
+"${LOCALBIN}/client-gen" \
+  --go-header-file "${HEADER}" \
+  --clientset-name versioned \
+  --input-base "" \
+  --input "${MODULE}/api/v1" \
+  --output-pkg "${OUTPUT_PKG}/clientset" \
+  --output-dir "${OUTPUT_DIR}/clientset"
+
+"${LOCALBIN}/lister-gen" \
+  --go-header-file "${HEADER}" \
+  --output-pkg "${OUTPUT_PKG}/listers" \
+  --output-dir "${OUTPUT_DIR}/listers" \
+  "${MODULE}/api/v1"
+
+"${LOCALBIN}/informer-gen" \
+  --go-header-file "${HEADER}" \
+  --versioned-clientset-package "${OUTPUT_PKG}/clientset/versioned" \
+  --listers-package "${OUTPUT_PKG}/listers" \
+  --output-pkg "${OUTPUT_PKG}/informers" \
+  --output-dir "${OUTPUT_DIR}/informers" \
+  "${MODULE}/api/v1"
@@ -0,0 +1,793 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	myApiV1 "deployment/api/v1"
+	"fmt"
+	appsV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	networkingV1 "k8s.io/api/networking/v1"
+	rbacV1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"time"
+)
+
+// ChildResource MyDeployment 生成的一种子资源，每种子资源对应一个 condition，
+// 由 reconcileChild 统一完成 获取 / 不存在时创建 / 存在时更新 / 不需要时删除 / 设置 condition 的流程
+type ChildResource interface {
+	// ConditionType 子资源对应的 condition 类型，为空时不设置 condition，
+	// 用于状态合并到其他 condition 中的子资源，比如 Role、金丝雀的 Service，由调用方设置 condition
+	ConditionType() string
+	// Object 只含有名称和 namespace 的空对象，用来获取和删除已有的对象
+	Object(myDeployment *myApiV1.MyDeployment) client.Object
+	// ShouldExist 根据 spec 判断是否需要这个子资源，不需要时删除已有的对象和 condition
+	ShouldExist(myDeployment *myApiV1.MyDeployment) bool
+	// Build 生成期望的对象，只在 ShouldExist 为 true 时调用
+	Build(ctx context.Context, myDeployment *myApiV1.MyDeployment) (client.Object, error)
+	// Equal 判断已有的对象是否和期望的相同，相同时不需要更新，
+	// 可以修改 desired，比如预更新后得到 apiserver 填充了默认值的对象，用来执行真正的更新
+	Equal(ctx context.Context, desired, existing client.Object) (bool, error)
+	// IsReady 判断对象是否就绪，返回 condition 的信息和原因，刚创建时 existing 为创建的对象
+	IsReady(ctx context.Context, myDeployment *myApiV1.MyDeployment, existing client.Object) (ready bool, message, reason string)
+}
+
+// 不需要时可以保留已有对象的子资源，Retain 返回 true 时只删除 condition，
+// 比如 pvc 防止误操作丢失数据，切换发布方式的过程中保留原来的 Deployment 继续提供服务
+type retainedChild interface {
+	Retain(myDeployment *myApiV1.MyDeployment) bool
+}
+
+// 同步一种子资源的结果
+type childResult struct {
+	// desired 期望的对象，不需要这个子资源时为 nil
+	desired client.Object
+	// existing 已有的对象，刚创建时为创建的对象，不需要这个子资源时为 nil
+	existing client.Object
+	// updated 本次是否更新了已有的对象
+	updated bool
+	// ready 子资源是否就绪
+	ready bool
+}
+
+// 同步一种子资源，并更新对应的 condition
+// 1. 不需要时删除 md 拥有的对象，删除 condition，retainedChild 需要保留时只删除 condition
+// 2. 需要时创建或者更新，根据 IsReady 设置 condition，出错时 condition 为 False，原因为 <ConditionType>NotReady
+func (r *MyDeploymentReconciler) reconcileChild(ctx context.Context, myDeployment *myApiV1.MyDeployment, child ChildResource) (childResult, error) {
+	result := childResult{}
+	// 1. 不需要这个子资源
+	if !child.ShouldExist(myDeployment) {
+		if retained, ok := child.(retainedChild); !ok || !retained.Retain(myDeployment) {
+			err := r.syncOwnedObject(ctx, myDeployment, false, child.Object(myDeployment), child.Object(myDeployment), nil)
+			if err != nil {
+				return result, err
+			}
+		}
+		r.deleteStatus(myDeployment, child.ConditionType())
+		return result, nil
+	}
+
+	// 2. 创建或者更新
+	existing := child.Object(myDeployment)
+	// 和 ConditionReason<ConditionType>NotReady 常量的命名相同
+	notReadyReason := child.ConditionType() + "NotReady"
+	failed := func(err error) (childResult, error) {
+		if child.ConditionType() == "" {
+			return result, err
+		}
+		kind := child.ConditionType()
+		if gvk, gvkErr := apiutil.GVKForObject(existing, r.Scheme); gvkErr == nil {
+			kind = gvk.Kind
+		}
+		r.updateConditions(myDeployment, child.ConditionType(),
+			fmt.Sprintf("%s %s, err: %s", kind, existing.GetName(), err.Error()),
+			myApiV1.ConditionStatusFalse, notReadyReason)
+		return result, err
+	}
+	desired, err := child.Build(ctx, myDeployment)
+	if err != nil {
+		return failed(err)
+	}
+	result.desired = desired
+	var equalErr error
+	err = r.syncOwnedObject(ctx, myDeployment, true, desired, existing, func(prev client.Object) bool {
+		var equal bool
+		equal, equalErr = child.Equal(ctx, desired, prev)
+		result.updated = equalErr == nil && !equal
+		// 比较出错时不更新，返回比较的错误
+		return equalErr != nil || equal
+	})
+	if err == nil {
+		err = equalErr
+	}
+	if err != nil {
+		return failed(err)
+	}
+	// 刚创建的对象，Get 没有获取到，使用创建后的对象
+	if existing.GetResourceVersion() == "" {
+		existing = desired
+	}
+	result.existing = existing
+
+	// 3. 设置 condition
+	ready, message, reason := child.IsReady(ctx, myDeployment, existing)
+	result.ready = ready
+	if child.ConditionType() == "" {
+		return result, nil
+	}
+	status := myApiV1.ConditionStatusFalse
+	if ready {
+		status = myApiV1.ConditionStatusTrue
+	}
+	r.updateConditions(myDeployment, child.ConditionType(), message, status, reason)
+	return result, nil
+}
+
+// 同步多种子资源，先处理不需要的，再同步需要的，多种子资源使用同一个 condition 时，由需要的子资源设置，
+// 比如蓝绿发布时不需要滚动更新的 Deployment，由 active 颜色的 Deployment 设置 Deployment condition
+// 需要的子资源按照参数的顺序同步，后面的子资源可以依赖前面的子资源的结果，返回的结果和参数的顺序一致
+func (r *MyDeploymentReconciler) reconcileChildren(ctx context.Context, myDeployment *myApiV1.MyDeployment,
+	children ...ChildResource) ([]childResult, error) {
+	results := make([]childResult, len(children))
+	var needed []int
+	for i, child := range children {
+		if child.ShouldExist(myDeployment) {
+			needed = append(needed, i)
+			continue
+		}
+		_, err := r.reconcileChild(ctx, myDeployment, child)
+		if err != nil {
+			return results, err
+		}
+	}
+	for _, i := range needed {
+		result, err := r.reconcileChild(ctx, myDeployment, children[i])
+		results[i] = result
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// 预更新，得到 apiserver 填充了默认值的对象，再和已有的对象比较 spec
+func dryRunEqual(ctx context.Context, c client.Client, desired, existing client.Object, spec func(client.Object) any) (bool, error) {
+	err := c.Update(ctx, desired, client.DryRunAll)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(spec(desired), spec(existing)), nil
+}
+
+// ============ deployment ===============
+
+// 滚动更新使用的 Deployment，蓝绿发布时不需要，第一次切换到 active 颜色之前保留
+type deploymentChild struct {
+	reconciler        *MyDeploymentReconciler
+	referenceChecksum string
+	// rolledBack Build 时是否使用了最后一次全部就绪的 pod template
+	rolledBack bool
+}
+
+func (d *deploymentChild) ConditionType() string {
+	return myApiV1.ConditionTypeDeployment
+}
+
+func (d *deploymentChild) Object(myDeployment *myApiV1.MyDeployment) client.Object {
+	return &appsV1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: myDeployment.Name, Namespace: myDeployment.Namespace}}
+}
+
+func (d *deploymentChild) ShouldExist(myDeployment *myApiV1.MyDeployment) bool {
+	return !myDeployment.Spec.IsBlueGreen()
+}
+
+// 切换到蓝绿发布后，service 选择 active 颜色之前继续提供服务
+func (d *deploymentChild) Retain(myDeployment *myApiV1.MyDeployment) bool {
+	return myDeployment.Spec.IsBlueGreen() && myDeployment.Status.ActiveColor == ""
+}
+
+func (d *deploymentChild) Build(ctx context.Context, myDeployment *myApiV1.MyDeployment) (client.Object, error) {
+	// rollback 1. 期望的 pod template 已经被回滚过时，继续使用最后一次全部就绪的 pod template
+	deployment, rolledBack, err := d.reconciler.desiredDeployment(ctx, myDeployment, d.referenceChecksum)
+	if err != nil {
+		return nil, err
+	}
+	d.rolledBack = rolledBack
+	return &deployment, nil
+}
+
+func (d *deploymentChild) Equal(ctx context.Context, desired, existing client.Object) (bool, error) {
+	// selector 创建后不可修改，之前创建的 Deployment 的 selector 中没有 track 标签，保留原来的 selector，
+	// pod template 中多出的 track 标签仍然满足原来的 selector
+	desiredDeployment, prevDeployment := desired.(*appsV1.Deployment), existing.(*appsV1.Deployment)
+	if prevDeployment.Spec.Selector != nil {
+		desiredDeployment.Spec.Selector = prevDeployment.Spec.Selector.DeepCopy()
+	}
+	return dryRunEqual(ctx, d.reconciler.Client, desired, existing, func(obj client.Object) any {
+		return obj.(*appsV1.Deployment).Spec
+	})
+}
+
+func (d *deploymentChild) IsReady(ctx context.Context, myDeployment *myApiV1.MyDeployment, existing client.Object) (bool, string, string) {
+	deployment := existing.(*appsV1.Deployment)
+	if *deployment.Spec.Replicas == deployment.Status.ReadyReplicas {
+		// bluegreen 2. 从蓝绿发布切换回滚动更新，Deployment 就绪后清空颜色，不再保留蓝绿发布的资源
+		myDeployment.Status.ActiveColor = ""
+		myDeployment.Status.PreviewColor = ""
+		return true, fmt.Sprintf(myApiV1.ConditionMessageDeploymentOKFmt, deployment.Name), myApiV1.ConditionReasonDeploymentReady
+	}
+	// 读取 pod 的状态，用来给出拉取镜像失败等具体的原因，读取失败时只使用 Deployment 的状态
+	pods, err := d.reconciler.listPods(ctx, myDeployment)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "list pods failed")
+	}
+	message, reason := deploymentNotReadyMessage(deployment, pods)
+	return false, message, reason
+}
+
+// ============ service ===============
+
+type serviceChild struct {
+	client client.Client
+}
+
+func (s *serviceChild) ConditionType() string {
+	return myApiV1.ConditionTypeService
+}
+
+func (s *serviceChild) Object(myDeployment *myApiV1.MyDeployment) client.Object {
+	return &coreV1.Service{ObjectMeta: metav1.ObjectMeta{Name: myDeployment.Name, Namespace: myDeployment.Namespace}}
+}
+
+func (s *serviceChild) ShouldExist(*myApiV1.MyDeployment) bool {
+	return true
+}
+
+func (s *serviceChild) Build(_ context.Context, myDeployment *myApiV1.MyDeployment) (client.Object, error) {
+	service := NewService(myDeployment)
+	return &service, nil
+}
+
+func (s *serviceChild) Equal(ctx context.Context, desired, existing client.Object) (bool, error) {
+	return dryRunEqual(ctx, s.client, desired, existing, func(obj client.Object) any {
+		return obj.(*coreV1.Service).Spec
+	})
+}
+
+func (s *serviceChild) IsReady(_ context.Context, _ *myApiV1.MyDeployment, existing client.Object) (bool, string, string) {
+	return true, fmt.Sprintf(myApiV1.ConditionMessageServiceOKFmt, existing.GetName()), myApiV1.ConditionReasonServiceReady
+}
+
+// ============ ingress ===============
+
+// ingress 模式下的 Ingress，nodePort 模式或者只在集群内部访问时不需要
+type ingressChild struct {
+	client client.Client
+}
+
+func (i *ingressChild) ConditionType() string {
+	return myApiV1.ConditionTypeIngress
+}
+
+func (i *ingressChild) Object(myDeployment *myApiV1.MyDeployment) client.Object {
+	return &networkingV1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: myDeployment.Name, Namespace: myDeployment.Namespace}}
+}
+
+func (i *ingressChild) ShouldExist(myDeployment *myApiV1.MyDeployment) bool {
+	return myDeployment.Spec.ExposeMode() == myApiV1.ModeIngress
+}
+
+func (i *ingressChild) Build(_ context.Context, myDeployment *myApiV1.MyDeployment) (client.Object, error) {
+	ingress := NewIngress(myDeployment)
+	return &ingress, nil
+}
+
+func (i *ingressChild) Equal(ctx context.Context, desired, existing client.Object) (bool, error) {
+	return dryRunEqual(ctx, i.client, desired, existing, func(obj client.Object) any {
+		return obj.(*networkingV1.Ingress).Spec
+	})
+}
+
+func (i *ingressChild) IsReady(_ context.Context, _ *myApiV1.MyDeployment, existing client.Object) (bool, string, string) {
+	return true, fmt.Sprintf(myApiV1.ConditionMessageIngressOKFmt, existing.GetName()), myApiV1.ConditionReasonIngressReady
+}
+
+// ============ configmap ===============
+
+// configFiles 生成的 ConfigMap，没有设置 configFiles 时不需要
+type configMapChild struct{}
+
+func (c *configMapChild) ConditionType() string {
+	return myApiV1.ConditionTypeConfigMap
+}
+
+func (c *configMapChild) Object(myDeployment *myApiV1.MyDeployment) client.Object {
+	return &coreV1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: configMapName(myDeployment), Namespace: myDeployment.Namespace}}
+}
+
+func (c *configMapChild) ShouldExist(myDeployment *myApiV1.MyDeployment) bool {
+	return len(myDeployment.Spec.ConfigFiles) != 0
+}
+
+func (c *configMapChild) Build(_ context.Context, myDeployment *myApiV1.MyDeployment) (client.Object, error) {
+	configMap := NewConfigMap(myDeployment)
+	return &configMap, nil
+}
+
+// ConfigMap 没有 spec，直接比较数据
+func (c *configMapChild) Equal(_ context.Context, desired, existing client.Object) (bool, error) {
+	return reflect.DeepEqual(desired.(*coreV1.ConfigMap).Data, existing.(*coreV1.ConfigMap).Data), nil
+}
+
+func (c *configMapChild) IsReady(_ context.Context, _ *myApiV1.MyDeployment, existing client.Object) (bool, string, string) {
+	return true, fmt.Sprintf(myApiV1.ConditionMessageConfigMapOKFmt, existing.GetName()), myApiV1.ConditionReasonConfigMapReady
+}
+
+// ============ pvc ===============
+
+// spec.storage 生成的 PersistentVolumeClaim，删除 spec.storage 后保留，随着 MyDeployment 的删除被回收
+type pvcChild struct{}
+
+func (p *pvcChild) Retain(*myApiV1.MyDeployment) bool {
+	return true
+}
+
+func (p *pvcChild) ConditionType() string {
+	return myApiV1.ConditionTypeStorage
+}
+
+func (p *pvcChild) Object(myDeployment *myApiV1.MyDeployment) client.Object {
+	return &coreV1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+		Name: persistentVolumeClaimName(myDeployment), Namespace: myDeployment.Namespace}}
+}
+
+func (p *pvcChild) ShouldExist(myDeployment *myApiV1.MyDeployment) bool {
+	return myDeployment.Spec.Storage != nil
+}
+
+func (p *pvcChild) Build(_ context.Context, myDeployment *myApiV1.MyDeployment) (client.Object, error) {
+	pvc := NewPersistentVolumeClaim(myDeployment)
+	return &pvc, nil
+}
+
+// pvc 创建后 spec 基本不可修改，这里只处理扩容，需要存储类支持 allowVolumeExpansion，
+// 需要扩容时把 desired 替换为修改了容量的已有对象，用来执行更新
+func (p *pvcChild) Equal(_ context.Context, desired, existing client.Object) (bool, error) {
+	desiredPVC := desired.(*coreV1.PersistentVolumeClaim)
+	size := desiredPVC.Spec.Resources.Requests[coreV1.ResourceStorage]
+	prev := existing.(*coreV1.PersistentVolumeClaim)
+	if prev.Spec.Resources.Requests.Storage().Cmp(size) >= 0 {
+		return true, nil
+	}
+	// 防止污染缓存
+	pvc := prev.DeepCopy()
+	if pvc.Spec.Resources.Requests == nil {
+		pvc.Spec.Resources.Requests = coreV1.ResourceList{}
+	}
+	pvc.Spec.Resources.Requests[coreV1.ResourceStorage] = size
+	*desiredPVC = *pvc
+	return false, nil
+}
+
+func (p *pvcChild) IsReady(_ context.Context, _ *myApiV1.MyDeployment, existing client.Object) (bool, string, string) {
+	if existing.(*coreV1.PersistentVolumeClaim).Status.Phase == coreV1.ClaimBound {
+		return true, fmt.Sprintf(myApiV1.ConditionMessageStorageOKFmt, existing.GetName()), myApiV1.ConditionReasonStorageReady
+	}
+	return false, fmt.Sprintf(myApiV1.ConditionMessageStorageNotOKFmt, existing.GetName()), myApiV1.ConditionReasonStorageNotReady
+}
+
+// ============ serviceaccount ===============
+
+// spec.serviceAccount.create 为 true 时生成的 ServiceAccount
+type serviceAccountChild struct{}
+
+func (s *serviceAccountChild) ConditionType() string {
+	return myApiV1.ConditionTypeServiceAccount
+}
+
+func (s *serviceAccountChild) Object(myDeployment *myApiV1.MyDeployment) client.Object {
+	return &coreV1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name: serviceAccountName(myDeployment), Namespace: myDeployment.Namespace}}
+}
+
+func (s *serviceAccountChild) ShouldExist(myDeployment *myApiV1.MyDeployment) bool {
+	return myDeployment.Spec.ServiceAccount != nil && myDeployment.Spec.ServiceAccount.Create
+}
+
+func (s *serviceAccountChild) Build(_ context.Context, myDeployment *myApiV1.MyDeployment) (client.Object, error) {
+	serviceAccount := NewServiceAccount(myDeployment)
+	return &serviceAccount, nil
+}
+
+func (s *serviceAccountChild) Equal(_ context.Context, desired, existing client.Object) (bool, error) {
+	return reflect.DeepEqual(desired.(*coreV1.ServiceAccount).AutomountServiceAccountToken,
+		existing.(*coreV1.ServiceAccount).AutomountServiceAccountToken), nil
+}
+
+func (s *serviceAccountChild) IsReady(_ context.Context, _ *myApiV1.MyDeployment, existing client.Object) (bool, string, string) {
+	return true, fmt.Sprintf(myApiV1.ConditionMessageServiceAccountOKFmt, existing.GetName()), myApiV1.ConditionReasonServiceAccountReady
+}
+
+// 根据 spec.serviceAccount.rules 生成的 Role，状态合并到 ServiceAccount condition
+type roleChild struct{}
+
+func (r *roleChild) ConditionType() string {
+	return ""
+}
+
+func (r *roleChild) Object(myDeployment *myApiV1.MyDeployment) client.Object {
+	return &rbacV1.Role{ObjectMeta: metav1.ObjectMeta{Name: serviceAccountName(myDeployment), Namespace: myDeployment.Namespace}}
+}
+
+func (r *roleChild) ShouldExist(myDeployment *myApiV1.MyDeployment) bool {
+	return serviceAccountWithRules(myDeployment)
+}
+
+func (r *roleChild) Build(_ context.Context, myDeployment *myApiV1.MyDeployment) (client.Object, error) {
+	role := NewRole(myDeployment)
+	return &role, nil
+}
+
+func (r *roleChild) Equal(_ context.Context, desired, existing client.Object) (bool, error) {
+	return reflect.DeepEqual(desired.(*rbacV1.Role).Rules, existing.(*rbacV1.Role).Rules), nil
+}
+
+func (r *roleChild) IsReady(context.Context, *myApiV1.MyDeployment, client.Object) (bool, string, string) {
+	return true, "", ""
+}
+
+// 把 Role 绑定到 ServiceAccount 的 RoleBinding，状态合并到 ServiceAccount condition
+type roleBindingChild struct{}
+
+func (r *roleBindingChild) ConditionType() string {
+	return ""
+}
+
+func (r *roleBindingChild) Object(myDeployment *myApiV1.MyDeployment) client.Object {
+	return &rbacV1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: serviceAccountName(myDeployment), Namespace: myDeployment.Namespace}}
+}
+
+func (r *roleBindingChild) ShouldExist(myDeployment *myApiV1.MyDeployment) bool {
+	return serviceAccountWithRules(myDeployment)
+}
+
+func (r *roleBindingChild) Build(_ context.Context, myDeployment *myApiV1.MyDeployment) (client.Object, error) {
+	roleBinding := NewRoleBinding(myDeployment)
+	return &roleBinding, nil
+}
+
+// roleRef 创建后不可修改，名称固定，所以只比较 subjects
+func (r *roleBindingChild) Equal(_ context.Context, desired, existing client.Object) (bool, error) {
+	return reflect.DeepEqual(desired.(*rbacV1.RoleBinding).Subjects, existing.(*rbacV1.RoleBinding).Subjects), nil
+}
+
+func (r *roleBindingChild) IsReady(context.Context, *myApiV1.MyDeployment, client.Object) (bool, string, string) {
+	return true, "", ""
+}
+
+func serviceAccountWithRules(myDeployment *myApiV1.MyDeployment) bool {
+	serviceAccount := myDeployment.Spec.ServiceAccount
+	return serviceAccount != nil && serviceAccount.Create && len(serviceAccount.Rules) != 0
+}
+
+// ============ canary ===============
+
+// 金丝雀发布进行中时的金丝雀 Deployment，就绪状态决定 Canary condition 和 status.canary
+type canaryDeploymentChild struct {
+	client            client.Client
+	referenceChecksum string
+}
+
+func (c *canaryDeploymentChild) ConditionType() string {
+	return myApiV1.ConditionTypeCanary
+}
+
+func (c *canaryDeploymentChild) Object(myDeployment *myApiV1.MyDeployment) client.Object {
+	return &appsV1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: canaryName(myDeployment), Namespace: myDeployment.Namespace}}
+}
+
+func (c *canaryDeploymentChild) ShouldExist(myDeployment *myApiV1.MyDeployment) bool {
+	return myDeployment.Spec.CanaryInProgress()
+}
+
+func (c *canaryDeploymentChild) Build(_ context.Context, myDeployment *myApiV1.MyDeployment) (client.Object, error) {
+	deployment := NewCanaryDeployment(myDeployment, c.referenceChecksum)
+	return &deployment, nil
+}
+
+func (c *canaryDeploymentChild) Equal(ctx context.Context, desired, existing client.Object) (bool, error) {
+	return dryRunEqual(ctx, c.client, desired, existing, func(obj client.Object) any {
+		return obj.(*appsV1.Deployment).Spec
+	})
+}
+
+// 根据金丝雀发布的进度设置 status.canary，只有通过分析后才就绪
+func (c *canaryDeploymentChild) IsReady(_ context.Context, myDeployment *myApiV1.MyDeployment, existing client.Object) (bool, string, string) {
+	canaryStatus, message, status, reason := canaryProgress(myDeployment, existing.(*appsV1.Deployment), time.Now())
+	myDeployment.Status.Canary = canaryStatus
+	return status == myApiV1.ConditionStatusTrue, message, reason
+}
+
+// ingress 模式下设置了 weight 时，指向金丝雀 pod 的 Service，状态合并到 Canary condition
+type canaryServiceChild struct{}
+
+func (c *canaryServiceChild) ConditionType() string {
+	return ""
+}
+
+func (c *canaryServiceChild) Object(myDeployment *myApiV1.MyDeployment) client.Object {
+	return &coreV1.Service{ObjectMeta: metav1.ObjectMeta{Name: canaryName(myDeployment), Namespace: myDeployment.Namespace}}
+}
+
+func (c *canaryServiceChild) ShouldExist(myDeployment *myApiV1.MyDeployment) bool {
+	return canaryWithIngress(myDeployment)
+}
+
+func (c *canaryServiceChild) Build(_ context.Context, myDeployment *myApiV1.MyDeployment) (client.Object, error) {
+	service := NewCanaryService(myDeployment)
+	return &service, nil
+}
+
+func (c *canaryServiceChild) Equal(_ context.Context, desired, existing client.Object) (bool, error) {
+	return serviceSelectorAndPortsEqual(desired, existing), nil
+}
+
+func (c *canaryServiceChild) IsReady(context.Context, *myApiV1.MyDeployment, client.Object) (bool, string, string) {
+	return true, "", ""
+}
+
+// ingress 模式下设置了 weight 时，按权重分配流量的 nginx 金丝雀 Ingress，状态合并到 Canary condition
+type canaryIngressChild struct{}
+
+func (c *canaryIngressChild) ConditionType() string {
+	return ""
+}
+
+func (c *canaryIngressChild) Object(myDeployment *myApiV1.MyDeployment) client.Object {
+	return &networkingV1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: canaryName(myDeployment), Namespace: myDeployment.Namespace}}
+}
+
+func (c *canaryIngressChild) ShouldExist(myDeployment *myApiV1.MyDeployment) bool {
+	return canaryWithIngress(myDeployment)
+}
+
+func (c *canaryIngressChild) Build(_ context.Context, myDeployment *myApiV1.MyDeployment) (client.Object, error) {
+	ingress := NewCanaryIngress(myDeployment)
+	return &ingress, nil
+}
+
+func (c *canaryIngressChild) Equal(_ context.Context, desired, existing client.Object) (bool, error) {
+	desiredIngress, prevIngress := desired.(*networkingV1.Ingress), existing.(*networkingV1.Ingress)
+	return reflect.DeepEqual(desiredIngress.Annotations, prevIngress.Annotations) &&
+		reflect.DeepEqual(desiredIngress.Spec.Rules, prevIngress.Spec.Rules), nil
+}
+
+func (c *canaryIngressChild) IsReady(context.Context, *myApiV1.MyDeployment, client.Object) (bool, string, string) {
+	return true, "", ""
+}
+
+func canaryWithIngress(myDeployment *myApiV1.MyDeployment) bool {
+	return myDeployment.Spec.CanaryInProgress() && myDeployment.Spec.Canary.Weight != nil &&
+		myDeployment.Spec.ExposeMode() == myApiV1.ModeIngress
+}
+
+// ============ bluegreen ===============
+
+// 蓝绿发布的子资源共享的状态，在 active 和 preview 两个 Deployment 之间传递发布的进度
+// active 颜色来自 status，还没有 active 颜色时先部署 blue，preview 为另一个颜色
+type blueGreen struct {
+	referenceChecksum string
+	// hash 期望的 pod template 的摘要
+	hash         string
+	activeColor  string
+	previewColor string
+	// established 开始同步前 service 是否已经选择了 active 颜色
+	established bool
+	// rollout active 的摘要和期望的不同，新版本部署到 preview，由 active 的 Equal 设置
+	rollout bool
+}
+
+func newBlueGreen(myDeployment *myApiV1.MyDeployment, referenceChecksum string) *blueGreen {
+	deployment := NewDeployment(myDeployment, referenceChecksum)
+	activeColor := myDeployment.Status.ActiveColor
+	if activeColor == "" {
+		activeColor = myApiV1.ColorBlue
+	}
+	return &blueGreen{
+		referenceChecksum: referenceChecksum,
+		hash:              templateHash(&deployment.Spec.Template),
+		activeColor:       activeColor,
+		previewColor:      otherColor(activeColor),
+		established:       myDeployment.Status.ActiveColor != "",
+	}
+}
+
+// 切换回滚动更新后，滚动更新的 Deployment 就绪之前，service 仍然选择 active 颜色，保留蓝绿发布的资源
+func blueGreenRetain(myDeployment *myApiV1.MyDeployment) bool {
+	return !myDeployment.Spec.IsBlueGreen() && myDeployment.Status.ActiveColor != ""
+}
+
+// 通过注解上的摘要判断 pod template 是否变化，不需要预更新
+func blueGreenDeploymentEqual(desired, existing client.Object) bool {
+	desiredDeployment, prevDeployment := desired.(*appsV1.Deployment), existing.(*appsV1.Deployment)
+	return desiredDeployment.Annotations[myApiV1.AnnotationTemplateHash] == prevDeployment.Annotations[myApiV1.AnnotationTemplateHash] &&
+		reflect.DeepEqual(desiredDeployment.Spec.Replicas, prevDeployment.Spec.Replicas)
+}
+
+// active 颜色的 Deployment，设置 Deployment condition
+// 1. 摘要和期望的相同，或者还没有 active 颜色时，同步 active，第一次全部就绪后记录 active 颜色
+// 2. 摘要不同，开始新的发布，active 保持不变，继续提供服务，Deployment condition 仍然根据 active 是否就绪设置
+type blueGreenActiveChild struct {
+	*blueGreen
+}
+
+func (a *blueGreenActiveChild) ConditionType() string {
+	return myApiV1.ConditionTypeDeployment
+}
+
+func (a *blueGreenActiveChild) Object(myDeployment *myApiV1.MyDeployment) client.Object {
+	return &appsV1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		Name: blueGreenName(myDeployment, a.activeColor), Namespace: myDeployment.Namespace}}
+}
+
+func (a *blueGreenActiveChild) ShouldExist(myDeployment *myApiV1.MyDeployment) bool {
+	return myDeployment.Spec.IsBlueGreen()
+}
+
+func (a *blueGreenActiveChild) Retain(myDeployment *myApiV1.MyDeployment) bool {
+	return blueGreenRetain(myDeployment)
+}
+
+func (a *blueGreenActiveChild) Build(_ context.Context, myDeployment *myApiV1.MyDeployment) (client.Object, error) {
+	deployment := NewBlueGreenDeployment(myDeployment, a.referenceChecksum, a.activeColor)
+	return &deployment, nil
+}
+
+func (a *blueGreenActiveChild) Equal(_ context.Context, desired, existing client.Object) (bool, error) {
+	if a.established && existing.GetAnnotations()[myApiV1.AnnotationTemplateHash] != a.hash {
+		a.rollout = true
+		return true, nil
+	}
+	return blueGreenDeploymentEqual(desired, existing), nil
+}
+
+func (a *blueGreenActiveChild) IsReady(_ context.Context, myDeployment *myApiV1.MyDeployment, existing client.Object) (bool, string, string) {
+	deployment := existing.(*appsV1.Deployment)
+	// 发布进行中时 active 仍然是原来的版本，只看 active 的 pod 是否全部就绪，新版本的进度由 BlueGreen condition 体现
+	ready := deploymentComplete(deployment)
+	if !a.rollout {
+		ready = ready && deployment.Annotations[myApiV1.AnnotationTemplateHash] == a.hash
+	}
+	if !ready {
+		message, reason := deploymentNotReadyMessage(deployment, nil)
+		return false, message, reason
+	}
+	if myDeployment.Status.ActiveColor == "" {
+		// 第一次全部就绪，service 从滚动更新的 Deployment 切换到 active 颜色
+		myDeployment.Status.ActiveColor = a.activeColor
+		myDeployment.Status.PreviewColor = a.previewColor
+	}
+	return true, fmt.Sprintf(myApiV1.ConditionMessageDeploymentOKFmt, deployment.Name), myApiV1.ConditionReasonDeploymentReady
+}
+
+// preview 颜色的 Deployment，设置 BlueGreen condition，在 active 之后同步
+// 1. 没有进行中的发布时缩容到 0，保留 Deployment 便于下次发布
+// 2. 发布进行中时部署新版本，全部就绪后自动切换，或者等待注解确认后切换，
+// 切换后 service 指向新的颜色，原来的颜色在下次 Reconcile 时缩容
+type blueGreenPreviewChild struct {
+	*blueGreen
+}
+
+func (p *blueGreenPreviewChild) ConditionType() string {
+	return myApiV1.ConditionTypeBlueGreen
+}
+
+func (p *blueGreenPreviewChild) Object(myDeployment *myApiV1.MyDeployment) client.Object {
+	return &appsV1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		Name: blueGreenName(myDeployment, p.previewColor), Namespace: myDeployment.Namespace}}
+}
+
+func (p *blueGreenPreviewChild) ShouldExist(myDeployment *myApiV1.MyDeployment) bool {
+	return myDeployment.Spec.IsBlueGreen()
+}
+
+func (p *blueGreenPreviewChild) Retain(myDeployment *myApiV1.MyDeployment) bool {
+	return blueGreenRetain(myDeployment)
+}
+
+func (p *blueGreenPreviewChild) Build(_ context.Context, myDeployment *myApiV1.MyDeployment) (client.Object, error) {
+	deployment := NewBlueGreenDeployment(myDeployment, p.referenceChecksum, p.previewColor)
+	if !p.rollout {
+		deployment.Spec.Replicas = ptr.To(int32(0))
+	}
+	return &deployment, nil
+}
+
+func (p *blueGreenPreviewChild) Equal(_ context.Context, desired, existing client.Object) (bool, error) {
+	return blueGreenDeploymentEqual(desired, existing), nil
+}
+
+func (p *blueGreenPreviewChild) IsReady(_ context.Context, myDeployment *myApiV1.MyDeployment, existing client.Object) (bool, string, string) {
+	if !p.rollout {
+		if myDeployment.Status.ActiveColor == "" {
+			return false, fmt.Sprintf(myApiV1.ConditionMessageBlueGreenSwitchingFmt, p.activeColor),
+				myApiV1.ConditionReasonBlueGreenSwitching
+		}
+		return true, fmt.Sprintf(myApiV1.ConditionMessageBlueGreenOKFmt, p.activeColor), myApiV1.ConditionReasonBlueGreenActive
+	}
+	deployment := existing.(*appsV1.Deployment)
+	ready := deployment.Annotations[myApiV1.AnnotationTemplateHash] == p.hash && deploymentComplete(deployment)
+	switch {
+	case ready && (myDeployment.Spec.Strategy.AutoPromote() ||
+		myDeployment.Annotations[myApiV1.AnnotationPromoteColor] == p.previewColor):
+		p.activeColor, p.previewColor = p.previewColor, p.activeColor
+		myDeployment.Status.ActiveColor = p.activeColor
+		myDeployment.Status.PreviewColor = p.previewColor
+		return true, fmt.Sprintf(myApiV1.ConditionMessageBlueGreenOKFmt, p.activeColor), myApiV1.ConditionReasonBlueGreenActive
+	case ready:
+		return false, fmt.Sprintf(myApiV1.ConditionMessageBlueGreenPreviewWaitingFmt,
+			p.previewColor, myApiV1.AnnotationPromoteColor, p.previewColor), myApiV1.ConditionReasonBlueGreenPreviewReady
+	default:
+		return false, fmt.Sprintf(myApiV1.ConditionMessageBlueGreenPreviewNotOKFmt, p.previewColor),
+			myApiV1.ConditionReasonBlueGreenPreviewNotReady
+	}
+}
+
+// 蓝绿发布中指向 preview 颜色的 Service，状态合并到 BlueGreen condition，在 preview 之后同步
+type previewServiceChild struct{}
+
+func (p *previewServiceChild) ConditionType() string {
+	return ""
+}
+
+func (p *previewServiceChild) Object(myDeployment *myApiV1.MyDeployment) client.Object {
+	return &coreV1.Service{ObjectMeta: metav1.ObjectMeta{Name: previewServiceName(myDeployment), Namespace: myDeployment.Namespace}}
+}
+
+func (p *previewServiceChild) ShouldExist(myDeployment *myApiV1.MyDeployment) bool {
+	return myDeployment.Spec.IsBlueGreen()
+}
+
+func (p *previewServiceChild) Retain(myDeployment *myApiV1.MyDeployment) bool {
+	return blueGreenRetain(myDeployment)
+}
+
+func (p *previewServiceChild) Build(_ context.Context, myDeployment *myApiV1.MyDeployment) (client.Object, error) {
+	service := NewPreviewService(myDeployment)
+	return &service, nil
+}
+
+func (p *previewServiceChild) Equal(_ context.Context, desired, existing client.Object) (bool, error) {
+	return serviceSelectorAndPortsEqual(desired, existing), nil
+}
+
+func (p *previewServiceChild) IsReady(context.Context, *myApiV1.MyDeployment, client.Object) (bool, string, string) {
+	return true, "", ""
+}
+
+// 只比较 selector 和端口，其他字段由 apiserver 填充
+func serviceSelectorAndPortsEqual(desired, existing client.Object) bool {
+	desiredService, prevService := desired.(*coreV1.Service), existing.(*coreV1.Service)
+	return reflect.DeepEqual(desiredService.Spec.Selector, prevService.Spec.Selector) &&
+		reflect.DeepEqual(desiredService.Spec.Ports, prevService.Spec.Ports)
+}
@@ -0,0 +1,472 @@
+package controller
+
+import (
+	"context"
+	myApiV1 "deployment/api/v1"
+	appsV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	networkingV1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"testing"
+)
+
+// 返回指定类型的 condition，不存在时返回 nil
+func findCondition(myDeployment *myApiV1.MyDeployment, conditionType string) *myApiV1.Condition {
+	for i := range myDeployment.Status.Conditions {
+		if myDeployment.Status.Conditions[i].Type == conditionType {
+			return &myDeployment.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+func newFakeMyDeployment(filename string) *myApiV1.MyDeployment {
+	myDeployment := newMyDeployment(filename)
+	myDeployment.Namespace = "default"
+	myDeployment.UID = types.UID("mydeployment-test-uid")
+	return myDeployment
+}
+
+func TestReconcileChild(t *testing.T) {
+	ctx := context.Background()
+	r := newFakeReconciler(t)
+	myDeployment := newFakeMyDeployment("volume-cr.yaml")
+	child := &configMapChild{}
+
+	// 1. 不存在时创建，设置 condition
+	result, err := r.reconcileChild(ctx, myDeployment, child)
+	if err != nil {
+		t.Fatalf("reconcileChild() create error = %v", err)
+	}
+	if result.updated || !result.ready || result.existing.GetResourceVersion() == "" {
+		t.Errorf("reconcileChild() create result = %+v", result)
+	}
+	condition := findCondition(myDeployment, myApiV1.ConditionTypeConfigMap)
+	if condition == nil || condition.Status != myApiV1.ConditionStatusTrue {
+		t.Errorf("reconcileChild() create condition = %+v", condition)
+	}
+
+	// 2. 没有变化时不更新
+	if result, err = r.reconcileChild(ctx, myDeployment, child); err != nil || result.updated {
+		t.Errorf("reconcileChild() unchanged result = %+v, error = %v", result, err)
+	}
+
+	// 3. 有变化时更新
+	myDeployment.Spec.ConfigFiles["nginx.conf"] = "worker_processes 1;"
+	if result, err = r.reconcileChild(ctx, myDeployment, child); err != nil || !result.updated {
+		t.Errorf("reconcileChild() changed result = %+v, error = %v", result, err)
+	}
+	configMap := new(coreV1.ConfigMap)
+	if err = r.Get(ctx, client.ObjectKeyFromObject(child.Object(myDeployment)), configMap); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(configMap.Data, myDeployment.Spec.ConfigFiles) {
+		t.Errorf("reconcileChild() updated data = %v, want %v", configMap.Data, myDeployment.Spec.ConfigFiles)
+	}
+
+	// 4. 不需要时删除对象和 condition
+	myDeployment.Spec.ConfigFiles = nil
+	if _, err = r.reconcileChild(ctx, myDeployment, child); err != nil {
+		t.Fatalf("reconcileChild() delete error = %v", err)
+	}
+	if err = r.Get(ctx, client.ObjectKeyFromObject(configMap), configMap); client.IgnoreNotFound(err) != nil || err == nil {
+		t.Errorf("reconcileChild() delete got error = %v, want not found", err)
+	}
+	if condition = findCondition(myDeployment, myApiV1.ConditionTypeConfigMap); condition != nil {
+		t.Errorf("reconcileChild() delete condition = %+v, want nil", condition)
+	}
+}
+
+func TestReconcileChildNotOwned(t *testing.T) {
+	ctx := context.Background()
+	r := newFakeReconciler(t)
+	myDeployment := newFakeMyDeployment("ingress-cr.yaml")
+	// 同名的 Service 不属于 md 时，不覆盖，condition 为 False
+	err := r.Create(ctx, &coreV1.Service{ObjectMeta: (&serviceChild{}).Object(myDeployment).(*coreV1.Service).ObjectMeta})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.reconcileChild(ctx, myDeployment, &serviceChild{client: r.Client}); err == nil {
+		t.Errorf("reconcileChild() error = nil, want not managed error")
+	}
+	condition := findCondition(myDeployment, myApiV1.ConditionTypeService)
+	if condition == nil || condition.Status != myApiV1.ConditionStatusFalse ||
+		condition.Reason != myApiV1.ConditionReasonServiceNotReady {
+		t.Errorf("reconcileChild() condition = %+v", condition)
+	}
+}
+
+func TestPVCChild(t *testing.T) {
+	ctx := context.Background()
+	r := newFakeReconciler(t)
+	myDeployment := newFakeMyDeployment("volume-cr.yaml")
+	child := &pvcChild{}
+	key := client.ObjectKeyFromObject(child.Object(myDeployment))
+	getPVC := func() *coreV1.PersistentVolumeClaim {
+		t.Helper()
+		pvc := new(coreV1.PersistentVolumeClaim)
+		if err := r.Get(ctx, key, pvc); err != nil {
+			t.Fatal(err)
+		}
+		return pvc
+	}
+
+	// 1. 同名的 pvc 不属于 md 时，不修改，condition 为 False
+	userPVC := &coreV1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+	userPVC.Spec.Resources.Requests = coreV1.ResourceList{coreV1.ResourceStorage: resource.MustParse("100Mi")}
+	if err := r.Create(ctx, userPVC); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.reconcileChild(ctx, myDeployment, child); err == nil {
+		t.Errorf("reconcileChild() not owned error = nil, want not managed error")
+	}
+	if got := getPVC().Spec.Resources.Requests[coreV1.ResourceStorage]; got.Cmp(resource.MustParse("100Mi")) != 0 {
+		t.Errorf("reconcileChild() not owned changed size to %s", got.String())
+	}
+	condition := findCondition(myDeployment, myApiV1.ConditionTypeStorage)
+	if condition == nil || condition.Status != myApiV1.ConditionStatusFalse ||
+		condition.Reason != myApiV1.ConditionReasonStorageNotReady {
+		t.Errorf("reconcileChild() not owned condition = %+v", condition)
+	}
+	if err := r.Delete(ctx, userPVC); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2. 不存在时创建，没有绑定时 condition 为 False
+	if result, err := r.reconcileChild(ctx, myDeployment, child); err != nil || result.ready {
+		t.Fatalf("reconcileChild() create result = %+v, error = %v", result, err)
+	}
+	condition = findCondition(myDeployment, myApiV1.ConditionTypeStorage)
+	if condition == nil || condition.Reason != myApiV1.ConditionReasonStorageNotReady {
+		t.Errorf("reconcileChild() create condition = %+v", condition)
+	}
+
+	// 3. 扩容时只修改已有 pvc 的容量
+	myDeployment.Spec.Storage.Size = resource.MustParse("2Gi")
+	if result, err := r.reconcileChild(ctx, myDeployment, child); err != nil || !result.updated {
+		t.Fatalf("reconcileChild() expand result = %+v, error = %v", result, err)
+	}
+	pvc := getPVC()
+	if got := pvc.Spec.Resources.Requests[coreV1.ResourceStorage]; got.Cmp(resource.MustParse("2Gi")) != 0 {
+		t.Errorf("reconcileChild() expand size = %s, want 2Gi", got.String())
+	}
+	if !metav1.IsControlledBy(pvc, myDeployment) {
+		t.Errorf("reconcileChild() expand owner references = %+v", pvc.OwnerReferences)
+	}
+	// 缩小容量时不更新
+	myDeployment.Spec.Storage.Size = resource.MustParse("1Gi")
+	if result, err := r.reconcileChild(ctx, myDeployment, child); err != nil || result.updated {
+		t.Errorf("reconcileChild() shrink result = %+v, error = %v", result, err)
+	}
+
+	// 4. 删除 storage 后保留 pvc，只删除 condition
+	myDeployment.Spec.Storage = nil
+	if _, err := r.reconcileChild(ctx, myDeployment, child); err != nil {
+		t.Fatalf("reconcileChild() retain error = %v", err)
+	}
+	getPVC()
+	if condition = findCondition(myDeployment, myApiV1.ConditionTypeStorage); condition != nil {
+		t.Errorf("reconcileChild() retain condition = %+v, want nil", condition)
+	}
+}
+
+// 之前设置过 storage，pvc 还没有创建成功就去掉了，删除获取 pvc 失败时设置的 condition
+func TestPVCChildNotNeeded(t *testing.T) {
+	r := newFakeReconciler(t)
+	myDeployment := newFakeMyDeployment("volume-cr.yaml")
+	myDeployment.Spec.Storage = nil
+	myDeployment.Status.Conditions = []myApiV1.Condition{
+		createCondition(myApiV1.ConditionTypeStorage, "", myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonStorageNotReady),
+		createCondition(myApiV1.ConditionTypeDeployment, "", myApiV1.ConditionStatusTrue, myApiV1.ConditionReasonDeploymentReady),
+	}
+	if _, err := r.reconcileChild(context.Background(), myDeployment, &pvcChild{}); err != nil {
+		t.Fatalf("reconcileChild() error = %v", err)
+	}
+	if condition := findCondition(myDeployment, myApiV1.ConditionTypeStorage); condition != nil {
+		t.Errorf("reconcileChild() condition = %+v, want nil", condition)
+	}
+	if condition := findCondition(myDeployment, myApiV1.ConditionTypeDeployment); condition == nil {
+		t.Errorf("reconcileChild() removed the Deployment condition")
+	}
+}
+
+func TestIngressChild(t *testing.T) {
+	child := &ingressChild{}
+	tests := []struct {
+		name     string
+		filename string
+		want     *networkingV1.Ingress
+	}{
+		{
+			name:     "测试使用 ingress mode，需要 Ingress",
+			filename: "ingress-cr.yaml",
+			want:     newIngress("golden/ingress-ingress-expect.yaml"),
+		},
+		{
+			name:     "测试使用 nodePort mode，不需要 Ingress",
+			filename: "nodeport-cr.yaml",
+		},
+		{
+			name:     "测试不设置 expose，不需要 Ingress",
+			filename: "internal-cr.yaml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			myDeployment := newMyDeployment(tt.filename)
+			if got := child.ShouldExist(myDeployment); got != (tt.want != nil) {
+				t.Fatalf("ShouldExist() = %v, want %v", got, tt.want != nil)
+			}
+			if tt.want == nil {
+				return
+			}
+			got, err := child.Build(context.Background(), myDeployment)
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Build() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeploymentChildIsReady(t *testing.T) {
+	myDeployment := newFakeMyDeployment("internal-cr.yaml")
+	// 拉取镜像失败的 pod
+	imagePullFailedPod := func(name string, labels map[string]string) *coreV1.Pod {
+		return &coreV1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: myDeployment.Namespace, Labels: labels},
+			Status: coreV1.PodStatus{ContainerStatuses: []coreV1.ContainerStatus{{
+				State: coreV1.ContainerState{Waiting: &coreV1.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+			}}},
+		}
+	}
+	tests := []struct {
+		name          string
+		readyReplicas int32
+		pods          []*coreV1.Pod
+		want          bool
+		wantReason    string
+	}{
+		{
+			name:          "测试所有副本就绪",
+			readyReplicas: 2,
+			want:          true,
+			wantReason:    myApiV1.ConditionReasonDeploymentReady,
+		},
+		{
+			name:          "测试部分副本就绪",
+			readyReplicas: 1,
+			want:          false,
+			wantReason:    myApiV1.ConditionReasonDeploymentNotReady,
+		},
+		{
+			name:          "测试主 Deployment 的 pod 拉取镜像失败",
+			readyReplicas: 1,
+			pods:          []*coreV1.Pod{imagePullFailedPod("stable", myDeployment.StableLabels())},
+			want:          false,
+			wantReason:    myApiV1.ConditionReasonDeploymentImagePullFailure,
+		},
+		{
+			name:          "测试金丝雀 pod 拉取镜像失败，不影响主 Deployment",
+			readyReplicas: 1,
+			pods:          []*coreV1.Pod{imagePullFailedPod("canary", newCanaryLabels(myDeployment))},
+			want:          false,
+			wantReason:    myApiV1.ConditionReasonDeploymentNotReady,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			child := &deploymentChild{reconciler: newFakeReconciler(t)}
+			for _, pod := range tt.pods {
+				if err := child.reconciler.Create(context.Background(), pod); err != nil {
+					t.Fatal(err)
+				}
+			}
+			deployment := NewDeployment(myDeployment, "")
+			deployment.Spec.Replicas = ptr.To(int32(2))
+			deployment.Status = appsV1.DeploymentStatus{ReadyReplicas: tt.readyReplicas}
+			got, _, reason := child.IsReady(context.Background(), myDeployment, &deployment)
+			if got != tt.want || reason != tt.wantReason {
+				t.Errorf("IsReady() = %v, %v, want %v, %v", got, reason, tt.want, tt.wantReason)
+			}
+		})
+	}
+}
+
+// selector 创建后不可修改，之前创建的 Deployment 保留原来的 selector
+func TestDeploymentChildKeepSelector(t *testing.T) {
+	ctx := context.Background()
+	r := newFakeReconciler(t)
+	myDeployment := newFakeMyDeployment("internal-cr.yaml")
+	child := &deploymentChild{reconciler: r}
+	existing := NewDeployment(myDeployment, "")
+	existing.Spec.Selector = &metav1.LabelSelector{MatchLabels: newLabels(myDeployment)}
+	existing.Spec.Template.Labels = newLabels(myDeployment)
+	if err := r.Create(ctx, &existing); err != nil {
+		t.Fatal(err)
+	}
+
+	desired, err := child.Build(ctx, myDeployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desired.SetResourceVersion(existing.ResourceVersion)
+	equal, err := child.Equal(ctx, desired, &existing)
+	if err != nil {
+		t.Fatalf("Equal() error = %v", err)
+	}
+	if equal {
+		t.Errorf("Equal() = true, want false for the new track label in pod template")
+	}
+	got := desired.(*appsV1.Deployment)
+	if !reflect.DeepEqual(got.Spec.Selector, existing.Spec.Selector) {
+		t.Errorf("Equal() selector = %v, want %v", got.Spec.Selector, existing.Spec.Selector)
+	}
+	if !reflect.DeepEqual(got.Spec.Template.Labels, myDeployment.StableLabels()) {
+		t.Errorf("Equal() template labels = %v, want %v", got.Spec.Template.Labels, myDeployment.StableLabels())
+	}
+}
+
+// 蓝绿发布从滚动更新切换过来，发布新版本，再切换回滚动更新，由 reconcileChildren 驱动所有的 Deployment
+func TestBlueGreenChildren(t *testing.T) {
+	ctx := context.Background()
+	r := newFakeReconciler(t)
+	myDeployment := newFakeMyDeployment("bluegreen-cr.yaml")
+	myDeployment.Status = myApiV1.MyDeploymentStatus{}
+	// 和 Reconcile 一样，active 和 preview 共享同一个状态
+	reconcile := func() {
+		t.Helper()
+		blueGreen := newBlueGreen(myDeployment, "")
+		_, err := r.reconcileChildren(ctx, myDeployment, &deploymentChild{reconciler: r},
+			&blueGreenActiveChild{blueGreen}, &blueGreenPreviewChild{blueGreen}, &previewServiceChild{})
+		if err != nil {
+			t.Fatalf("reconcileChildren() error = %v", err)
+		}
+	}
+	getDeployment := func(name string) (*appsV1.Deployment, bool) {
+		t.Helper()
+		deployment := new(appsV1.Deployment)
+		err := r.Get(ctx, client.ObjectKey{Namespace: myDeployment.Namespace, Name: name}, deployment)
+		if client.IgnoreNotFound(err) != nil {
+			t.Fatal(err)
+		}
+		return deployment, err == nil
+	}
+	// 模拟 Deployment controller 完成更新
+	complete := func(name string) {
+		t.Helper()
+		deployment, _ := getDeployment(name)
+		replicas := ptr.Deref(deployment.Spec.Replicas, 1)
+		deployment.Status = appsV1.DeploymentStatus{ObservedGeneration: deployment.Generation,
+			Replicas: replicas, UpdatedReplicas: replicas, ReadyReplicas: replicas}
+		if err := r.Status().Update(ctx, deployment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantCondition := func(conditionType, status, reason string) {
+		t.Helper()
+		condition := findCondition(myDeployment, conditionType)
+		if condition == nil || condition.Status != status || condition.Reason != reason {
+			t.Errorf("%s condition = %+v, want %s %s", conditionType, condition, status, reason)
+		}
+	}
+	wantColors := func(active, preview string) {
+		t.Helper()
+		if myDeployment.Status.ActiveColor != active || myDeployment.Status.PreviewColor != preview {
+			t.Errorf("colors = %q %q, want %q %q",
+				myDeployment.Status.ActiveColor, myDeployment.Status.PreviewColor, active, preview)
+		}
+	}
+	blue, green := blueGreenName(myDeployment, myApiV1.ColorBlue), blueGreenName(myDeployment, myApiV1.ColorGreen)
+
+	// 1. 之前使用滚动更新，blue 就绪之前保留滚动更新的 Deployment，green 缩容到 0
+	rolling := NewDeployment(myDeployment, "")
+	if err := controllerutil.SetControllerReference(myDeployment, &rolling, r.Scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Create(ctx, &rolling); err != nil {
+		t.Fatal(err)
+	}
+	reconcile()
+	if _, ok := getDeployment(myDeployment.Name); !ok {
+		t.Errorf("rolling Deployment deleted before the first switch")
+	}
+	if deployment, _ := getDeployment(green); ptr.Deref(deployment.Spec.Replicas, 1) != 0 {
+		t.Errorf("preview replicas = %d, want 0", ptr.Deref(deployment.Spec.Replicas, 1))
+	}
+	wantColors("", "")
+	wantCondition(myApiV1.ConditionTypeDeployment, myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonDeploymentNotReady)
+	wantCondition(myApiV1.ConditionTypeBlueGreen, myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonBlueGreenSwitching)
+
+	// 2. blue 就绪后切换，下次同步时删除滚动更新的 Deployment
+	complete(blue)
+	reconcile()
+	wantColors(myApiV1.ColorBlue, myApiV1.ColorGreen)
+	wantCondition(myApiV1.ConditionTypeDeployment, myApiV1.ConditionStatusTrue, myApiV1.ConditionReasonDeploymentReady)
+	wantCondition(myApiV1.ConditionTypeBlueGreen, myApiV1.ConditionStatusTrue, myApiV1.ConditionReasonBlueGreenActive)
+	reconcile()
+	if _, ok := getDeployment(myDeployment.Name); ok {
+		t.Errorf("rolling Deployment not deleted after the switch")
+	}
+
+	// 3. 发布新版本，blue 保持不变，新版本部署到 green，没有确认时不切换
+	myDeployment.Spec.Image = "nginx:1.27"
+	reconcile()
+	if deployment, _ := getDeployment(blue); deployment.Spec.Template.Spec.Containers[0].Image != "nginx" {
+		t.Errorf("active image = %s, want nginx", deployment.Spec.Template.Spec.Containers[0].Image)
+	}
+	preview, _ := getDeployment(green)
+	if preview.Spec.Template.Spec.Containers[0].Image != "nginx:1.27" || ptr.Deref(preview.Spec.Replicas, 1) != 2 {
+		t.Errorf("preview image = %s replicas = %d, want nginx:1.27 2",
+			preview.Spec.Template.Spec.Containers[0].Image, ptr.Deref(preview.Spec.Replicas, 1))
+	}
+	wantCondition(myApiV1.ConditionTypeBlueGreen, myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonBlueGreenPreviewNotReady)
+	complete(green)
+	reconcile()
+	wantColors(myApiV1.ColorBlue, myApiV1.ColorGreen)
+	wantCondition(myApiV1.ConditionTypeBlueGreen, myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonBlueGreenPreviewReady)
+
+	// 4. 确认后切换到 green，下次同步时 blue 缩容到 0
+	myDeployment.Annotations = map[string]string{myApiV1.AnnotationPromoteColor: myApiV1.ColorGreen}
+	reconcile()
+	wantColors(myApiV1.ColorGreen, myApiV1.ColorBlue)
+	wantCondition(myApiV1.ConditionTypeBlueGreen, myApiV1.ConditionStatusTrue, myApiV1.ConditionReasonBlueGreenActive)
+	service := new(coreV1.Service)
+	reconcile()
+	if err := r.Get(ctx, client.ObjectKeyFromObject((&previewServiceChild{}).Object(myDeployment)), service); err != nil {
+		t.Fatal(err)
+	}
+	if service.Spec.Selector[myApiV1.LabelKeyColor] != myApiV1.ColorBlue {
+		t.Errorf("preview service selector = %v, want color blue", service.Spec.Selector)
+	}
+	if deployment, _ := getDeployment(blue); ptr.Deref(deployment.Spec.Replicas, 1) != 0 {
+		t.Errorf("old active replicas = %d, want 0", ptr.Deref(deployment.Spec.Replicas, 1))
+	}
+
+	// 5. 切换回滚动更新，滚动更新的 Deployment 就绪之前保留蓝绿发布的资源，就绪后清空颜色，下次同步时删除
+	myDeployment.Spec.Strategy = nil
+	reconcile()
+	if _, ok := getDeployment(green); !ok {
+		t.Errorf("active Deployment deleted before the rolling Deployment is ready")
+	}
+	complete(myDeployment.Name)
+	reconcile()
+	wantColors("", "")
+	if condition := findCondition(myDeployment, myApiV1.ConditionTypeBlueGreen); condition != nil {
+		t.Errorf("BlueGreen condition = %+v, want nil", condition)
+	}
+	reconcile()
+	for _, name := range []string{blue, green} {
+		if _, ok := getDeployment(name); ok {
+			t.Errorf("Deployment %s not deleted after switching back", name)
+		}
+	}
+}
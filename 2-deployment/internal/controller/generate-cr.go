@@ -2,14 +2,21 @@ package controller
 
 import (
 	"bytes"
+	"crypto/sha256"
 	myApiV1 "deployment/api/v1"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	appsV1 "k8s.io/api/apps/v1"
 	coreV1 "k8s.io/api/core/v1"
 	networkingV1 "k8s.io/api/networking/v1"
+	rbacV1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	"strconv"
 	"text/template"
 )
 
@@ -31,11 +38,13 @@ var PrefixPathType = networkingV1.PathTypePrefix
 
 func newLabels(myDeployment *myApiV1.MyDeployment) map[string]string {
 	return map[string]string{
-		"app": myDeployment.Name,
+		myApiV1.LabelKeyApp: myDeployment.Name,
 	}
 }
 
-func NewDeployment(myDeployment *myApiV1.MyDeployment) appsV1.Deployment {
+// NewDeployment 生成 Deployment，referenceChecksum 是 spec 中引用的 ConfigMap / Secret 内容的摘要，
+// 为空表示没有需要跟随变化滚动更新的引用
+func NewDeployment(myDeployment *myApiV1.MyDeployment, referenceChecksum string) appsV1.Deployment {
 	// 1. 创建基本的 deployment
 	// 1.1 创建只含有 metadata 的信息对象
 	deploy := newBaseDeployment(myDeployment)
@@ -44,22 +53,47 @@ func NewDeployment(myDeployment *myApiV1.MyDeployment) appsV1.Deployment {
 	// 2.1 在基本的 deployment 中添加其他的对象
 	deploy.Spec.Replicas = &myDeployment.Spec.Replicas
 	deploy.Spec.Selector = &metav1.LabelSelector{
-		MatchLabels: newLabels(myDeployment),
+		MatchLabels: myDeployment.StableLabels(),
 	}
 	deploy.Spec.Template.ObjectMeta = metav1.ObjectMeta{
 		Name:   myDeployment.Name,
-		Labels: newLabels(myDeployment),
+		Labels: myDeployment.StableLabels(),
 	}
 	deploy.Spec.Template.Spec.Containers = []coreV1.Container{
 		newBaseContainer(myDeployment),
 	}
+	// 2.2 添加卷，包括用户自定义的卷，以及 configFiles 和 storage 生成的卷
+	deploy.Spec.Template.Spec.Volumes = newVolumes(myDeployment)
+	// 2.3 添加调度配置
+	setScheduling(&deploy.Spec.Template.Spec, myDeployment.Spec.Scheduling)
+	// 2.4 添加 pod 级别的安全配置
+	deploy.Spec.Template.Spec.SecurityContext = newPodSecurityContext(myDeployment.Spec.SecurityContext)
+	if myDeployment.Spec.ServiceAccount != nil {
+		deploy.Spec.Template.Spec.ServiceAccountName = serviceAccountName(myDeployment)
+		deploy.Spec.Template.Spec.AutomountServiceAccountToken = myDeployment.Spec.ServiceAccount.AutomountServiceAccountToken
+	}
+	// 2.5 configFiles 和引用对象的内容摘要写入 pod template，内容变化时触发滚动更新
+	annotations := map[string]string{}
+	if len(myDeployment.Spec.ConfigFiles) != 0 {
+		annotations[myApiV1.AnnotationConfigChecksum] = configChecksum(myDeployment.Spec.ConfigFiles)
+	}
+	if referenceChecksum != "" {
+		annotations[myApiV1.AnnotationReferenceChecksum] = referenceChecksum
+	}
+	if len(annotations) != 0 {
+		deploy.Spec.Template.ObjectMeta.Annotations = annotations
+	}
+	// 2.6 自动回滚根据 Deployment 的 ProgressDeadlineExceeded 判断更新失败
+	if myDeployment.Spec.Rollback != nil {
+		deploy.Spec.ProgressDeadlineSeconds = myDeployment.Spec.Rollback.ProgressDeadlineSeconds
+	}
 	return deploy
 }
 
 func newBaseContainer(myDeployment *myApiV1.MyDeployment) coreV1.Container {
 	c := coreV1.Container{
 		Name:  myDeployment.ObjectMeta.Name,
-		Image: myDeployment.Spec.Image,
+		Image: myDeployment.Spec.DeploymentImage(),
 		Ports: []coreV1.ContainerPort{
 			{
 				ContainerPort: myDeployment.Spec.Port,
@@ -78,9 +112,130 @@ func newBaseContainer(myDeployment *myApiV1.MyDeployment) coreV1.Container {
 		c.Env = myDeployment.Spec.Environments
 	}
 
+	c.VolumeMounts = newVolumeMounts(myDeployment)
+	c.SecurityContext = newContainerSecurityContext(myDeployment.Spec.SecurityContext)
+
 	return c
 }
 
+// 用户设置的 pod 安全配置优先，开启加固时补全 runAsNonRoot 和 seccomp
+func newPodSecurityContext(securityContext *myApiV1.SecurityContext) *coreV1.PodSecurityContext {
+	var podSecurityContext *coreV1.PodSecurityContext
+	if securityContext != nil && securityContext.Pod != nil {
+		podSecurityContext = securityContext.Pod.DeepCopy()
+	}
+	if !securityContext.IsHardened() {
+		return podSecurityContext
+	}
+	if podSecurityContext == nil {
+		podSecurityContext = &coreV1.PodSecurityContext{}
+	}
+	if podSecurityContext.RunAsNonRoot == nil {
+		podSecurityContext.RunAsNonRoot = ptr.To(true)
+	}
+	if podSecurityContext.SeccompProfile == nil {
+		podSecurityContext.SeccompProfile = &coreV1.SeccompProfile{
+			Type: coreV1.SeccompProfileTypeRuntimeDefault,
+		}
+	}
+	return podSecurityContext
+}
+
+// 用户设置的容器安全配置优先，开启加固时补全禁止提权、丢弃所有 capabilities 和只读根文件系统
+func newContainerSecurityContext(securityContext *myApiV1.SecurityContext) *coreV1.SecurityContext {
+	var containerSecurityContext *coreV1.SecurityContext
+	if securityContext != nil && securityContext.Container != nil {
+		containerSecurityContext = securityContext.Container.DeepCopy()
+	}
+	if !securityContext.IsHardened() {
+		return containerSecurityContext
+	}
+	if containerSecurityContext == nil {
+		containerSecurityContext = &coreV1.SecurityContext{}
+	}
+	if containerSecurityContext.AllowPrivilegeEscalation == nil {
+		containerSecurityContext.AllowPrivilegeEscalation = ptr.To(false)
+	}
+	if containerSecurityContext.Capabilities == nil {
+		containerSecurityContext.Capabilities = &coreV1.Capabilities{
+			Drop: []coreV1.Capability{"ALL"},
+		}
+	}
+	if containerSecurityContext.ReadOnlyRootFilesystem == nil {
+		containerSecurityContext.ReadOnlyRootFilesystem = ptr.To(true)
+	}
+	return containerSecurityContext
+}
+
+func setScheduling(podSpec *coreV1.PodSpec, scheduling *myApiV1.Scheduling) {
+	if scheduling == nil {
+		return
+	}
+	podSpec.NodeSelector = scheduling.NodeSelector
+	podSpec.Tolerations = scheduling.Tolerations
+	podSpec.Affinity = scheduling.Affinity
+	podSpec.PriorityClassName = scheduling.PriorityClassName
+	podSpec.TopologySpreadConstraints = scheduling.TopologySpreadConstraints
+}
+
+func newVolumes(myDeployment *myApiV1.MyDeployment) []coreV1.Volume {
+	var volumes []coreV1.Volume
+	volumes = append(volumes, myDeployment.Spec.Volumes...)
+	if len(myDeployment.Spec.ConfigFiles) != 0 {
+		volumes = append(volumes, coreV1.Volume{
+			Name: myApiV1.VolumeNameConfigFiles,
+			VolumeSource: coreV1.VolumeSource{
+				ConfigMap: &coreV1.ConfigMapVolumeSource{
+					LocalObjectReference: coreV1.LocalObjectReference{
+						Name: configMapName(myDeployment),
+					},
+				},
+			},
+		})
+	}
+	if myDeployment.Spec.Storage != nil {
+		volumes = append(volumes, coreV1.Volume{
+			Name: myApiV1.VolumeNameStorage,
+			VolumeSource: coreV1.VolumeSource{
+				PersistentVolumeClaim: &coreV1.PersistentVolumeClaimVolumeSource{
+					ClaimName: persistentVolumeClaimName(myDeployment),
+				},
+			},
+		})
+	}
+	return volumes
+}
+
+func newVolumeMounts(myDeployment *myApiV1.MyDeployment) []coreV1.VolumeMount {
+	var volumeMounts []coreV1.VolumeMount
+	volumeMounts = append(volumeMounts, myDeployment.Spec.VolumeMounts...)
+	if len(myDeployment.Spec.ConfigFiles) != 0 {
+		mountPath := myDeployment.Spec.ConfigFilesMountPath
+		if mountPath == "" {
+			mountPath = myApiV1.DefaultConfigFilesMountPath
+		}
+		volumeMounts = append(volumeMounts, coreV1.VolumeMount{
+			Name:      myApiV1.VolumeNameConfigFiles,
+			MountPath: mountPath,
+			ReadOnly:  true,
+		})
+	}
+	if myDeployment.Spec.Storage != nil {
+		volumeMounts = append(volumeMounts, coreV1.VolumeMount{
+			Name:      myApiV1.VolumeNameStorage,
+			MountPath: myDeployment.Spec.Storage.MountPath,
+		})
+	}
+	return volumeMounts
+}
+
+// 计算 configFiles 的摘要，json 序列化 map 时 key 是有序的，所以结果是稳定的
+func configChecksum(configFiles map[string]string) string {
+	data, _ := json.Marshal(configFiles)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
 func newBaseDeployment(myDeployment *myApiV1.MyDeployment) appsV1.Deployment {
 	return appsV1.Deployment{
 		TypeMeta: metav1.TypeMeta{
@@ -102,7 +257,7 @@ func NewIngress(myDeployment *myApiV1.MyDeployment) networkingV1.Ingress {
 		newIngressRule(myDeployment),
 	}
 	// https 6. ingress 添加 tls 支持
-	if myDeployment.Spec.Expose.Mode == myApiV1.ModeIngress && myDeployment.Spec.Expose.Tls {
+	if myDeployment.Spec.TLSEnabled() {
 		ingress.Spec.TLS = []networkingV1.IngressTLS{
 			newIngressTLS(myDeployment),
 		}
@@ -156,7 +311,7 @@ func newIngressRule(deployment *myApiV1.MyDeployment) networkingV1.IngressRule {
 							Service: &networkingV1.IngressServiceBackend{
 								Name: deployment.Name,
 								Port: networkingV1.ServiceBackendPort{
-									Number: int32(deployment.Spec.Expose.ServicePort),
+									Number: deployment.Spec.ServicePort(),
 								},
 							},
 						},
@@ -177,11 +332,16 @@ func newIngressTLS(deployment *myApiV1.MyDeployment) networkingV1.IngressTLS {
 func NewService(myDeployment *myApiV1.MyDeployment) coreV1.Service {
 	svc := newBaseService(myDeployment)
 	svc.Spec.Selector = newLabels(myDeployment)
+	// 蓝绿发布时只选择 active 颜色的 pod，还没有颜色全部就绪过时，仍然选择所有的 pod
+	if myDeployment.Spec.IsBlueGreen() && myDeployment.Status.ActiveColor != "" {
+		svc.Spec.Selector = newColorLabels(myDeployment, myDeployment.Status.ActiveColor)
+	}
 
 	servicePort := newServicePort(myDeployment)
 
-	switch myDeployment.Spec.Expose.Mode {
-	case myApiV1.ModeIngress:
+	switch myDeployment.Spec.ExposeMode() {
+	case "", myApiV1.ModeIngress:
+		// 没有设置 spec.expose 时只在集群内部访问，和 ingress 模式一样使用 ClusterIP
 		svc.Spec.Ports = []coreV1.ServicePort{servicePort}
 	case myApiV1.ModeNodePort:
 		svc.Spec.Type = coreV1.ServiceTypeNodePort
@@ -209,12 +369,315 @@ func newBaseService(deployment *myApiV1.MyDeployment) coreV1.Service {
 func newServicePort(deployment *myApiV1.MyDeployment) coreV1.ServicePort {
 	return coreV1.ServicePort{
 		Protocol: coreV1.ProtocolTCP,
-		Port:     deployment.Spec.Expose.ServicePort,
+		Port:     deployment.Spec.ServicePort(),
 		TargetPort: intstr.IntOrString{
 			Type:   intstr.Int,
-			IntVal: deployment.Spec.Expose.ServicePort,
+			IntVal: deployment.Spec.ServicePort(),
+		},
+	}
+}
+
+func configMapName(myDeployment *myApiV1.MyDeployment) string {
+	return myDeployment.Name + "-config"
+}
+
+func NewConfigMap(myDeployment *myApiV1.MyDeployment) coreV1.ConfigMap {
+	return coreV1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      configMapName(myDeployment),
+			Namespace: myDeployment.Namespace,
+			Labels:    newLabels(myDeployment),
+		},
+		Data: myDeployment.Spec.ConfigFiles,
+	}
+}
+
+func persistentVolumeClaimName(myDeployment *myApiV1.MyDeployment) string {
+	return myDeployment.Name + "-data"
+}
+
+func NewPersistentVolumeClaim(myDeployment *myApiV1.MyDeployment) coreV1.PersistentVolumeClaim {
+	storage := myDeployment.Spec.Storage
+	if storage == nil {
+		return coreV1.PersistentVolumeClaim{}
+	}
+	return coreV1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      persistentVolumeClaimName(myDeployment),
+			Namespace: myDeployment.Namespace,
+			Labels:    newLabels(myDeployment),
+		},
+		Spec: coreV1.PersistentVolumeClaimSpec{
+			AccessModes:      storage.EffectiveAccessModes(),
+			StorageClassName: storage.StorageClassName,
+			Resources: coreV1.VolumeResourceRequirements{
+				Requests: coreV1.ResourceList{
+					coreV1.ResourceStorage: storage.Size,
+				},
+			},
+		},
+	}
+}
+
+// 引用或者生成的 ServiceAccount 名称，没有指定时使用 MyDeployment 的名称
+func serviceAccountName(myDeployment *myApiV1.MyDeployment) string {
+	if myDeployment.Spec.ServiceAccount != nil && myDeployment.Spec.ServiceAccount.Name != "" {
+		return myDeployment.Spec.ServiceAccount.Name
+	}
+	return myDeployment.Name
+}
+
+func NewServiceAccount(myDeployment *myApiV1.MyDeployment) coreV1.ServiceAccount {
+	serviceAccount := coreV1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ServiceAccount",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceAccountName(myDeployment),
+			Namespace: myDeployment.Namespace,
+			Labels:    newLabels(myDeployment),
+		},
+	}
+	if myDeployment.Spec.ServiceAccount != nil {
+		serviceAccount.AutomountServiceAccountToken = myDeployment.Spec.ServiceAccount.AutomountServiceAccountToken
+	}
+	return serviceAccount
+}
+
+// Role 和 RoleBinding 与生成的 ServiceAccount 同名
+func NewRole(myDeployment *myApiV1.MyDeployment) rbacV1.Role {
+	role := rbacV1.Role{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Role",
+			APIVersion: "rbac.authorization.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceAccountName(myDeployment),
+			Namespace: myDeployment.Namespace,
+			Labels:    newLabels(myDeployment),
+		},
+	}
+	if myDeployment.Spec.ServiceAccount != nil {
+		role.Rules = myDeployment.Spec.ServiceAccount.Rules
+	}
+	return role
+}
+
+func NewRoleBinding(myDeployment *myApiV1.MyDeployment) rbacV1.RoleBinding {
+	return rbacV1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "RoleBinding",
+			APIVersion: "rbac.authorization.k8s.io/v1",
 		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceAccountName(myDeployment),
+			Namespace: myDeployment.Namespace,
+			Labels:    newLabels(myDeployment),
+		},
+		Subjects: []rbacV1.Subject{
+			{
+				Kind:      rbacV1.ServiceAccountKind,
+				Name:      serviceAccountName(myDeployment),
+				Namespace: myDeployment.Namespace,
+			},
+		},
+		RoleRef: rbacV1.RoleRef{
+			APIGroup: rbacV1.GroupName,
+			Kind:     "Role",
+			Name:     serviceAccountName(myDeployment),
+		},
+	}
+}
+
+// 金丝雀的 Deployment / Service / Ingress 使用相同的名称
+func canaryName(myDeployment *myApiV1.MyDeployment) string {
+	return myDeployment.Name + "-canary"
+}
+
+// 金丝雀 pod 保留 app 标签，主 service 同样会选择到金丝雀 pod，track 和主 Deployment 的 pod 不同
+func newCanaryLabels(myDeployment *myApiV1.MyDeployment) map[string]string {
+	labels := newLabels(myDeployment)
+	labels[myApiV1.LabelKeyTrack] = myApiV1.LabelValueCanary
+	return labels
+}
+
+// NewCanaryDeployment 生成金丝雀 Deployment，除了名称、标签、副本数和镜像，和主 Deployment 相同
+func NewCanaryDeployment(myDeployment *myApiV1.MyDeployment, referenceChecksum string) appsV1.Deployment {
+	deploy := NewDeployment(myDeployment, referenceChecksum)
+	deploy.Name = canaryName(myDeployment)
+	deploy.Labels = newCanaryLabels(myDeployment)
+	deploy.Spec.Replicas = ptr.To(myDeployment.Spec.Canary.EffectiveReplicas())
+	deploy.Spec.Selector = &metav1.LabelSelector{
+		MatchLabels: newCanaryLabels(myDeployment),
+	}
+	deploy.Spec.Template.ObjectMeta.Name = canaryName(myDeployment)
+	deploy.Spec.Template.ObjectMeta.Labels = newCanaryLabels(myDeployment)
+	deploy.Spec.Template.Spec.Containers[0].Image = myDeployment.Spec.Canary.Image
+	return deploy
+}
+
+// NewCanaryService 生成只选择金丝雀 pod 的 service，作为金丝雀 Ingress 的后端
+func NewCanaryService(myDeployment *myApiV1.MyDeployment) coreV1.Service {
+	svc := newBaseService(myDeployment)
+	svc.Name = canaryName(myDeployment)
+	svc.Spec.Selector = newCanaryLabels(myDeployment)
+	svc.Spec.Ports = []coreV1.ServicePort{newServicePort(myDeployment)}
+	return svc
+}
+
+// NewCanaryIngress 生成 nginx 的金丝雀 Ingress，和主 Ingress 使用相同的域名，按 weight 把流量转发到金丝雀 service
+// nginx 只使用金丝雀 Ingress 上的 canary 相关注解，tls 沿用主 Ingress 的配置
+func NewCanaryIngress(myDeployment *myApiV1.MyDeployment) networkingV1.Ingress {
+	ingress := NewIngress(myDeployment)
+	ingress.Name = canaryName(myDeployment)
+	ingress.Annotations = map[string]string{
+		myApiV1.AnnotationNginxCanary:       "true",
+		myApiV1.AnnotationNginxCanaryWeight: strconv.Itoa(int(ptr.Deref(myDeployment.Spec.Canary.Weight, 0))),
+	}
+	ingress.Spec.TLS = nil
+	ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name = canaryName(myDeployment)
+	return ingress
+}
+
+func blueGreenName(myDeployment *myApiV1.MyDeployment, color string) string {
+	return myDeployment.Name + "-" + color
+}
+
+func previewServiceName(myDeployment *myApiV1.MyDeployment) string {
+	return myDeployment.Name + "-preview"
+}
+
+func newColorLabels(myDeployment *myApiV1.MyDeployment, color string) map[string]string {
+	labels := newLabels(myDeployment)
+	labels[myApiV1.LabelKeyColor] = color
+	return labels
+}
+
+// 另一个颜色，还没有 active 颜色时先部署 blue，preview 为 green
+func otherColor(color string) string {
+	if color == myApiV1.ColorGreen {
+		return myApiV1.ColorBlue
 	}
+	return myApiV1.ColorGreen
+}
+
+// 计算 pod template 的摘要，和 configChecksum 一样 json 序列化的结果是稳定的
+func templateHash(template *coreV1.PodTemplateSpec) string {
+	data, _ := json.Marshal(template)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// NewBlueGreenDeployment 生成蓝绿发布中指定颜色的 Deployment，pod template 和主 Deployment 相同，
+// 在添加颜色标签之前计算摘要，记录在注解上，两个颜色的摘要相同说明运行的是同一个版本
+func NewBlueGreenDeployment(myDeployment *myApiV1.MyDeployment, referenceChecksum, color string) appsV1.Deployment {
+	deploy := NewDeployment(myDeployment, referenceChecksum)
+	hash := templateHash(&deploy.Spec.Template)
+	deploy.Name = blueGreenName(myDeployment, color)
+	deploy.Labels = newColorLabels(myDeployment, color)
+	deploy.Annotations = map[string]string{
+		myApiV1.AnnotationTemplateHash: hash,
+	}
+	deploy.Spec.Selector = &metav1.LabelSelector{
+		MatchLabels: newColorLabels(myDeployment, color),
+	}
+	deploy.Spec.Template.ObjectMeta.Name = blueGreenName(myDeployment, color)
+	deploy.Spec.Template.ObjectMeta.Labels = newColorLabels(myDeployment, color)
+	return deploy
+}
+
+// NewPreviewService 生成指向 preview 颜色的 service，用来在切换前访问新版本
+func NewPreviewService(myDeployment *myApiV1.MyDeployment) coreV1.Service {
+	previewColor := myDeployment.Status.PreviewColor
+	if previewColor == "" {
+		previewColor = otherColor(myDeployment.Status.ActiveColor)
+	}
+	svc := newBaseService(myDeployment)
+	svc.Name = previewServiceName(myDeployment)
+	svc.Spec.Selector = newColorLabels(myDeployment, previewColor)
+	svc.Spec.Ports = []coreV1.ServicePort{newServicePort(myDeployment)}
+	return svc
+}
+
+// pod template 摘要的前 10 位，用于资源名称和信息
+func shortHash(hash string) string {
+	if len(hash) > 10 {
+		return hash[:10]
+	}
+	return hash
+}
+
+func knownGoodRevisionName(myDeployment *myApiV1.MyDeployment, hash string) string {
+	return myDeployment.Name + "-known-good-" + shortHash(hash)
+}
+
+// NewKnownGoodRevision 生成保存最后一次全部就绪的 pod template 的 ControllerRevision，
+// ControllerRevision 的 data 创建后不可修改，名称中带有摘要，pod template 变化后创建新的对象
+func NewKnownGoodRevision(myDeployment *myApiV1.MyDeployment, template *coreV1.PodTemplateSpec) (appsV1.ControllerRevision, error) {
+	data, err := json.Marshal(template)
+	if err != nil {
+		return appsV1.ControllerRevision{}, err
+	}
+	labels := newLabels(myDeployment)
+	labels[myApiV1.LabelKeyKnownGood] = "true"
+	return appsV1.ControllerRevision{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "apps/v1",
+			Kind:       "ControllerRevision",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      knownGoodRevisionName(myDeployment, templateHash(template)),
+			Namespace: myDeployment.Namespace,
+			Labels:    labels,
+		},
+		Data:     runtime.RawExtension{Raw: data},
+		Revision: myDeployment.Generation,
+	}, nil
+}
+
+// 计算 spec 的摘要，rollbackTo 和 revisionHistoryLimit 不影响子资源，不参与计算
+func specHash(spec myApiV1.MyDeploymentSpec) ([]byte, string, error) {
+	spec.RollbackTo = nil
+	spec.RevisionHistoryLimit = nil
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	return data, hex.EncodeToString(sum[:]), nil
+}
+
+// NewSpecRevision 生成保存 spec 历史版本的 ControllerRevision，名称中带有 spec 的摘要，
+// data 为 v1 的 spec，controller 使用 v1 读取，和存储版本无关
+func NewSpecRevision(myDeployment *myApiV1.MyDeployment, revision int64) (appsV1.ControllerRevision, error) {
+	data, hash, err := specHash(myDeployment.Spec)
+	if err != nil {
+		return appsV1.ControllerRevision{}, err
+	}
+	labels := newLabels(myDeployment)
+	labels[myApiV1.LabelKeySpecRevision] = "true"
+	return appsV1.ControllerRevision{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "apps/v1",
+			Kind:       "ControllerRevision",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      myDeployment.Name + "-" + shortHash(hash),
+			Namespace: myDeployment.Namespace,
+			Labels:    labels,
+		},
+		Data:     runtime.RawExtension{Raw: data},
+		Revision: revision,
+	}, nil
 }
 
 //func NewNodePortService(myDeployment *myApiV1.MyDeployment) (*coreV1.Service, error) {
@@ -232,7 +695,7 @@ func newServicePort(deployment *myApiV1.MyDeployment) coreV1.ServicePort {
 //}
 
 func NewIssuer(myDeployment *myApiV1.MyDeployment) (*unstructured.Unstructured, error) {
-	if myDeployment.Spec.Expose.Mode != myApiV1.ModeIngress || !myDeployment.Spec.Expose.Tls {
+	if !myDeployment.Spec.TLSEnabled() {
 		return nil, nil
 	}
 	//apiVersion: cert-manager.io/v1
@@ -258,7 +721,7 @@ func NewIssuer(myDeployment *myApiV1.MyDeployment) (*unstructured.Unstructured,
 }
 
 func NewCertificate(myDeployment *myApiV1.MyDeployment) (*unstructured.Unstructured, error) {
-	if myDeployment.Spec.Expose.Mode != myApiV1.ModeIngress || !myDeployment.Spec.Expose.Tls {
+	if !myDeployment.Spec.TLSEnabled() {
 		return nil, nil
 	}
 	/*
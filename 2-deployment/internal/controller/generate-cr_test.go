@@ -2,11 +2,14 @@ package controller
 
 import (
 	myApiV1 "deployment/api/v1"
+	"encoding/json"
 	"fmt"
 	appsV1 "k8s.io/api/apps/v1"
 	coreV1 "k8s.io/api/core/v1"
 	networkingV1 "k8s.io/api/networking/v1"
+	rbacV1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/utils/ptr"
 	"os"
 	"reflect"
 	"testing"
@@ -60,9 +63,60 @@ func newIngress(filename string) *networkingV1.Ingress {
 	return ingress
 }
 
+func newConfigMap(filename string) *coreV1.ConfigMap {
+	content := readFile(filename)
+	configMap := new(coreV1.ConfigMap)
+	err := yaml.Unmarshal(content, configMap)
+	if err != nil {
+		panic(err)
+	}
+	return configMap
+}
+
+func newPersistentVolumeClaim(filename string) *coreV1.PersistentVolumeClaim {
+	content := readFile(filename)
+	pvc := new(coreV1.PersistentVolumeClaim)
+	err := yaml.Unmarshal(content, pvc)
+	if err != nil {
+		panic(err)
+	}
+	return pvc
+}
+
+func newServiceAccount(filename string) *coreV1.ServiceAccount {
+	content := readFile(filename)
+	serviceAccount := new(coreV1.ServiceAccount)
+	err := yaml.Unmarshal(content, serviceAccount)
+	if err != nil {
+		panic(err)
+	}
+	return serviceAccount
+}
+
+func newRole(filename string) *rbacV1.Role {
+	content := readFile(filename)
+	role := new(rbacV1.Role)
+	err := yaml.Unmarshal(content, role)
+	if err != nil {
+		panic(err)
+	}
+	return role
+}
+
+func newRoleBinding(filename string) *rbacV1.RoleBinding {
+	content := readFile(filename)
+	roleBinding := new(rbacV1.RoleBinding)
+	err := yaml.Unmarshal(content, roleBinding)
+	if err != nil {
+		panic(err)
+	}
+	return roleBinding
+}
+
 func TestNewDeployment(t *testing.T) {
 	type args struct {
-		myDeployment *myApiV1.MyDeployment
+		myDeployment      *myApiV1.MyDeployment
+		referenceChecksum string
 	}
 	tests := []struct {
 		name    string
@@ -75,7 +129,7 @@ func TestNewDeployment(t *testing.T) {
 			args: args{
 				myDeployment: newMyDeployment("ingress-cr.yaml"),
 			},
-			want:    newDeployment("ingress-deployment-expect.yaml"),
+			want:    newDeployment("golden/ingress-deployment-expect.yaml"),
 			wantErr: false,
 		},
 		{
@@ -83,13 +137,86 @@ func TestNewDeployment(t *testing.T) {
 			args: args{
 				myDeployment: newMyDeployment("nodeport-cr.yaml"),
 			},
-			want:    newDeployment("nodeport-deployment-expect.yaml"),
+			want:    newDeployment("golden/nodeport-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试不设置 expose，生成 Deployment 资源",
+			args: args{
+				myDeployment: newMyDeployment("internal-cr.yaml"),
+			},
+			want:    newDeployment("golden/internal-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试金丝雀推广后，Deployment 使用金丝雀镜像",
+			args: args{
+				myDeployment: newMyDeployment("canary-promote-cr.yaml"),
+			},
+			want:    newDeployment("golden/canary-promote-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试使用 volumes、configFiles 和 storage，生成 Deployment 资源",
+			args: args{
+				myDeployment: newMyDeployment("volume-cr.yaml"),
+			},
+			want:    newDeployment("golden/volume-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试引用了 ConfigMap / Secret，生成带有引用摘要的 Deployment 资源",
+			args: args{
+				myDeployment:      newMyDeployment("reference-cr.yaml"),
+				referenceChecksum: "0123456789abcdef",
+			},
+			want:    newDeployment("golden/reference-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试使用 scheduling，生成带有调度配置的 Deployment 资源",
+			args: args{
+				myDeployment: newMyDeployment("scheduling-cr.yaml"),
+			},
+			want:    newDeployment("golden/scheduling-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试开启加固并覆盖部分安全配置，生成合并后的 Deployment 资源",
+			args: args{
+				myDeployment: newMyDeployment("security-cr.yaml"),
+			},
+			want:    newDeployment("golden/security-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试关闭加固，生成不带安全配置的 Deployment 资源",
+			args: args{
+				myDeployment: newMyDeployment("insecure-cr.yaml"),
+			},
+			want:    newDeployment("golden/insecure-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试使用 serviceAccount，生成使用指定 ServiceAccount 的 Deployment 资源",
+			args: args{
+				myDeployment: newMyDeployment("serviceaccount-cr.yaml"),
+			},
+			want:    newDeployment("golden/serviceaccount-deployment-expect.yaml"),
+			wantErr: false,
+		},
+		{
+			name: "测试使用 rollback，生成设置了 progressDeadlineSeconds 的 Deployment 资源",
+			args: args{
+				myDeployment: newMyDeployment("rollback-cr.yaml"),
+			},
+			want:    newDeployment("golden/rollback-deployment-expect.yaml"),
 			wantErr: false,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := NewDeployment(tt.args.myDeployment)
+			got := NewDeployment(tt.args.myDeployment, tt.args.referenceChecksum)
 			if !reflect.DeepEqual(got, *tt.want) {
 				t.Errorf("NewDeployment() got = %v, want %v", got, tt.want)
 			}
@@ -111,7 +238,7 @@ func TestNewIngress(t *testing.T) {
 			args: args{
 				myDeployment: newMyDeployment("ingress-cr.yaml"),
 			},
-			want: newIngress("ingress-ingress-expect.yaml"),
+			want: newIngress("golden/ingress-ingress-expect.yaml"),
 		},
 	}
 	for _, tt := range tests {
@@ -138,7 +265,7 @@ func TestNewNodePortService(t *testing.T) {
 			args: args{
 				myDeployment: newMyDeployment("nodeport-cr.yaml"),
 			},
-			want: newService("nodeport-service-expect.yaml"),
+			want: newService("golden/nodeport-service-expect.yaml"),
 		},
 	}
 	for _, tt := range tests {
@@ -165,7 +292,21 @@ func TestNewService(t *testing.T) {
 			args: args{
 				myDeployment: newMyDeployment("ingress-cr.yaml"),
 			},
-			want: newService("ingress-service-expect.yaml"),
+			want: newService("golden/ingress-service-expect.yaml"),
+		},
+		{
+			name: "测试不设置 expose，只在集群内部访问，生成 ClusterIP 类型的 Service 资源",
+			args: args{
+				myDeployment: newMyDeployment("internal-cr.yaml"),
+			},
+			want: newService("golden/internal-service-expect.yaml"),
+		},
+		{
+			name: "测试使用蓝绿发布，生成只选择 active 颜色 pod 的 Service 资源",
+			args: args{
+				myDeployment: newMyDeployment("bluegreen-cr.yaml"),
+			},
+			want: newService("golden/bluegreen-service-expect.yaml"),
 		},
 	}
 	for _, tt := range tests {
@@ -177,3 +318,675 @@ func TestNewService(t *testing.T) {
 		})
 	}
 }
+
+func TestNewConfigMap(t *testing.T) {
+	type args struct {
+		myDeployment *myApiV1.MyDeployment
+	}
+	tests := []struct {
+		name string
+		args args
+		want *coreV1.ConfigMap
+	}{
+		{
+			name: "测试使用 configFiles，生成 ConfigMap 资源",
+			args: args{
+				myDeployment: newMyDeployment("volume-cr.yaml"),
+			},
+			want: newConfigMap("golden/volume-configmap-expect.yaml"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewConfigMap(tt.args.myDeployment)
+			if !reflect.DeepEqual(got, *tt.want) {
+				t.Errorf("NewConfigMap() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPersistentVolumeClaim(t *testing.T) {
+	type args struct {
+		myDeployment *myApiV1.MyDeployment
+	}
+	tests := []struct {
+		name string
+		args args
+		want *coreV1.PersistentVolumeClaim
+	}{
+		{
+			name: "测试使用 storage，生成 PersistentVolumeClaim 资源",
+			args: args{
+				myDeployment: newMyDeployment("volume-cr.yaml"),
+			},
+			want: newPersistentVolumeClaim("golden/volume-pvc-expect.yaml"),
+		},
+		{
+			name: "测试不使用 storage，生成空的 PersistentVolumeClaim 资源",
+			args: args{
+				myDeployment: newMyDeployment("ingress-cr.yaml"),
+			},
+			want: &coreV1.PersistentVolumeClaim{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewPersistentVolumeClaim(tt.args.myDeployment)
+			if !reflect.DeepEqual(got, *tt.want) {
+				t.Errorf("NewPersistentVolumeClaim() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewServiceAccount(t *testing.T) {
+	type args struct {
+		myDeployment *myApiV1.MyDeployment
+	}
+	tests := []struct {
+		name string
+		args args
+		want *coreV1.ServiceAccount
+	}{
+		{
+			name: "测试使用 serviceAccount，生成 ServiceAccount 资源",
+			args: args{
+				myDeployment: newMyDeployment("serviceaccount-cr.yaml"),
+			},
+			want: newServiceAccount("golden/serviceaccount-serviceaccount-expect.yaml"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewServiceAccount(tt.args.myDeployment)
+			if !reflect.DeepEqual(got, *tt.want) {
+				t.Errorf("NewServiceAccount() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRole(t *testing.T) {
+	type args struct {
+		myDeployment *myApiV1.MyDeployment
+	}
+	tests := []struct {
+		name string
+		args args
+		want *rbacV1.Role
+	}{
+		{
+			name: "测试使用 serviceAccount，生成 Role 资源",
+			args: args{
+				myDeployment: newMyDeployment("serviceaccount-cr.yaml"),
+			},
+			want: newRole("golden/serviceaccount-role-expect.yaml"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewRole(tt.args.myDeployment)
+			if !reflect.DeepEqual(got, *tt.want) {
+				t.Errorf("NewRole() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRoleBinding(t *testing.T) {
+	type args struct {
+		myDeployment *myApiV1.MyDeployment
+	}
+	tests := []struct {
+		name string
+		args args
+		want *rbacV1.RoleBinding
+	}{
+		{
+			name: "测试使用 serviceAccount，生成 RoleBinding 资源",
+			args: args{
+				myDeployment: newMyDeployment("serviceaccount-cr.yaml"),
+			},
+			want: newRoleBinding("golden/serviceaccount-rolebinding-expect.yaml"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewRoleBinding(tt.args.myDeployment)
+			if !reflect.DeepEqual(got, *tt.want) {
+				t.Errorf("NewRoleBinding() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCanaryDeployment(t *testing.T) {
+	type args struct {
+		myDeployment *myApiV1.MyDeployment
+	}
+	tests := []struct {
+		name string
+		args args
+		want *appsV1.Deployment
+	}{
+		{
+			name: "测试使用 canary，生成金丝雀 Deployment 资源",
+			args: args{
+				myDeployment: newMyDeployment("canary-cr.yaml"),
+			},
+			want: newDeployment("golden/canary-canary-deployment-expect.yaml"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewCanaryDeployment(tt.args.myDeployment, "")
+			if !reflect.DeepEqual(got, *tt.want) {
+				t.Errorf("NewCanaryDeployment() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCanaryService(t *testing.T) {
+	type args struct {
+		myDeployment *myApiV1.MyDeployment
+	}
+	tests := []struct {
+		name string
+		args args
+		want *coreV1.Service
+	}{
+		{
+			name: "测试使用 canary，生成只选择金丝雀 pod 的 Service 资源",
+			args: args{
+				myDeployment: newMyDeployment("canary-cr.yaml"),
+			},
+			want: newService("golden/canary-canary-service-expect.yaml"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewCanaryService(tt.args.myDeployment)
+			if !reflect.DeepEqual(got, *tt.want) {
+				t.Errorf("NewCanaryService() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCanaryIngress(t *testing.T) {
+	type args struct {
+		myDeployment *myApiV1.MyDeployment
+	}
+	tests := []struct {
+		name string
+		args args
+		want *networkingV1.Ingress
+	}{
+		{
+			name: "测试使用 canary weight，生成带有 nginx canary 注解的 Ingress 资源",
+			args: args{
+				myDeployment: newMyDeployment("canary-cr.yaml"),
+			},
+			want: newIngress("golden/canary-canary-ingress-expect.yaml"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewCanaryIngress(tt.args.myDeployment)
+			if !reflect.DeepEqual(got, *tt.want) {
+				t.Errorf("NewCanaryIngress() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewBlueGreenDeployment(t *testing.T) {
+	type args struct {
+		myDeployment *myApiV1.MyDeployment
+		color        string
+	}
+	tests := []struct {
+		name string
+		args args
+		want *appsV1.Deployment
+	}{
+		{
+			name: "测试使用蓝绿发布，生成 active 颜色的 Deployment 资源",
+			args: args{
+				myDeployment: newMyDeployment("bluegreen-cr.yaml"),
+				color:        myApiV1.ColorBlue,
+			},
+			want: newDeployment("golden/bluegreen-blue-deployment-expect.yaml"),
+		},
+		{
+			name: "测试使用蓝绿发布，生成 preview 颜色的 Deployment 资源",
+			args: args{
+				myDeployment: newMyDeployment("bluegreen-cr.yaml"),
+				color:        myApiV1.ColorGreen,
+			},
+			want: newDeployment("golden/bluegreen-green-deployment-expect.yaml"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewBlueGreenDeployment(tt.args.myDeployment, "", tt.args.color)
+			// 摘要和主 Deployment 的 pod template 相同，切换颜色时通过摘要判断是否需要更新
+			deploy := NewDeployment(tt.args.myDeployment, "")
+			if hash := templateHash(&deploy.Spec.Template); got.Annotations[myApiV1.AnnotationTemplateHash] != hash {
+				t.Errorf("NewBlueGreenDeployment() hash = %v, want %v", got.Annotations[myApiV1.AnnotationTemplateHash], hash)
+			}
+			if !reflect.DeepEqual(got, *tt.want) {
+				t.Errorf("NewBlueGreenDeployment() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPreviewService(t *testing.T) {
+	type args struct {
+		myDeployment *myApiV1.MyDeployment
+	}
+	tests := []struct {
+		name string
+		args args
+		want *coreV1.Service
+	}{
+		{
+			name: "测试使用蓝绿发布，生成只选择 preview 颜色 pod 的 Service 资源",
+			args: args{
+				myDeployment: newMyDeployment("bluegreen-cr.yaml"),
+			},
+			want: newService("golden/bluegreen-preview-service-expect.yaml"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewPreviewService(tt.args.myDeployment)
+			if !reflect.DeepEqual(got, *tt.want) {
+				t.Errorf("NewPreviewService() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewKnownGoodRevision(t *testing.T) {
+	myDeployment := newMyDeployment("rollback-cr.yaml")
+	deployment := NewDeployment(myDeployment, "")
+	got, err := NewKnownGoodRevision(myDeployment, &deployment.Spec.Template)
+	if err != nil {
+		t.Fatalf("NewKnownGoodRevision() error = %v", err)
+	}
+	hash := templateHash(&deployment.Spec.Template)
+	if want := "mydeployment-test-known-good-" + hash[:10]; got.Name != want {
+		t.Errorf("NewKnownGoodRevision() name = %v, want %v", got.Name, want)
+	}
+	if got.Labels[myApiV1.LabelKeyKnownGood] != "true" {
+		t.Errorf("NewKnownGoodRevision() labels = %v, want %s label", got.Labels, myApiV1.LabelKeyKnownGood)
+	}
+	// 保存的 pod template 反序列化后和原来的相同，回滚时才能得到相同的摘要
+	template := coreV1.PodTemplateSpec{}
+	if err := json.Unmarshal(got.Data.Raw, &template); err != nil {
+		t.Fatalf("unmarshal data error = %v", err)
+	}
+	if templateHash(&template) != hash {
+		t.Errorf("NewKnownGoodRevision() data = %s, want template %v", got.Data.Raw, deployment.Spec.Template)
+	}
+}
+
+func TestNewVolumes(t *testing.T) {
+	projected := coreV1.Volume{
+		Name: "projected",
+		VolumeSource: coreV1.VolumeSource{
+			Projected: &coreV1.ProjectedVolumeSource{
+				Sources: []coreV1.VolumeProjection{
+					{ConfigMap: &coreV1.ConfigMapProjection{LocalObjectReference: coreV1.LocalObjectReference{Name: "app-config"}}},
+					{Secret: &coreV1.SecretProjection{LocalObjectReference: coreV1.LocalObjectReference{Name: "app-secret"}}},
+					{ServiceAccountToken: &coreV1.ServiceAccountTokenProjection{Audience: "vault", Path: "token"}},
+				},
+				DefaultMode: ptr.To(int32(0440)),
+			},
+		},
+	}
+	configFilesVolume := coreV1.Volume{
+		Name: myApiV1.VolumeNameConfigFiles,
+		VolumeSource: coreV1.VolumeSource{
+			ConfigMap: &coreV1.ConfigMapVolumeSource{
+				LocalObjectReference: coreV1.LocalObjectReference{Name: "mydeployment-test-config"},
+			},
+		},
+	}
+	storageVolume := coreV1.Volume{
+		Name: myApiV1.VolumeNameStorage,
+		VolumeSource: coreV1.VolumeSource{
+			PersistentVolumeClaim: &coreV1.PersistentVolumeClaimVolumeSource{ClaimName: "mydeployment-test-data"},
+		},
+	}
+	tests := []struct {
+		name    string
+		mutate  func(spec *myApiV1.MyDeploymentSpec)
+		want    []coreV1.Volume
+		wantNil bool
+	}{
+		{
+			name:    "测试不设置卷，生成 nil",
+			mutate:  func(spec *myApiV1.MyDeploymentSpec) {},
+			wantNil: true,
+		},
+		{
+			name: "测试设置空的 volumes 切片，生成 nil，和不设置相同",
+			mutate: func(spec *myApiV1.MyDeploymentSpec) {
+				spec.Volumes = []coreV1.Volume{}
+			},
+			wantNil: true,
+		},
+		{
+			name: "测试使用 projected 卷，原样使用用户定义的卷",
+			mutate: func(spec *myApiV1.MyDeploymentSpec) {
+				spec.Volumes = []coreV1.Volume{projected}
+			},
+			want: []coreV1.Volume{projected},
+		},
+		{
+			name: "测试同时使用 volumes、configFiles 和 storage，生成的卷在用户定义的卷之后",
+			mutate: func(spec *myApiV1.MyDeploymentSpec) {
+				spec.Volumes = []coreV1.Volume{projected}
+				spec.ConfigFiles = map[string]string{"app.conf": "debug=false"}
+				spec.Storage = &myApiV1.Storage{MountPath: "/data"}
+			},
+			want: []coreV1.Volume{projected, configFilesVolume, storageVolume},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			myDeployment := newMyDeployment("internal-cr.yaml")
+			tt.mutate(&myDeployment.Spec)
+			got := newVolumes(myDeployment)
+			if tt.wantNil {
+				if got != nil {
+					t.Errorf("newVolumes() got = %#v, want nil", got)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newVolumes() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewVolumeMounts(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(spec *myApiV1.MyDeploymentSpec)
+		want    []coreV1.VolumeMount
+		wantNil bool
+	}{
+		{
+			name: "测试设置空的 volumeMounts 切片，生成 nil，和不设置相同",
+			mutate: func(spec *myApiV1.MyDeploymentSpec) {
+				spec.VolumeMounts = []coreV1.VolumeMount{}
+			},
+			wantNil: true,
+		},
+		{
+			name: "测试不设置 configFilesMountPath，configFiles 挂载到默认目录",
+			mutate: func(spec *myApiV1.MyDeploymentSpec) {
+				spec.ConfigFiles = map[string]string{"app.conf": "debug=false"}
+			},
+			want: []coreV1.VolumeMount{
+				{Name: myApiV1.VolumeNameConfigFiles, MountPath: myApiV1.DefaultConfigFilesMountPath, ReadOnly: true},
+			},
+		},
+		{
+			name: "测试设置 configFilesMountPath 和 storage，生成的挂载在用户定义的挂载之后",
+			mutate: func(spec *myApiV1.MyDeploymentSpec) {
+				spec.VolumeMounts = []coreV1.VolumeMount{{Name: "projected", MountPath: "/var/run/secrets/app"}}
+				spec.ConfigFiles = map[string]string{"app.conf": "debug=false"}
+				spec.ConfigFilesMountPath = "/app/config"
+				spec.Storage = &myApiV1.Storage{MountPath: "/data"}
+			},
+			want: []coreV1.VolumeMount{
+				{Name: "projected", MountPath: "/var/run/secrets/app"},
+				{Name: myApiV1.VolumeNameConfigFiles, MountPath: "/app/config", ReadOnly: true},
+				{Name: myApiV1.VolumeNameStorage, MountPath: "/data"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			myDeployment := newMyDeployment("internal-cr.yaml")
+			tt.mutate(&myDeployment.Spec)
+			got := newVolumeMounts(myDeployment)
+			if tt.wantNil {
+				if got != nil {
+					t.Errorf("newVolumeMounts() got = %#v, want nil", got)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newVolumeMounts() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPodSecurityContext(t *testing.T) {
+	hardened := &coreV1.PodSecurityContext{
+		RunAsNonRoot:   ptr.To(true),
+		SeccompProfile: &coreV1.SeccompProfile{Type: coreV1.SeccompProfileTypeRuntimeDefault},
+	}
+	tests := []struct {
+		name            string
+		securityContext *myApiV1.SecurityContext
+		want            *coreV1.PodSecurityContext
+	}{
+		{
+			name:            "测试不设置 securityContext，默认开启加固",
+			securityContext: nil,
+			want:            hardened,
+		},
+		{
+			name:            "测试设置空的 securityContext，默认开启加固",
+			securityContext: &myApiV1.SecurityContext{},
+			want:            hardened,
+		},
+		{
+			name:            "测试关闭加固且不设置 pod，生成 nil",
+			securityContext: &myApiV1.SecurityContext{Hardened: ptr.To(false)},
+			want:            nil,
+		},
+		{
+			name: "测试关闭加固，原样使用用户设置的 pod 安全配置",
+			securityContext: &myApiV1.SecurityContext{
+				Hardened: ptr.To(false),
+				Pod:      &coreV1.PodSecurityContext{RunAsUser: ptr.To(int64(0))},
+			},
+			want: &coreV1.PodSecurityContext{RunAsUser: ptr.To(int64(0))},
+		},
+		{
+			name: "测试开启加固并覆盖 runAsNonRoot 和 seccomp，用户设置的字段优先",
+			securityContext: &myApiV1.SecurityContext{
+				Pod: &coreV1.PodSecurityContext{
+					RunAsNonRoot: ptr.To(false),
+					SeccompProfile: &coreV1.SeccompProfile{
+						Type:             coreV1.SeccompProfileTypeLocalhost,
+						LocalhostProfile: ptr.To("profiles/app.json"),
+					},
+				},
+			},
+			want: &coreV1.PodSecurityContext{
+				RunAsNonRoot: ptr.To(false),
+				SeccompProfile: &coreV1.SeccompProfile{
+					Type:             coreV1.SeccompProfileTypeLocalhost,
+					LocalhostProfile: ptr.To("profiles/app.json"),
+				},
+			},
+		},
+		{
+			name: "测试开启加固并设置其他字段，补全加固的默认配置",
+			securityContext: &myApiV1.SecurityContext{
+				Pod: &coreV1.PodSecurityContext{RunAsUser: ptr.To(int64(1000)), FSGroup: ptr.To(int64(2000))},
+			},
+			want: &coreV1.PodSecurityContext{
+				RunAsUser:      ptr.To(int64(1000)),
+				FSGroup:        ptr.To(int64(2000)),
+				RunAsNonRoot:   ptr.To(true),
+				SeccompProfile: &coreV1.SeccompProfile{Type: coreV1.SeccompProfileTypeRuntimeDefault},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := tt.securityContext.DeepCopy()
+			got := newPodSecurityContext(tt.securityContext)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newPodSecurityContext() got = %v, want %v", got, tt.want)
+			}
+			// 补全默认配置时不能修改 spec 中的对象
+			if !reflect.DeepEqual(tt.securityContext, before) {
+				t.Errorf("newPodSecurityContext() modified input, got %v, want %v", tt.securityContext, before)
+			}
+		})
+	}
+}
+
+func TestNewContainerSecurityContext(t *testing.T) {
+	hardened := &coreV1.SecurityContext{
+		AllowPrivilegeEscalation: ptr.To(false),
+		Capabilities:             &coreV1.Capabilities{Drop: []coreV1.Capability{"ALL"}},
+		ReadOnlyRootFilesystem:   ptr.To(true),
+	}
+	tests := []struct {
+		name            string
+		securityContext *myApiV1.SecurityContext
+		want            *coreV1.SecurityContext
+	}{
+		{
+			name:            "测试不设置 securityContext，默认开启加固",
+			securityContext: nil,
+			want:            hardened,
+		},
+		{
+			name:            "测试关闭加固且不设置 container，生成 nil",
+			securityContext: &myApiV1.SecurityContext{Hardened: ptr.To(false)},
+			want:            nil,
+		},
+		{
+			name: "测试开启加固并设置 capabilities，不再丢弃所有 capabilities",
+			securityContext: &myApiV1.SecurityContext{
+				Container: &coreV1.SecurityContext{
+					Capabilities: &coreV1.Capabilities{Add: []coreV1.Capability{"NET_BIND_SERVICE"}},
+				},
+			},
+			want: &coreV1.SecurityContext{
+				AllowPrivilegeEscalation: ptr.To(false),
+				Capabilities:             &coreV1.Capabilities{Add: []coreV1.Capability{"NET_BIND_SERVICE"}},
+				ReadOnlyRootFilesystem:   ptr.To(true),
+			},
+		},
+		{
+			name: "测试开启加固并显式设置为 false，用户设置的字段优先",
+			securityContext: &myApiV1.SecurityContext{
+				Container: &coreV1.SecurityContext{
+					AllowPrivilegeEscalation: ptr.To(true),
+					ReadOnlyRootFilesystem:   ptr.To(false),
+				},
+			},
+			want: &coreV1.SecurityContext{
+				AllowPrivilegeEscalation: ptr.To(true),
+				Capabilities:             &coreV1.Capabilities{Drop: []coreV1.Capability{"ALL"}},
+				ReadOnlyRootFilesystem:   ptr.To(false),
+			},
+		},
+		{
+			name: "测试关闭加固，原样使用用户设置的容器安全配置",
+			securityContext: &myApiV1.SecurityContext{
+				Hardened:  ptr.To(false),
+				Container: &coreV1.SecurityContext{Privileged: ptr.To(true)},
+			},
+			want: &coreV1.SecurityContext{Privileged: ptr.To(true)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := tt.securityContext.DeepCopy()
+			got := newContainerSecurityContext(tt.securityContext)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newContainerSecurityContext() got = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.securityContext, before) {
+				t.Errorf("newContainerSecurityContext() modified input, got %v, want %v", tt.securityContext, before)
+			}
+		})
+	}
+}
+
+func TestSetScheduling(t *testing.T) {
+	affinity := &coreV1.Affinity{
+		NodeAffinity: &coreV1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &coreV1.NodeSelector{
+				NodeSelectorTerms: []coreV1.NodeSelectorTerm{{
+					MatchExpressions: []coreV1.NodeSelectorRequirement{{
+						Key: "kubernetes.io/arch", Operator: coreV1.NodeSelectorOpIn, Values: []string{"amd64"},
+					}},
+				}},
+			},
+		},
+	}
+	tolerations := []coreV1.Toleration{{Key: "dedicated", Operator: coreV1.TolerationOpEqual, Value: "app", Effect: coreV1.TaintEffectNoSchedule}}
+	tests := []struct {
+		name       string
+		scheduling *myApiV1.Scheduling
+		want       coreV1.PodSpec
+	}{
+		{
+			name:       "测试不设置 scheduling，不修改 pod spec",
+			scheduling: nil,
+			want:       coreV1.PodSpec{},
+		},
+		{
+			name:       "测试设置空的 scheduling，不修改 pod spec",
+			scheduling: &myApiV1.Scheduling{},
+			want:       coreV1.PodSpec{},
+		},
+		{
+			name: "测试设置全部调度配置，原样写入 pod spec",
+			scheduling: &myApiV1.Scheduling{
+				NodeSelector:      map[string]string{"disktype": "ssd"},
+				Tolerations:       tolerations,
+				Affinity:          affinity,
+				PriorityClassName: "high-priority",
+				TopologySpreadConstraints: []coreV1.TopologySpreadConstraint{{
+					MaxSkew: 1, TopologyKey: "topology.kubernetes.io/zone", WhenUnsatisfiable: coreV1.ScheduleAnyway,
+				}},
+			},
+			want: coreV1.PodSpec{
+				NodeSelector:      map[string]string{"disktype": "ssd"},
+				Tolerations:       tolerations,
+				Affinity:          affinity,
+				PriorityClassName: "high-priority",
+				TopologySpreadConstraints: []coreV1.TopologySpreadConstraint{{
+					MaxSkew: 1, TopologyKey: "topology.kubernetes.io/zone", WhenUnsatisfiable: coreV1.ScheduleAnyway,
+				}},
+			},
+		},
+		{
+			name: "测试只设置 tolerations，其他调度配置保持为空",
+			scheduling: &myApiV1.Scheduling{
+				Tolerations: tolerations,
+			},
+			want: coreV1.PodSpec{Tolerations: tolerations},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := coreV1.PodSpec{}
+			setScheduling(&got, tt.scheduling)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("setScheduling() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package controller
+
+import (
+	myApiV1 "deployment/api/v1"
+	"errors"
+	"flag"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"testing"
+)
+
+// 重新生成 golden 文件：go test ./internal/controller/ -run TestGolden -update
+var update = flag.Bool("update", false, "update golden files in testdata/golden")
+
+// golden 文件的目录，所有生成函数的期望结果都在这里，文件名为 <cr 名称>-<kind>-expect.yaml
+const goldenDir = "testdata/golden"
+
+// 引用了 ConfigMap / Secret 的 MyDeployment 使用固定的摘要，测试引用摘要写入 pod template 的注解
+const goldenReferenceChecksum = "0123456789abcdef"
+
+func goldenChecksum(myDeployment *myApiV1.MyDeployment) string {
+	if len(referencedConfigMaps(myDeployment)) == 0 && len(referencedSecrets(myDeployment)) == 0 {
+		return ""
+	}
+	return goldenReferenceChecksum
+}
+
+// 每个生成函数返回要保存的对象，返回 nil 表示这个 MyDeployment 不需要这个资源，判断条件和 reconciler 相同
+var goldenGenerators = []struct {
+	kind     string
+	generate func(myDeployment *myApiV1.MyDeployment) (interface{}, error)
+}{
+	{
+		// 蓝绿发布不使用滚动更新的 Deployment
+		kind: "deployment",
+		generate: func(myDeployment *myApiV1.MyDeployment) (interface{}, error) {
+			if myDeployment.Spec.IsBlueGreen() {
+				return nil, nil
+			}
+			return NewDeployment(myDeployment, goldenChecksum(myDeployment)), nil
+		},
+	},
+	{
+		kind: "service",
+		generate: func(myDeployment *myApiV1.MyDeployment) (interface{}, error) {
+			return NewService(myDeployment), nil
+		},
+	},
+	{
+		kind: "ingress",
+		generate: func(myDeployment *myApiV1.MyDeployment) (interface{}, error) {
+			if myDeployment.Spec.ExposeMode() != myApiV1.ModeIngress {
+				return nil, nil
+			}
+			return NewIngress(myDeployment), nil
+		},
+	},
+	{
+		kind: "issuer",
+		generate: func(myDeployment *myApiV1.MyDeployment) (interface{}, error) {
+			issuer, err := NewIssuer(myDeployment)
+			if issuer == nil {
+				return nil, err
+			}
+			return issuer, err
+		},
+	},
+	{
+		kind: "certificate",
+		generate: func(myDeployment *myApiV1.MyDeployment) (interface{}, error) {
+			certificate, err := NewCertificate(myDeployment)
+			if certificate == nil {
+				return nil, err
+			}
+			return certificate, err
+		},
+	},
+	{
+		kind: "configmap",
+		generate: func(myDeployment *myApiV1.MyDeployment) (interface{}, error) {
+			if len(myDeployment.Spec.ConfigFiles) == 0 {
+				return nil, nil
+			}
+			return NewConfigMap(myDeployment), nil
+		},
+	},
+	{
+		kind: "pvc",
+		generate: func(myDeployment *myApiV1.MyDeployment) (interface{}, error) {
+			if myDeployment.Spec.Storage == nil {
+				return nil, nil
+			}
+			return NewPersistentVolumeClaim(myDeployment), nil
+		},
+	},
+	{
+		kind: "serviceaccount",
+		generate: func(myDeployment *myApiV1.MyDeployment) (interface{}, error) {
+			if myDeployment.Spec.ServiceAccount == nil || !myDeployment.Spec.ServiceAccount.Create {
+				return nil, nil
+			}
+			return NewServiceAccount(myDeployment), nil
+		},
+	},
+	{
+		kind: "role",
+		generate: func(myDeployment *myApiV1.MyDeployment) (interface{}, error) {
+			if !serviceAccountWithRules(myDeployment) {
+				return nil, nil
+			}
+			return NewRole(myDeployment), nil
+		},
+	},
+	{
+		kind: "rolebinding",
+		generate: func(myDeployment *myApiV1.MyDeployment) (interface{}, error) {
+			if !serviceAccountWithRules(myDeployment) {
+				return nil, nil
+			}
+			return NewRoleBinding(myDeployment), nil
+		},
+	},
+	{
+		kind: "canary-deployment",
+		generate: func(myDeployment *myApiV1.MyDeployment) (interface{}, error) {
+			if !myDeployment.Spec.CanaryInProgress() {
+				return nil, nil
+			}
+			return NewCanaryDeployment(myDeployment, goldenChecksum(myDeployment)), nil
+		},
+	},
+	{
+		kind: "canary-service",
+		generate: func(myDeployment *myApiV1.MyDeployment) (interface{}, error) {
+			if !canaryWithIngress(myDeployment) {
+				return nil, nil
+			}
+			return NewCanaryService(myDeployment), nil
+		},
+	},
+	{
+		kind: "canary-ingress",
+		generate: func(myDeployment *myApiV1.MyDeployment) (interface{}, error) {
+			if !canaryWithIngress(myDeployment) {
+				return nil, nil
+			}
+			return NewCanaryIngress(myDeployment), nil
+		},
+	},
+	{
+		kind: "blue-deployment",
+		generate: func(myDeployment *myApiV1.MyDeployment) (interface{}, error) {
+			if !myDeployment.Spec.IsBlueGreen() {
+				return nil, nil
+			}
+			return NewBlueGreenDeployment(myDeployment, goldenChecksum(myDeployment), myApiV1.ColorBlue), nil
+		},
+	},
+	{
+		kind: "green-deployment",
+		generate: func(myDeployment *myApiV1.MyDeployment) (interface{}, error) {
+			if !myDeployment.Spec.IsBlueGreen() {
+				return nil, nil
+			}
+			return NewBlueGreenDeployment(myDeployment, goldenChecksum(myDeployment), myApiV1.ColorGreen), nil
+		},
+	},
+	{
+		kind: "preview-service",
+		generate: func(myDeployment *myApiV1.MyDeployment) (interface{}, error) {
+			if !myDeployment.Spec.IsBlueGreen() {
+				return nil, nil
+			}
+			return NewPreviewService(myDeployment), nil
+		},
+	},
+}
+
+// 遍历 testdata 中所有的 *-cr.yaml，生成资源并和 testdata/golden/<name>-<kind>-expect.yaml 比较，
+// 新增 cr 或生成函数后使用 -update 生成期望结果，检查 diff 后提交
+func TestGolden(t *testing.T) {
+	crs, err := filepath.Glob("testdata/*-cr.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crs) == 0 {
+		t.Fatal("no *-cr.yaml found in testdata")
+	}
+	if *update {
+		if err := os.MkdirAll(goldenDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, cr := range crs {
+		name := strings.TrimSuffix(filepath.Base(cr), "-cr.yaml")
+		myDeployment := newMyDeployment(filepath.Base(cr))
+		for _, generator := range goldenGenerators {
+			t.Run(name+"/"+generator.kind, func(t *testing.T) {
+				got, err := generator.generate(myDeployment.DeepCopy())
+				if err != nil {
+					t.Fatalf("generate %s: %v", generator.kind, err)
+				}
+				checkGolden(t, filepath.Join(goldenDir, name+"-"+generator.kind+"-expect.yaml"), got)
+			})
+		}
+	}
+}
+
+// 比较生成的对象和 golden 文件，对象为 nil 时 golden 文件不应该存在
+func checkGolden(t *testing.T, path string, got interface{}) {
+	t.Helper()
+	// 1. 不需要这个资源
+	if got == nil {
+		if *update {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				t.Fatal(err)
+			}
+			return
+		}
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("%s exists, but no object was generated, run with -update to remove it", path)
+		}
+		return
+	}
+	gotData, err := yaml.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2. 更新 golden 文件
+	if *update {
+		if err := os.WriteFile(path, gotData, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	// 3. 比较
+	wantData, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run with -update to create it", err)
+	}
+	if diff := cmp.Diff(string(wantData), string(gotData)); diff != "" {
+		t.Errorf("%s mismatch (-want +got):\n%s", path, diff)
+	}
+}
@@ -19,19 +19,25 @@ package controller
 import (
 	"context"
 	myApiV1 "deployment/api/v1"
+	"encoding/json"
 	"fmt"
 	appsV1 "k8s.io/api/apps/v1"
 	coreV1 "k8s.io/api/core/v1"
 	networkingV1 "k8s.io/api/networking/v1"
+	rbacV1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/client-go/dynamic"
-	"reflect"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"time"
 )
@@ -44,6 +50,10 @@ type MyDeploymentReconciler struct {
 	Scheme *runtime.Scheme
 	// 用来访问 issuer 和 certificate 资源
 	DynamicClient dynamic.Interface
+	// APIReader 不经过缓存直接读取 apiserver，用来在 Deployment 未就绪时读取 pod 的状态，为空时使用 Client
+	APIReader client.Reader
+	// Recorder 在 MyDeployment 上产生事件，比如自动回滚，为空时不产生事件
+	Recorder record.EventRecorder
 }
 
 // https 2. 创建动态 GVR
@@ -66,7 +76,19 @@ var (
 // +kubebuilder:rbac:groups=apps.shudong.com,resources=mydeployments/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=apps.shudong.com,resources=mydeployments/finalizers,verbs=update
 // +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list
+// rbac 1. 生成 ServiceAccount / Role / RoleBinding 需要的权限，
+// escalate 和 bind 允许 operator 授予自己没有的权限，否则 apiserver 会拒绝创建 Role 和 RoleBinding
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles,verbs=escalate;bind
 // +kubebuilder:rbac:groups="apps",resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// rollback 6. 保存最后一次全部就绪的 pod template，以及产生回滚事件需要的权限
+// +kubebuilder:rbac:groups="apps",resources=controllerrevisions,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 // +kubebuilder:rbac:groups="networking.k8s.io",resources=ingresses,verbs=get;list;watch;create;update;patch;delete
 // https 3. 创建 issuer certificate GVR 需要的权限
 // +kubebuilder:rbac:groups=cert-manager.io,resources=issuers,verbs=get;list;watch;create;update;patch
@@ -81,7 +103,7 @@ var (
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.19.1/pkg/reconcile
-func (r *MyDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *MyDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, reterr error) {
 	// 状态更新策略
 	// 创建的时候
 	//		更新为创建
@@ -103,160 +125,157 @@ func (r *MyDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Request
 
 	// 处理最终的返回
 	defer func() {
+		var updateErr error
 		if r.Ready(myDeploymentCopy) {
-			_ = r.Client.Status().Update(ctx, myDeploymentCopy)
-			return
+			// revision 2. 子资源全部就绪后，currentRevision 更新为正在使用的版本
+			myDeploymentCopy.Status.CurrentRevision = myDeploymentCopy.Status.UpdateRevision
+			updateErr = r.Client.Status().Update(ctx, myDeploymentCopy)
+		} else if myDeploymentCopy.Status.ObservedGeneration != myDeployment.Status.ObservedGeneration {
+			updateErr = r.Client.Status().Update(ctx, myDeploymentCopy)
 		}
-		if myDeploymentCopy.Status.ObservedGeneration != myDeployment.Status.ObservedGeneration {
-			_ = r.Client.Status().Update(ctx, myDeploymentCopy)
+		// status 更新失败时返回错误重新入队，已经有错误时只记录日志
+		if updateErr != nil {
+			if reterr == nil {
+				reterr = updateErr
+				return
+			}
+			logger.Error(updateErr, "unable to update MyDeployment status")
 		}
 	}()
 
-	// ============ 处理 deployment ===============
-	// 2. 获取 deployment 资源对象
-	deployment := new(appsV1.Deployment)
-	err = r.Get(ctx, req.NamespacedName, deployment)
+	// ============ 处理 revision ===============
+	// revision 1. 保存 spec 的历史版本，设置了 spec.rollbackTo 时使用历史版本的 spec 生成子资源，要先于其他子资源处理
+	found, err := r.reconcileRevisions(ctx, myDeploymentCopy)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			// 2.1 不存在对象
-			// 2.1.1 创建 deployment
-			errCreate := r.createDeployment(ctx, myDeploymentCopy)
-			if errCreate != nil {
-				return ctrl.Result{}, errCreate
-			}
-			r.updateConditions(myDeploymentCopy, myApiV1.ConditionTypeDeployment,
-				fmt.Sprintf(myApiV1.ConditionMessageDeploymentNotOKFmt, req.Name),
-				myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonDeploymentNotReady)
-		} else {
-			r.updateConditions(myDeploymentCopy, myApiV1.ConditionTypeDeployment,
-				fmt.Sprintf("Deployment %s, err: %s", req.Name, err.Error()),
-				myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonDeploymentNotReady)
-			return ctrl.Result{}, err
-		}
-	} else {
-		// 2.2 存在对象
-		// 2.2.1 更新 deployment
-		err := r.updateDeployment(ctx, myDeploymentCopy, deployment)
-		if err != nil {
-			return ctrl.Result{}, err
-		}
-		if *deployment.Spec.Replicas == deployment.Status.ReadyReplicas {
-			r.updateConditions(myDeploymentCopy, myApiV1.ConditionTypeDeployment,
-				fmt.Sprintf(myApiV1.ConditionMessageDeploymentOKFmt, req.Name),
-				myApiV1.ConditionStatusTrue, myApiV1.ConditionReasonDeploymentReady)
-		} else {
-			r.updateConditions(myDeploymentCopy, myApiV1.ConditionTypeDeployment,
-				fmt.Sprintf(myApiV1.ConditionMessageDeploymentNotOKFmt, req.Name),
-				myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonDeploymentNotReady)
+		return ctrl.Result{}, err
+	}
+	if !found {
+		// 版本不存在时不修改子资源，等待用户修改 spec.rollbackTo
+		r.updateConditions(myDeploymentCopy, myApiV1.ConditionTypeRevision,
+			fmt.Sprintf(myApiV1.ConditionMessageRevisionNotFoundFmt, *myDeploymentCopy.Spec.RollbackTo, req.Name),
+			myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonRevisionNotFound)
+		return ctrl.Result{}, nil
+	}
+	r.deleteStatus(myDeploymentCopy, myApiV1.ConditionTypeRevision)
 
-		}
+	// ============ 处理 configmap ===============
+	// volume 1. configmap 和 pvc 要先于 deployment 处理，pod 启动的时候才能挂载到
+	_, err = r.reconcileChild(ctx, myDeploymentCopy, &configMapChild{})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
 
+	// ============ 处理 pvc ===============
+	// volume 2. 设置了 storage 时同步 pvc，删除 storage 后为了防止误操作丢失数据，不删除 pvc，只是不再挂载，
+	// pvc 会随着 MyDeployment 的删除被回收
+	_, err = r.reconcileChild(ctx, myDeploymentCopy, &pvcChild{})
+	if err != nil {
+		return ctrl.Result{}, err
 	}
 
-	// ============ 处理 service ===============
-	// 3. 获取 service 资源对象
-	service := new(coreV1.Service)
-	err = r.Get(ctx, req.NamespacedName, service)
+	// ============ 处理 serviceaccount ===============
+	// rbac 2. serviceaccount 要先于 deployment 处理，pod 创建的时候 serviceaccount 需要已经存在
+	err = r.reconcileServiceAccount(ctx, myDeploymentCopy)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			// 3.1 不存在对象, 创建 service
-			err := r.createService(ctx, myDeploymentCopy)
-			if err != nil {
-				return ctrl.Result{}, err
-			}
+		r.updateConditions(myDeploymentCopy, myApiV1.ConditionTypeServiceAccount,
+			fmt.Sprintf("ServiceAccount %s, err: %s", serviceAccountName(myDeploymentCopy), err.Error()),
+			myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonServiceAccountNotReady)
+		return ctrl.Result{}, err
+	}
 
-			r.updateConditions(myDeploymentCopy, myApiV1.ConditionTypeService,
-				fmt.Sprintf(myApiV1.ConditionMessageServiceNotOKFmt, req.Name),
-				myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonServiceNotReady)
-		} else {
-			r.updateConditions(myDeploymentCopy, myApiV1.ConditionTypeService,
-				fmt.Sprintf("Service %s, err: %s", req.Name, err.Error()),
-				myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonServiceNotReady)
-			return ctrl.Result{}, err
-		}
-	} else {
-		// 3.2 存在对象，更新 service
-		err := r.updateService(ctx, myDeploymentCopy, service)
+	// ============ 处理 deployment ===============
+	// reference 1. 计算引用的 ConfigMap / Secret 的内容摘要，内容变化后 pod template 变化，触发滚动更新
+	referenceChecksum, err := r.referenceChecksum(ctx, myDeploymentCopy)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// 2. 同步滚动更新使用的 deployment，蓝绿发布时同步 -blue 和 -green 两个 Deployment 和 preview service
+	// bluegreen 1. 切换发布方式的过程中，两种方式的资源都保留，service 切换后再删除原来的资源
+	deploymentChild := &deploymentChild{reconciler: r, referenceChecksum: referenceChecksum}
+	blueGreen := newBlueGreen(myDeploymentCopy, referenceChecksum)
+	results, err := r.reconcileChildren(ctx, myDeploymentCopy, deploymentChild,
+		&blueGreenActiveChild{blueGreen}, &blueGreenPreviewChild{blueGreen}, &previewServiceChild{})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// bluegreen 3. service 的 selector 根据 active 颜色生成，颜色变化后先保存再同步 service
+	if myDeploymentCopy.Status.ActiveColor != myDeployment.Status.ActiveColor ||
+		myDeploymentCopy.Status.PreviewColor != myDeployment.Status.PreviewColor {
+		err = r.saveColors(ctx, myDeploymentCopy, myDeployment.Status.ActiveColor, myDeployment.Status.PreviewColor)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
-
-		r.updateConditions(myDeploymentCopy, myApiV1.ConditionTypeService,
-			fmt.Sprintf(myApiV1.ConditionMessageServiceOKFmt, req.Name),
-			myApiV1.ConditionStatusTrue, myApiV1.ConditionReasonServiceReady)
 	}
-
-	// ============ 处理 ingress ===============
-	// 4. 获取 ingress 资源对象
-	ingress := new(networkingV1.Ingress)
-	err = r.Get(ctx, req.NamespacedName, ingress)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			// 4.1 不存在对象
-			// 4.1.1 mode 为 ingress
-			if myDeploymentCopy.Spec.Expose.Mode == myApiV1.ModeIngress {
-				// 4.1.1.1 创建 ingress
-				err := r.createIngress(ctx, myDeploymentCopy)
+	if !myDeploymentCopy.Spec.IsBlueGreen() {
+		result := results[0]
+		rolledBack := deploymentChild.rolledBack
+		deployment := result.existing.(*appsV1.Deployment)
+		if result.ready {
+			// rollback 2. 期望的 pod template 已经全部就绪，保存为最后一次全部就绪的 pod template
+			if !result.updated && !rolledBack && deploymentComplete(deployment) {
+				err = r.saveKnownGood(ctx, myDeploymentCopy, result.desired.(*appsV1.Deployment))
 				if err != nil {
 					return ctrl.Result{}, err
 				}
-				r.updateConditions(myDeploymentCopy, myApiV1.ConditionTypeIngress,
-					fmt.Sprintf(myApiV1.ConditionMessageIngressNotOKFmt, req.Name),
-					myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonIngressNotReady)
-				if myDeploymentCopy.Spec.Expose.Tls {
-					// https 4. 创建 issuers 和 certificate
-					err := r.createIssuer(ctx, myDeploymentCopy)
-					if err != nil {
-						return ctrl.Result{}, err
-					}
-					err = r.createCertificate(ctx, myDeploymentCopy)
-					if err != nil {
-						return ctrl.Result{}, err
-					}
-				}
-			} else if myDeploymentCopy.Spec.Expose.Mode == myApiV1.ModeNodePort {
-				// 4.1.2 mode 为 nodePort
-				// 4.1.2.1 退出
-				return ctrl.Result{}, nil
 			}
-		} else {
-			r.updateConditions(myDeploymentCopy, myApiV1.ConditionTypeIngress,
-				fmt.Sprintf("Ingress %s, err: %s", req.Name, err.Error()),
-				myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonIngressNotReady)
-			return ctrl.Result{}, err
-		}
-	} else {
-		// 4.2 存在对象
-		if myDeploymentCopy.Spec.Expose.Mode == myApiV1.ModeIngress {
-			// 4.2.1 mode 为 ingress
-			// 4.2.1.1 更新 ingress
-			err := r.updateIngress(ctx, myDeploymentCopy, ingress)
+		} else if !result.updated && !rolledBack && progressDeadlineExceeded(deployment) {
+			// rollback 3. 超过 progressDeadlineSeconds 仍然没有完成更新，回滚到最后一次全部就绪的 pod template
+			rolledBack, err = r.rollback(ctx, myDeploymentCopy, referenceChecksum)
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-			r.updateConditions(myDeploymentCopy, myApiV1.ConditionTypeIngress,
-				fmt.Sprintf(myApiV1.ConditionMessageIngressOKFmt, req.Name),
-				myApiV1.ConditionStatusTrue, myApiV1.ConditionReasonIngressReady)
-			// https 5. 创建 issuers 和 certificate
-			if myDeploymentCopy.Spec.Expose.Tls {
-				// https 4. 创建 issuers 和 certificate
-				err := r.createIssuer(ctx, myDeploymentCopy)
-				if err != nil {
-					return ctrl.Result{}, err
-				}
-				err = r.createCertificate(ctx, myDeploymentCopy)
-				if err != nil {
-					return ctrl.Result{}, err
-				}
-			}
-		} else if myDeploymentCopy.Spec.Expose.Mode == myApiV1.ModeNodePort {
-			// 4.2.2 mode 为 nodePort
-			// 4.2.2.1 删除 ingress
-			err := r.deleteIngress(ctx, myDeploymentCopy)
+		}
+		// rollback 4. 回滚后 spec 没有变化时保持 RolledBack condition，提示用户修改 spec
+		if rolledBack {
+			status := myDeploymentCopy.Status.Rollback
+			r.updateConditions(myDeploymentCopy, myApiV1.ConditionTypeRolledBack,
+				fmt.Sprintf(myApiV1.ConditionMessageRolledBackFmt, req.Name,
+					shortHash(status.FailedHash), shortHash(status.KnownGoodHash)),
+				myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonRolledBack)
+		} else {
+			r.deleteStatus(myDeploymentCopy, myApiV1.ConditionTypeRolledBack)
+		}
+		// rollback 5. 关闭自动回滚后，删除保存的 pod template
+		if !myDeploymentCopy.Spec.RollbackEnabled() {
+			err = r.deleteKnownGood(ctx, myDeploymentCopy)
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-			r.deleteStatus(myDeploymentCopy, myApiV1.ConditionTypeIngress)
+		}
+	}
+
+	// ============ 处理 canary ===============
+	// canary 1. 金丝雀的 Deployment 和主 Deployment 使用相同的 pod template，在主 Deployment 之后处理
+	err = r.reconcileCanary(ctx, myDeploymentCopy, referenceChecksum)
+	if err != nil {
+		r.updateConditions(myDeploymentCopy, myApiV1.ConditionTypeCanary,
+			fmt.Sprintf("Canary %s, err: %s", canaryName(myDeploymentCopy), err.Error()),
+			myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonCanaryNotReady)
+		return ctrl.Result{}, err
+	}
+
+	// ============ 处理 service ===============
+	// 3. 同步 service
+	_, err = r.reconcileChild(ctx, myDeploymentCopy, &serviceChild{client: r.Client})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// ============ 处理 ingress ===============
+	// 4. 同步 ingress，mode 为 nodePort，或者没有设置 expose 只在集群内部访问时删除
+	_, err = r.reconcileChild(ctx, myDeploymentCopy, &ingressChild{client: r.Client})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if myDeploymentCopy.Spec.TLSEnabled() {
+		// https 4. 创建 issuers 和 certificate
+		err = r.createIssuer(ctx, myDeploymentCopy)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		err = r.createCertificate(ctx, myDeploymentCopy)
+		if err != nil {
+			return ctrl.Result{}, err
 		}
 	}
 
@@ -269,6 +288,10 @@ func (r *MyDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Request
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *MyDeploymentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// reference 2. 建立 MyDeployment 到引用的 ConfigMap / Secret 的索引
+	if err := setupReferenceIndexes(context.Background(), mgr); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&myApiV1.MyDeployment{}).
 		// 监控 Deployment 类型，变更就触发 Reconcile 方法的执行
@@ -277,105 +300,281 @@ func (r *MyDeploymentReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Owns(&coreV1.Service{}).
 		// 监控 Ingress 类型，变更就触发 Reconcile 方法的执行
 		Owns(&networkingV1.Ingress{}).
+		// 监控 ConfigMap 类型，变更就触发 Reconcile 方法的执行
+		Owns(&coreV1.ConfigMap{}).
+		// 监控 PersistentVolumeClaim 类型，变更就触发 Reconcile 方法的执行
+		Owns(&coreV1.PersistentVolumeClaim{}).
+		// 监控 ServiceAccount / Role / RoleBinding 类型，变更就触发 Reconcile 方法的执行
+		Owns(&coreV1.ServiceAccount{}).
+		Owns(&rbacV1.Role{}).
+		Owns(&rbacV1.RoleBinding{}).
+		// reference 3. 监控被引用的 ConfigMap / Secret，变更就触发引用它的 MyDeployment 的 Reconcile
+		Watches(&coreV1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.findMyDeploymentsForConfigMap)).
+		Watches(&coreV1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.findMyDeploymentsForSecret)).
 		Named("mydeployment").
 		Complete(r)
 }
 
-func (r *MyDeploymentReconciler) createDeployment(ctx context.Context, myDeployment *myApiV1.MyDeployment) error {
-	deployment := NewDeployment(myDeployment)
+// 同步生成的 ServiceAccount，以及根据 rules 生成的 Role 和 RoleBinding，
+// Role 和 RoleBinding 出错时由调用方设置 ServiceAccount condition
+func (r *MyDeploymentReconciler) reconcileServiceAccount(ctx context.Context, myDeployment *myApiV1.MyDeployment) error {
+	_, err := r.reconcileChildren(ctx, myDeployment, &serviceAccountChild{}, &roleChild{}, &roleBindingChild{})
+	return err
+}
 
-	// 设置 Deployment 所属于 md
-	err := controllerutil.SetControllerReference(myDeployment, &deployment, r.Scheme)
+// 保存 status 中的颜色，保存失败时恢复为原来的颜色，否则 service 已经切换，下次 Reconcile 仍然把原来的颜色当作 active，
+// 缩容或者切换错误的 Deployment
+func (r *MyDeploymentReconciler) saveColors(ctx context.Context, myDeployment *myApiV1.MyDeployment, prevActiveColor, prevPreviewColor string) error {
+	// 使用副本更新，返回的对象会覆盖 spec，设置了 rollbackTo 时内存中的 spec 是历史版本
+	myDeploymentCopy := myDeployment.DeepCopy()
+	err := r.Client.Status().Update(ctx, myDeploymentCopy)
 	if err != nil {
+		myDeployment.Status.ActiveColor = prevActiveColor
+		myDeployment.Status.PreviewColor = prevPreviewColor
 		return err
 	}
-	return r.Client.Create(ctx, &deployment)
+	myDeployment.ResourceVersion = myDeploymentCopy.ResourceVersion
+	return nil
 }
 
-func (r *MyDeploymentReconciler) updateDeployment(ctx context.Context, myDeployment *myApiV1.MyDeployment, prev *appsV1.Deployment) error {
-	deployment := NewDeployment(myDeployment)
-
-	// 设置 Deployment 所属于 md
-	err := controllerutil.SetControllerReference(myDeployment, &deployment, r.Scheme)
+// 同步 md 拥有的对象，want 为 true 时创建或更新，为 false 时删除，equal 判断已存在的对象是否需要更新
+// 同名的对象已经存在但不属于 md 时，不做修改也不删除，防止覆盖用户自己创建的对象
+func (r *MyDeploymentReconciler) syncOwnedObject(ctx context.Context, myDeployment *myApiV1.MyDeployment,
+	want bool, desired, prev client.Object, equal func(prev client.Object) bool) error {
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), prev)
 	if err != nil {
-		return err
+		if !errors.IsNotFound(err) || !want {
+			return client.IgnoreNotFound(err)
+		}
+		// 不存在对象，创建
+		err = controllerutil.SetControllerReference(myDeployment, desired, r.Scheme)
+		if err != nil {
+			return err
+		}
+		return r.Client.Create(ctx, desired)
 	}
-	// 预更新，得到更新后的数据
-	err = r.Update(ctx, &deployment, client.DryRunAll)
-	if err != nil {
-		return err
+	if !metav1.IsControlledBy(prev, myDeployment) {
+		if want {
+			return fmt.Errorf("%s %s already exists and is not managed by MyDeployment %s",
+				desired.GetObjectKind().GroupVersionKind().Kind, desired.GetName(), myDeployment.Name)
+		}
+		return nil
 	}
-	// 和之前的数据进行比较，如果相同，说明更新不需要
-	if reflect.DeepEqual(deployment.Spec, prev.Spec) {
+	// 存在对象，但是已经不需要了，删除
+	if !want {
+		return client.IgnoreNotFound(r.Client.Delete(ctx, prev))
+	}
+	// 存在对象，和之前的数据进行比较，如果相同，说明更新不需要
+	if equal(prev) {
 		return nil
 	}
-
-	return r.Client.Update(ctx, &deployment)
-}
-
-func (r *MyDeploymentReconciler) createService(ctx context.Context, myDeployment *myApiV1.MyDeployment) error {
-	service := NewService(myDeployment)
-	// 设置 Service 所属于 md
-	err := controllerutil.SetControllerReference(myDeployment, &service, r.Scheme)
+	err = controllerutil.SetControllerReference(myDeployment, desired, r.Scheme)
 	if err != nil {
 		return err
 	}
-	return r.Client.Create(ctx, &service)
+	desired.SetResourceVersion(prev.GetResourceVersion())
+	return r.Client.Update(ctx, desired)
 }
 
-func (r *MyDeploymentReconciler) updateService(ctx context.Context, myDeployment *myApiV1.MyDeployment, prev *coreV1.Service) error {
-	service := NewService(myDeployment)
-	// 设置 Service 所属于 md
-	err := controllerutil.SetControllerReference(myDeployment, &service, r.Scheme)
+// 同步金丝雀发布的资源，并更新 Canary condition
+// 1. 金丝雀发布进行中，同步金丝雀的 Deployment，ingress 模式下设置了 weight 时同步金丝雀的 Service 和 Ingress
+// 2. 推广、回滚或者删除了 spec.canary 时，删除金丝雀的资源
+func (r *MyDeploymentReconciler) reconcileCanary(ctx context.Context, myDeployment *myApiV1.MyDeployment, referenceChecksum string) error {
+	// 1. 处理 deployment、service 和 ingress，进行中时 deployment 根据进度设置 condition 和 status.canary
+	_, err := r.reconcileChildren(ctx, myDeployment,
+		&canaryDeploymentChild{client: r.Client, referenceChecksum: referenceChecksum},
+		&canaryServiceChild{},
+		&canaryIngressChild{})
 	if err != nil {
 		return err
 	}
 
-	// 预更新，得到更新后的数据
-	err = r.Update(ctx, &service, client.DryRunAll)
-	if err != nil {
-		return err
+	// 2. 不在进行中时更新 condition，删除 spec.canary 时 condition 已经删除
+	canary := myDeployment.Spec.Canary
+	switch {
+	case canary == nil:
+		myDeployment.Status.Canary = nil
+	case canary.Promote != nil && *canary.Promote:
+		myDeployment.Status.Canary = nil
+		r.updateConditions(myDeployment, myApiV1.ConditionTypeCanary,
+			fmt.Sprintf(myApiV1.ConditionMessageCanaryPromotedFmt, canary.Image),
+			myApiV1.ConditionStatusTrue, myApiV1.ConditionReasonCanaryPromoted)
+	case canary.Promote != nil:
+		myDeployment.Status.Canary = nil
+		r.updateConditions(myDeployment, myApiV1.ConditionTypeCanary,
+			fmt.Sprintf(myApiV1.ConditionMessageCanaryRolledBackFmt, canary.Image),
+			myApiV1.ConditionStatusTrue, myApiV1.ConditionReasonCanaryRolledBack)
 	}
-	// 和之前的数据进行比较，如果相同，说明更新不需要
-	if reflect.DeepEqual(service.Spec, prev.Spec) {
-		return nil
+	return nil
+}
+
+// 根据金丝雀 Deployment 的状态计算金丝雀发布的进度
+// 1. 没有全部更新到金丝雀镜像并就绪时，清空就绪时间
+// 2. 全部就绪后记录就绪时间，金丝雀镜像变化后重新记录
+// 3. 就绪后经过 analysisDuration，可以推广
+func canaryProgress(myDeployment *myApiV1.MyDeployment, deployment *appsV1.Deployment, now time.Time) (
+	canaryStatus *myApiV1.CanaryStatus, message, status, reason string) {
+	canary := myDeployment.Spec.Canary
+	canaryStatus = &myApiV1.CanaryStatus{Image: canary.Image}
+
+	ready := len(deployment.Spec.Template.Spec.Containers) != 0 &&
+		deployment.Spec.Template.Spec.Containers[0].Image == canary.Image &&
+		deploymentComplete(deployment)
+	if !ready {
+		message, _ = deploymentNotReadyMessage(deployment, nil)
+		return canaryStatus, message, myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonCanaryNotReady
+	}
+
+	prev := myDeployment.Status.Canary
+	if prev != nil && prev.Image == canary.Image && prev.ReadyTime != nil {
+		canaryStatus.ReadyTime = prev.ReadyTime
+	} else {
+		canaryStatus.ReadyTime = ptr.To(metav1.NewTime(now))
 	}
-	return r.Client.Update(ctx, &service)
+	if canary.AnalysisDuration != nil {
+		until := canaryStatus.ReadyTime.Add(canary.AnalysisDuration.Duration)
+		if now.Before(until) {
+			return canaryStatus, fmt.Sprintf(myApiV1.ConditionMessageCanaryAnalysisFmt, deployment.Name, until.Format(time.RFC3339)),
+				myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonCanaryAnalysis
+		}
+	}
+	return canaryStatus, fmt.Sprintf(myApiV1.ConditionMessageCanaryOKFmt, deployment.Name),
+		myApiV1.ConditionStatusTrue, myApiV1.ConditionReasonCanaryReady
 }
 
-func (r *MyDeploymentReconciler) createIngress(ctx context.Context, myDeployment *myApiV1.MyDeployment) error {
-	ingress := NewIngress(myDeployment)
-	// 设置 Ingress 所属于 md
-	err := controllerutil.SetControllerReference(myDeployment, &ingress, r.Scheme)
+// Deployment 的所有副本都已经更新到最新的 pod template 并且就绪，没有旧的副本
+func deploymentComplete(deployment *appsV1.Deployment) bool {
+	replicas := ptr.Deref(deployment.Spec.Replicas, 1)
+	return deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.Replicas == replicas &&
+		deployment.Status.UpdatedReplicas == replicas &&
+		deployment.Status.ReadyReplicas == replicas
+}
+
+// Deployment 的 Progressing condition 超时的原因，和 Deployment controller 中的 TimedOutReason 相同
+const progressDeadlineExceededReason = "ProgressDeadlineExceeded"
+
+// 生成滚动更新使用的 Deployment，返回的 bool 表示是否使用了最后一次全部就绪的 pod template
+// spec 生成的 pod template 已经被回滚过，并且保存的 pod template 存在时，替换为保存的 pod template，
+// 副本数等其他字段仍然使用 spec 生成的值
+func (r *MyDeploymentReconciler) desiredDeployment(ctx context.Context, myDeployment *myApiV1.MyDeployment, referenceChecksum string) (appsV1.Deployment, bool, error) {
+	deployment := NewDeployment(myDeployment, referenceChecksum)
+	status := myDeployment.Status.Rollback
+	if !myDeployment.Spec.RollbackEnabled() || status == nil || status.KnownGoodHash == "" ||
+		status.FailedHash != templateHash(&deployment.Spec.Template) {
+		return deployment, false, nil
+	}
+	revision := new(appsV1.ControllerRevision)
+	err := r.Get(ctx, client.ObjectKey{
+		Namespace: myDeployment.Namespace,
+		Name:      knownGoodRevisionName(myDeployment, status.KnownGoodHash),
+	}, revision)
 	if err != nil {
-		return err
+		// 保存的 pod template 被删除了，只能使用 spec 生成的 pod template
+		return deployment, false, client.IgnoreNotFound(err)
+	}
+	template := coreV1.PodTemplateSpec{}
+	err = json.Unmarshal(revision.Data.Raw, &template)
+	if err != nil {
+		return deployment, false, err
 	}
-	return r.Client.Create(ctx, &ingress)
+	// 之前保存的 pod template 中没有 track 标签，补充后才能满足 selector
+	for key, value := range myDeployment.StableLabels() {
+		if template.Labels == nil {
+			template.Labels = map[string]string{}
+		}
+		template.Labels[key] = value
+	}
+	deployment.Spec.Template = template
+	return deployment, true, nil
 }
 
-func (r *MyDeploymentReconciler) updateIngress(ctx context.Context, myDeployment *myApiV1.MyDeployment, prev *networkingV1.Ingress) error {
-	ingress := NewIngress(myDeployment)
-
-	// 设置 Ingress 所属于 md
-	err := controllerutil.SetControllerReference(myDeployment, &ingress, r.Scheme)
+// 把全部就绪的 pod template 保存到 ControllerRevision 中，删除之前保存的，并清除失败的摘要
+func (r *MyDeploymentReconciler) saveKnownGood(ctx context.Context, myDeployment *myApiV1.MyDeployment, deployment *appsV1.Deployment) error {
+	if !myDeployment.Spec.RollbackEnabled() {
+		return nil
+	}
+	hash := templateHash(&deployment.Spec.Template)
+	status := myDeployment.Status.Rollback
+	if status != nil && status.KnownGoodHash == hash && status.FailedHash == "" {
+		return nil
+	}
+	revision, err := NewKnownGoodRevision(myDeployment, &deployment.Spec.Template)
 	if err != nil {
 		return err
 	}
-	// 预更新，得到更新后的数据
-	err = r.Update(ctx, &ingress, client.DryRunAll)
+	// 名称中带有摘要，同名的对象保存的就是同一个 pod template，不需要更新
+	err = r.syncOwnedObject(ctx, myDeployment, true, &revision, new(appsV1.ControllerRevision),
+		func(prev client.Object) bool { return true })
 	if err != nil {
 		return err
 	}
-	// 和之前的数据进行比较，如果相同，说明更新不需要
-	if reflect.DeepEqual(ingress.Spec, prev.Spec) {
+	if status != nil && status.KnownGoodHash != "" && status.KnownGoodHash != hash {
+		err = r.deleteKnownGood(ctx, myDeployment)
+		if err != nil {
+			return err
+		}
+	}
+	myDeployment.Status.Rollback = &myApiV1.RollbackStatus{KnownGoodHash: hash}
+	return nil
+}
+
+// 删除保存的 pod template，清空 status 中的摘要
+func (r *MyDeploymentReconciler) deleteKnownGood(ctx context.Context, myDeployment *myApiV1.MyDeployment) error {
+	status := myDeployment.Status.Rollback
+	if status == nil {
 		return nil
 	}
-	return r.Client.Update(ctx, &ingress)
+	if status.KnownGoodHash != "" {
+		revision := appsV1.ControllerRevision{ObjectMeta: metav1.ObjectMeta{
+			Name: knownGoodRevisionName(myDeployment, status.KnownGoodHash), Namespace: myDeployment.Namespace}}
+		err := r.syncOwnedObject(ctx, myDeployment, false, &revision, new(appsV1.ControllerRevision), nil)
+		if err != nil {
+			return err
+		}
+	}
+	myDeployment.Status.Rollback = nil
+	return nil
+}
+
+// 记录失败的摘要，Deployment 更新为最后一次全部就绪的 pod template，并产生 Warning 事件
+// 没有保存的 pod template，或者失败的就是保存的 pod template 时不回滚，返回 false
+func (r *MyDeploymentReconciler) rollback(ctx context.Context, myDeployment *myApiV1.MyDeployment, referenceChecksum string) (bool, error) {
+	status := myDeployment.Status.Rollback
+	if !myDeployment.Spec.RollbackEnabled() || status == nil || status.KnownGoodHash == "" {
+		return false, nil
+	}
+	deployment := NewDeployment(myDeployment, referenceChecksum)
+	hash := templateHash(&deployment.Spec.Template)
+	if hash == status.KnownGoodHash {
+		return false, nil
+	}
+	status.FailedHash = hash
+	child := &deploymentChild{reconciler: r, referenceChecksum: referenceChecksum}
+	_, err := r.reconcileChild(ctx, myDeployment, child)
+	if err != nil || !child.rolledBack {
+		status.FailedHash = ""
+		return false, err
+	}
+	if r.Recorder != nil {
+		r.Recorder.Eventf(myDeployment, coreV1.EventTypeWarning, myApiV1.EventReasonRolledBack,
+			myApiV1.ConditionMessageRolledBackFmt, deployment.Name, shortHash(hash), shortHash(status.KnownGoodHash))
+	}
+	return true, nil
 }
 
-func (r *MyDeploymentReconciler) deleteIngress(ctx context.Context, myDeployment *myApiV1.MyDeployment) error {
-	ingress := NewIngress(myDeployment)
-	return r.Client.Delete(ctx, &ingress)
+// Deployment 已经观测到最新的 spec，并且 Progressing condition 因为超时变为 False
+func progressDeadlineExceeded(deployment *appsV1.Deployment) bool {
+	if deployment.Status.ObservedGeneration < deployment.Generation {
+		return false
+	}
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsV1.DeploymentProgressing && condition.Status == coreV1.ConditionFalse &&
+			condition.Reason == progressDeadlineExceededReason {
+			return true
+		}
+	}
+	return false
 }
 
 func (r *MyDeploymentReconciler) createIssuer(ctx context.Context, myDeployment *myApiV1.MyDeployment) error {
@@ -387,14 +586,14 @@ func (r *MyDeploymentReconciler) createIssuer(ctx context.Context, myDeployment
 	// 设置 issuer 所属于 md
 	err = controllerutil.SetControllerReference(myDeployment, issuer, r.Scheme)
 	if err != nil {
-		if errors.IsAlreadyExists(err) {
-			return nil
-		}
 		return err
 	}
-	// 在 k8s 中创建 issuer 资源
+	// 在 k8s 中创建 issuer 资源，已经存在时不需要再创建
 	_, err = r.DynamicClient.Resource(issuerGVR).Namespace(myDeployment.Namespace).Create(ctx, issuer, metav1.CreateOptions{})
 	if err != nil {
+		if errors.IsAlreadyExists(err) {
+			return nil
+		}
 		return err
 	}
 	return nil
@@ -422,6 +621,61 @@ func (r *MyDeploymentReconciler) createCertificate(ctx context.Context, myDeploy
 	return nil
 }
 
+// 生成 Deployment 未就绪时的信息
+// ReplicaSet 创建 pod 失败时(比如被 Pod Security 准入拒绝)，Deployment 会有 ReplicaFailure 的 condition，
+// 把具体的原因带上，否则用户只能看到 not ready，需要自己去查 ReplicaSet 的事件
+// pod 已经创建但拉取镜像失败时，Deployment 上没有对应的 condition，从 pod 的容器状态中读取
+func deploymentNotReadyMessage(deployment *appsV1.Deployment, pods []coreV1.Pod) (message, reason string) {
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsV1.DeploymentReplicaFailure && condition.Status == coreV1.ConditionTrue {
+			return fmt.Sprintf(myApiV1.ConditionMessageDeploymentFailedFmt, deployment.Name, condition.Reason, condition.Message),
+				myApiV1.ConditionReasonDeploymentReplicaFailure
+		}
+	}
+	for i := range pods {
+		for _, statuses := range [][]coreV1.ContainerStatus{pods[i].Status.InitContainerStatuses, pods[i].Status.ContainerStatuses} {
+			for _, status := range statuses {
+				waiting := status.State.Waiting
+				if waiting != nil && imagePullFailureReasons[waiting.Reason] {
+					return fmt.Sprintf(myApiV1.ConditionMessageDeploymentFailedFmt, deployment.Name, waiting.Reason, waiting.Message),
+						myApiV1.ConditionReasonDeploymentImagePullFailure
+				}
+			}
+		}
+	}
+	return fmt.Sprintf(myApiV1.ConditionMessageDeploymentNotOKFmt, deployment.Name),
+		myApiV1.ConditionReasonDeploymentNotReady
+}
+
+// kubelet 拉取镜像失败时容器处于 waiting 状态的原因
+var imagePullFailureReasons = map[string]bool{
+	"ErrImagePull":     true,
+	"ImagePullBackOff": true,
+	"InvalidImageName": true,
+}
+
+// 获取 MyDeployment 生成的 pod
+func (r *MyDeploymentReconciler) listPods(ctx context.Context, myDeployment *myApiV1.MyDeployment) ([]coreV1.Pod, error) {
+	reader := r.APIReader
+	if reader == nil {
+		reader = r.Client
+	}
+	// 金丝雀 pod 同样有 app 标签，排除掉，金丝雀的镜像拉取失败不影响主 Deployment 的状态，
+	// 之前创建的 pod 没有 track 标签，notin 同样会选择到
+	notCanary, err := labels.NewRequirement(myApiV1.LabelKeyTrack, selection.NotIn, []string{myApiV1.LabelValueCanary})
+	if err != nil {
+		return nil, err
+	}
+	pods := new(coreV1.PodList)
+	err = reader.List(ctx, pods,
+		client.InNamespace(myDeployment.Namespace),
+		client.MatchingLabelsSelector{Selector: labels.SelectorFromSet(newLabels(myDeployment)).Add(*notCanary)})
+	if err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
 // 更新 Condition ，并变更版本
 func (r *MyDeploymentReconciler) updateConditions(myDeployment *myApiV1.MyDeployment, conditionType, message, status, reason string) {
 	// 1. 获取 MyDeployment 的 status
@@ -500,7 +754,7 @@ func createCondition(conditionType, message, status, reason string) myApiV1.Cond
 // 只是删除对应的 Condition，不做更多的操作
 func (r *MyDeploymentReconciler) deleteStatus(myDeployment *myApiV1.MyDeployment, conditionType string) {
 	// 1. 遍历 Conditions
-	var tmp []myApiV1.Condition
+	tmp := make([]myApiV1.Condition, len(myDeployment.Status.Conditions))
 	copy(tmp, myDeployment.Status.Conditions)
 	for i := range tmp {
 		// 2. 找到要删除的对象
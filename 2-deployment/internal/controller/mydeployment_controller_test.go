@@ -0,0 +1,907 @@
+package controller
+
+import (
+	"context"
+	myApiV1 "deployment/api/v1"
+	stderrors "errors"
+	"fmt"
+	appsV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	networkingV1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+	"reflect"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeploymentNotReadyMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		conditions  []appsV1.DeploymentCondition
+		pods        []coreV1.Pod
+		wantMessage string
+		wantReason  string
+	}{
+		{
+			name:        "测试没有失败的 condition，返回通用的未就绪信息",
+			wantMessage: "Deployment mydeployment-test is not ready",
+			wantReason:  myApiV1.ConditionReasonDeploymentNotReady,
+		},
+		{
+			name: "测试 ReplicaSet 创建 pod 失败，返回失败的原因",
+			conditions: []appsV1.DeploymentCondition{
+				{
+					Type:    appsV1.DeploymentReplicaFailure,
+					Status:  coreV1.ConditionTrue,
+					Reason:  "FailedCreate",
+					Message: `pods "mydeployment-test-5d8f" is forbidden: violates PodSecurity "restricted:latest"`,
+				},
+			},
+			wantMessage: `Deployment mydeployment-test is not ready, FailedCreate: ` +
+				`pods "mydeployment-test-5d8f" is forbidden: violates PodSecurity "restricted:latest"`,
+			wantReason: myApiV1.ConditionReasonDeploymentReplicaFailure,
+		},
+		{
+			name: "测试 pod 拉取镜像失败，返回容器等待的原因",
+			pods: []coreV1.Pod{
+				{
+					Status: coreV1.PodStatus{
+						ContainerStatuses: []coreV1.ContainerStatus{
+							{
+								Name:  "mydeployment-test",
+								State: coreV1.ContainerState{Running: &coreV1.ContainerStateRunning{}},
+							},
+						},
+					},
+				},
+				{
+					Status: coreV1.PodStatus{
+						ContainerStatuses: []coreV1.ContainerStatus{
+							{
+								Name: "mydeployment-test",
+								State: coreV1.ContainerState{Waiting: &coreV1.ContainerStateWaiting{
+									Reason:  "ImagePullBackOff",
+									Message: `Back-off pulling image "nginx:not-exist"`,
+								}},
+							},
+						},
+					},
+				},
+			},
+			wantMessage: `Deployment mydeployment-test is not ready, ImagePullBackOff: Back-off pulling image "nginx:not-exist"`,
+			wantReason:  myApiV1.ConditionReasonDeploymentImagePullFailure,
+		},
+		{
+			name: "测试 pod 处于其他等待状态，返回通用的未就绪信息",
+			pods: []coreV1.Pod{
+				{
+					Status: coreV1.PodStatus{
+						ContainerStatuses: []coreV1.ContainerStatus{
+							{
+								Name:  "mydeployment-test",
+								State: coreV1.ContainerState{Waiting: &coreV1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+							},
+						},
+					},
+				},
+			},
+			wantMessage: "Deployment mydeployment-test is not ready",
+			wantReason:  myApiV1.ConditionReasonDeploymentNotReady,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment := &appsV1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "mydeployment-test"},
+				Status:     appsV1.DeploymentStatus{Conditions: tt.conditions},
+			}
+			message, reason := deploymentNotReadyMessage(deployment, tt.pods)
+			if message != tt.wantMessage || reason != tt.wantReason {
+				t.Errorf("deploymentNotReadyMessage() got = %q %q, want %q %q", message, reason, tt.wantMessage, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestCreateIssuer(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := myApiV1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	myDeployment := newMyDeployment("ingress-cr.yaml")
+	myDeployment.Namespace = "default"
+	myDeployment.Spec.Expose.Tls = true
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{issuerGVR: "IssuerList"})
+	r := &MyDeploymentReconciler{Scheme: scheme, DynamicClient: dynamicClient}
+
+	// 第一次创建 issuer，之后每次 Reconcile 都会再次创建，已经存在时不应该返回错误
+	for i := 0; i < 2; i++ {
+		if err := r.createIssuer(context.TODO(), myDeployment); err != nil {
+			t.Fatalf("createIssuer() #%d error = %v", i, err)
+		}
+	}
+	issuer, err := dynamicClient.Resource(issuerGVR).Namespace("default").Get(context.TODO(), myDeployment.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get issuer error = %v", err)
+	}
+	if !metav1.IsControlledBy(issuer, myDeployment) {
+		t.Errorf("issuer owner references = %v, want controlled by %s", issuer.GetOwnerReferences(), myDeployment.Name)
+	}
+}
+
+func TestCanaryProgress(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	readyTime := metav1.NewTime(now.Add(-5 * time.Minute))
+	readyStatus := appsV1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, ReadyReplicas: 1}
+	tests := []struct {
+		name          string
+		image         string
+		status        appsV1.DeploymentStatus
+		prev          *myApiV1.CanaryStatus
+		wantReadyTime *metav1.Time
+		wantStatus    string
+		wantReason    string
+	}{
+		{
+			name:       "测试金丝雀 Deployment 没有就绪，等待就绪",
+			image:      "nginx:1.27",
+			status:     appsV1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1},
+			prev:       &myApiV1.CanaryStatus{Image: "nginx:1.27", ReadyTime: &readyTime},
+			wantStatus: myApiV1.ConditionStatusFalse,
+			wantReason: myApiV1.ConditionReasonCanaryNotReady,
+		},
+		{
+			name:       "测试金丝雀 Deployment 还没有更新到新的镜像，等待就绪",
+			image:      "nginx:1.26",
+			status:     readyStatus,
+			wantStatus: myApiV1.ConditionStatusFalse,
+			wantReason: myApiV1.ConditionReasonCanaryNotReady,
+		},
+		{
+			name:          "测试金丝雀 Deployment 刚刚就绪，开始观察",
+			image:         "nginx:1.27",
+			status:        readyStatus,
+			wantReadyTime: ptr.To(metav1.NewTime(now)),
+			wantStatus:    myApiV1.ConditionStatusFalse,
+			wantReason:    myApiV1.ConditionReasonCanaryAnalysis,
+		},
+		{
+			name:          "测试金丝雀镜像变化后，重新开始观察",
+			image:         "nginx:1.27",
+			status:        readyStatus,
+			prev:          &myApiV1.CanaryStatus{Image: "nginx:1.25", ReadyTime: ptr.To(metav1.NewTime(now.Add(-time.Hour)))},
+			wantReadyTime: ptr.To(metav1.NewTime(now)),
+			wantStatus:    myApiV1.ConditionStatusFalse,
+			wantReason:    myApiV1.ConditionReasonCanaryAnalysis,
+		},
+		{
+			name:          "测试观察时间没有结束，继续观察",
+			image:         "nginx:1.27",
+			status:        readyStatus,
+			prev:          &myApiV1.CanaryStatus{Image: "nginx:1.27", ReadyTime: &readyTime},
+			wantReadyTime: &readyTime,
+			wantStatus:    myApiV1.ConditionStatusFalse,
+			wantReason:    myApiV1.ConditionReasonCanaryAnalysis,
+		},
+		{
+			name:          "测试观察时间结束，可以推广",
+			image:         "nginx:1.27",
+			status:        readyStatus,
+			prev:          &myApiV1.CanaryStatus{Image: "nginx:1.27", ReadyTime: ptr.To(metav1.NewTime(now.Add(-10 * time.Minute)))},
+			wantReadyTime: ptr.To(metav1.NewTime(now.Add(-10 * time.Minute))),
+			wantStatus:    myApiV1.ConditionStatusTrue,
+			wantReason:    myApiV1.ConditionReasonCanaryReady,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			myDeployment := &myApiV1.MyDeployment{
+				Spec: myApiV1.MyDeploymentSpec{
+					Canary: &myApiV1.Canary{
+						Image:            "nginx:1.27",
+						AnalysisDuration: &metav1.Duration{Duration: 10 * time.Minute},
+					},
+				},
+				Status: myApiV1.MyDeploymentStatus{Canary: tt.prev},
+			}
+			deployment := &appsV1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "mydeployment-test-canary"},
+				Spec: appsV1.DeploymentSpec{
+					Replicas: ptr.To(int32(1)),
+					Template: coreV1.PodTemplateSpec{
+						Spec: coreV1.PodSpec{Containers: []coreV1.Container{{Image: tt.image}}},
+					},
+				},
+				Status: tt.status,
+			}
+			canaryStatus, _, status, reason := canaryProgress(myDeployment, deployment, now)
+			if status != tt.wantStatus || reason != tt.wantReason {
+				t.Errorf("canaryProgress() got = %q %q, want %q %q", status, reason, tt.wantStatus, tt.wantReason)
+			}
+			if !reflect.DeepEqual(canaryStatus.ReadyTime, tt.wantReadyTime) {
+				t.Errorf("canaryProgress() readyTime = %v, want %v", canaryStatus.ReadyTime, tt.wantReadyTime)
+			}
+		})
+	}
+}
+
+func TestListPods(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := coreV1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	myDeployment := newMyDeployment("canary-cr.yaml")
+	myDeployment.Namespace = "default"
+	newPod := func(name string, labels map[string]string) *coreV1.Pod {
+		return &coreV1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: myDeployment.Namespace, Labels: labels}}
+	}
+	// 升级之前创建的 pod 没有 track 标签，同样属于主 Deployment
+	r := &MyDeploymentReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		newPod("stable", myDeployment.StableLabels()),
+		newPod("legacy", newLabels(myDeployment)),
+		newPod("canary", newCanaryLabels(myDeployment)),
+	).Build(), Scheme: scheme}
+
+	pods, err := r.listPods(context.TODO(), myDeployment)
+	if err != nil {
+		t.Fatalf("listPods() error = %v", err)
+	}
+	var names []string
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	if want := []string{"legacy", "stable"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("listPods() got = %v, want %v", names, want)
+	}
+}
+
+func TestProgressDeadlineExceeded(t *testing.T) {
+	tests := []struct {
+		name               string
+		generation         int64
+		observedGeneration int64
+		conditions         []appsV1.DeploymentCondition
+		want               bool
+	}{
+		{
+			name:               "测试 Deployment 正在更新，没有超时",
+			generation:         2,
+			observedGeneration: 2,
+			conditions: []appsV1.DeploymentCondition{
+				{Type: appsV1.DeploymentProgressing, Status: coreV1.ConditionTrue, Reason: "ReplicaSetUpdated"},
+			},
+			want: false,
+		},
+		{
+			name:               "测试 Deployment 更新超过 progressDeadlineSeconds",
+			generation:         2,
+			observedGeneration: 2,
+			conditions: []appsV1.DeploymentCondition{
+				{Type: appsV1.DeploymentProgressing, Status: coreV1.ConditionFalse, Reason: "ProgressDeadlineExceeded"},
+			},
+			want: true,
+		},
+		{
+			name:               "测试 Deployment 还没有观测到最新的 spec，超时的 condition 属于之前的版本",
+			generation:         3,
+			observedGeneration: 2,
+			conditions: []appsV1.DeploymentCondition{
+				{Type: appsV1.DeploymentProgressing, Status: coreV1.ConditionFalse, Reason: "ProgressDeadlineExceeded"},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment := &appsV1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: tt.generation},
+				Status: appsV1.DeploymentStatus{
+					ObservedGeneration: tt.observedGeneration,
+					Conditions:         tt.conditions,
+				},
+			}
+			if got := progressDeadlineExceeded(deployment); got != tt.want {
+				t.Errorf("progressDeadlineExceeded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteStatus(t *testing.T) {
+	myDeployment := &myApiV1.MyDeployment{Status: myApiV1.MyDeploymentStatus{Conditions: []myApiV1.Condition{
+		{Type: myApiV1.ConditionTypeDeployment},
+		{Type: myApiV1.ConditionTypeRolledBack},
+		{Type: myApiV1.ConditionTypeService},
+	}}}
+	r := &MyDeploymentReconciler{}
+	r.deleteStatus(myDeployment, myApiV1.ConditionTypeRolledBack)
+	var got []string
+	for _, condition := range myDeployment.Status.Conditions {
+		got = append(got, condition.Type)
+	}
+	want := []string{myApiV1.ConditionTypeDeployment, myApiV1.ConditionTypeService}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("deleteStatus() conditions = %v, want %v", got, want)
+	}
+}
+
+// ============ Reconcile ===============
+
+// Reconcile 的测试环境，使用 fake client，通过 interceptor 注入错误并记录写操作
+type reconcileHarness struct {
+	t          *testing.T
+	reconciler *MyDeploymentReconciler
+	dynamic    *dynamicfake.FakeDynamicClient
+	request    ctrl.Request
+	// key 为 <verb>/<kind>，比如 create/Deployment、update/MyDeployment/status
+	faults map[string]error
+	// 实际执行的写操作的次数，不包括预更新，key 和 faults 相同
+	writes map[string]int
+}
+
+func newReconcileHarness(t *testing.T, myDeployment *myApiV1.MyDeployment) *reconcileHarness {
+	h := &reconcileHarness{
+		t:          t,
+		reconciler: newFakeReconciler(t),
+		request:    ctrl.Request{NamespacedName: client.ObjectKeyFromObject(myDeployment)},
+		faults:     map[string]error{},
+		writes:     map[string]int{},
+	}
+	scheme := h.reconciler.Scheme
+	h.reconciler.Client = fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(myDeployment).
+		WithStatusSubresource(&myApiV1.MyDeployment{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				if err := h.fault("get", obj); err != nil {
+					return err
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				if err := h.fault("create", obj); err != nil {
+					return err
+				}
+				h.writes["create/"+h.kind(obj)]++
+				return c.Create(ctx, obj, opts...)
+			},
+			Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				if err := h.fault("update", obj); err != nil {
+					return err
+				}
+				if len((&client.UpdateOptions{}).ApplyOptions(opts).DryRun) == 0 {
+					h.writes["update/"+h.kind(obj)]++
+				}
+				return c.Update(ctx, obj, opts...)
+			},
+			Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+				h.writes["delete/"+h.kind(obj)]++
+				return c.Delete(ctx, obj, opts...)
+			},
+			SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+				if err := h.fault("update", obj, subResourceName); err != nil {
+					return err
+				}
+				h.writes["update/"+h.kind(obj)+"/"+subResourceName]++
+				return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+			},
+		}).
+		Build()
+	h.dynamic = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			issuerGVR:      "IssuerList",
+			certificateGVR: "CertificateList",
+		})
+	h.reconciler.DynamicClient = h.dynamic
+	return h
+}
+
+func (h *reconcileHarness) kind(obj client.Object) string {
+	gvk, err := apiutil.GVKForObject(obj, h.reconciler.Scheme)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return gvk.Kind
+}
+
+// 返回注入的错误，subResource 为 status 等子资源
+func (h *reconcileHarness) fault(verb string, obj client.Object, subResource ...string) error {
+	key := strings.Join(append([]string{verb, h.kind(obj)}, subResource...), "/")
+	return h.faults[key]
+}
+
+func (h *reconcileHarness) reconcile() (ctrl.Result, error) {
+	h.writes = map[string]int{}
+	return h.reconciler.Reconcile(context.Background(), h.request)
+}
+
+// 执行 Reconcile，期望没有错误
+func (h *reconcileHarness) mustReconcile() ctrl.Result {
+	h.t.Helper()
+	result, err := h.reconcile()
+	if err != nil {
+		h.t.Fatalf("Reconcile() error = %v", err)
+	}
+	return result
+}
+
+// 读取 apiserver 中的 MyDeployment
+func (h *reconcileHarness) myDeployment() *myApiV1.MyDeployment {
+	h.t.Helper()
+	myDeployment := new(myApiV1.MyDeployment)
+	if err := h.reconciler.Get(context.Background(), h.request.NamespacedName, myDeployment); err != nil {
+		h.t.Fatal(err)
+	}
+	return myDeployment
+}
+
+// 修改 MyDeployment 的 spec，和用户执行 kubectl apply 相同
+func (h *reconcileHarness) updateSpec(mutate func(spec *myApiV1.MyDeploymentSpec)) {
+	h.t.Helper()
+	myDeployment := h.myDeployment()
+	mutate(&myDeployment.Spec)
+	myDeployment.Generation++
+	if err := h.reconciler.Update(context.Background(), myDeployment); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// fake client 中没有 Deployment 控制器，模拟 pod 全部就绪
+func (h *reconcileHarness) markDeploymentReady(name string) {
+	h.t.Helper()
+	deployment := new(appsV1.Deployment)
+	key := client.ObjectKey{Namespace: h.request.Namespace, Name: name}
+	if err := h.reconciler.Get(context.Background(), key, deployment); err != nil {
+		h.t.Fatal(err)
+	}
+	replicas := *deployment.Spec.Replicas
+	deployment.Status = appsV1.DeploymentStatus{
+		ObservedGeneration: deployment.Generation,
+		Replicas:           replicas,
+		UpdatedReplicas:    replicas,
+		ReadyReplicas:      replicas,
+		AvailableReplicas:  replicas,
+	}
+	if err := h.reconciler.Status().Update(context.Background(), deployment); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// 和 md 同名的对象是否存在
+func (h *reconcileHarness) exists(obj client.Object) bool {
+	h.t.Helper()
+	obj.SetNamespace(h.request.Namespace)
+	obj.SetName(h.request.Name)
+	return h.get(obj)
+}
+
+// 读取对象，返回是否存在，obj 中需要有名称和 namespace
+func (h *reconcileHarness) get(obj client.Object) bool {
+	h.t.Helper()
+	err := h.reconciler.Get(context.Background(), client.ObjectKeyFromObject(obj), obj)
+	if client.IgnoreNotFound(err) != nil {
+		h.t.Fatal(err)
+	}
+	return err == nil
+}
+
+// 检查 condition 的状态和原因，wantStatus 为空时期望 condition 不存在
+func checkCondition(t *testing.T, myDeployment *myApiV1.MyDeployment, conditionType, wantStatus, wantReason string) {
+	t.Helper()
+	condition := findCondition(myDeployment, conditionType)
+	if wantStatus == "" {
+		if condition != nil {
+			t.Errorf("condition %s = %+v, want nil", conditionType, condition)
+		}
+		return
+	}
+	if condition == nil || condition.Status != wantStatus || condition.Reason != wantReason {
+		t.Errorf("condition %s = %+v, want status %s reason %s", conditionType, condition, wantStatus, wantReason)
+	}
+}
+
+func TestReconcilePhaseTransitions(t *testing.T) {
+	h := newReconcileHarness(t, newFakeMyDeployment("ingress-cr.yaml"))
+
+	// 1. 第一次 Reconcile，创建子资源，Deployment 没有就绪，phase 为第一个没有就绪的 condition 类型
+	result := h.mustReconcile()
+	if result.RequeueAfter != WaitRequest {
+		t.Errorf("Reconcile() not ready result = %+v, want RequeueAfter %s", result, WaitRequest)
+	}
+	myDeployment := h.myDeployment()
+	checkCondition(t, myDeployment, myApiV1.ConditionTypeDeployment, myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonDeploymentNotReady)
+	checkCondition(t, myDeployment, myApiV1.ConditionTypeService, myApiV1.ConditionStatusTrue, myApiV1.ConditionReasonServiceReady)
+	checkCondition(t, myDeployment, myApiV1.ConditionTypeIngress, myApiV1.ConditionStatusTrue, myApiV1.ConditionReasonIngressReady)
+	if myDeployment.Status.Phase != myApiV1.ConditionTypeDeployment || myDeployment.Status.Reason != myApiV1.ConditionReasonDeploymentNotReady {
+		t.Errorf("not ready status = %s/%s, want %s/%s", myDeployment.Status.Phase, myDeployment.Status.Reason,
+			myApiV1.ConditionTypeDeployment, myApiV1.ConditionReasonDeploymentNotReady)
+	}
+	if myDeployment.Status.CurrentRevision != "" || myDeployment.Status.UpdateRevision == "" {
+		t.Errorf("not ready revisions = %s/%s, want empty current and non-empty update",
+			myDeployment.Status.CurrentRevision, myDeployment.Status.UpdateRevision)
+	}
+
+	// 2. pod 全部就绪后 phase 为 Complete，currentRevision 更新为正在使用的版本
+	h.markDeploymentReady(h.request.Name)
+	if result = h.mustReconcile(); result != (ctrl.Result{}) {
+		t.Errorf("Reconcile() ready result = %+v, want empty", result)
+	}
+	myDeployment = h.myDeployment()
+	checkCondition(t, myDeployment, myApiV1.ConditionTypeDeployment, myApiV1.ConditionStatusTrue, myApiV1.ConditionReasonDeploymentReady)
+	if myDeployment.Status.Phase != myApiV1.StatusPhaseComplete || myDeployment.Status.Reason != myApiV1.StatusReasonSuccess {
+		t.Errorf("ready status = %s/%s, want %s/%s", myDeployment.Status.Phase, myDeployment.Status.Reason,
+			myApiV1.StatusPhaseComplete, myApiV1.StatusReasonSuccess)
+	}
+	if myDeployment.Status.CurrentRevision != myDeployment.Status.UpdateRevision {
+		t.Errorf("ready currentRevision = %s, want %s", myDeployment.Status.CurrentRevision, myDeployment.Status.UpdateRevision)
+	}
+
+	// 3. 修改镜像后 Deployment 更新，重新进入未就绪的状态
+	h.updateSpec(func(spec *myApiV1.MyDeploymentSpec) {
+		spec.Image = "nginx:1.27"
+	})
+	h.mustReconcile()
+	if h.writes["update/Deployment"] != 1 {
+		t.Errorf("Reconcile() after image change writes = %v, want one Deployment update", h.writes)
+	}
+	// fake client 中更新 Deployment 不会改变 status，手动设置为没有就绪
+	deployment := new(appsV1.Deployment)
+	if err := h.reconciler.Get(context.Background(), h.request.NamespacedName, deployment); err != nil {
+		t.Fatal(err)
+	}
+	deployment.Status.ReadyReplicas = 0
+	if err := h.reconciler.Status().Update(context.Background(), deployment); err != nil {
+		t.Fatal(err)
+	}
+	h.mustReconcile()
+	if phase := h.myDeployment().Status.Phase; phase != myApiV1.ConditionTypeDeployment {
+		t.Errorf("after image change phase = %s, want %s", phase, myApiV1.ConditionTypeDeployment)
+	}
+}
+
+func TestReconcileIngressToNodePort(t *testing.T) {
+	h := newReconcileHarness(t, newFakeMyDeployment("ingress-cr.yaml"))
+	h.mustReconcile()
+	if !h.exists(&networkingV1.Ingress{}) {
+		t.Fatal("Ingress not created in ingress mode")
+	}
+
+	// 切换到 nodePort 模式，删除 Ingress 和 Ingress condition，Service 变为 NodePort 类型
+	h.updateSpec(func(spec *myApiV1.MyDeploymentSpec) {
+		spec.Expose = &myApiV1.Expose{Mode: myApiV1.ModeNodePort, NodePort: 30080, ServicePort: 80}
+	})
+	h.mustReconcile()
+	if h.exists(&networkingV1.Ingress{}) {
+		t.Error("Ingress still exists after switching to nodePort mode")
+	}
+	if h.writes["delete/Ingress"] != 1 {
+		t.Errorf("Reconcile() writes = %v, want one Ingress delete", h.writes)
+	}
+	checkCondition(t, h.myDeployment(), myApiV1.ConditionTypeIngress, "", "")
+	service := new(coreV1.Service)
+	if !h.exists(service) {
+		t.Fatal("Service not found")
+	}
+	if service.Spec.Type != coreV1.ServiceTypeNodePort || service.Spec.Ports[0].NodePort != 30080 {
+		t.Errorf("Service type = %s, nodePort = %d, want NodePort 30080", service.Spec.Type, service.Spec.Ports[0].NodePort)
+	}
+
+	// 再次 Reconcile，Ingress 已经不存在，不需要再删除
+	h.mustReconcile()
+	if h.writes["delete/Ingress"] != 0 {
+		t.Errorf("Reconcile() writes = %v, want no Ingress delete", h.writes)
+	}
+}
+
+func TestReconcileTLS(t *testing.T) {
+	ctx := context.Background()
+	myDeployment := newFakeMyDeployment("ingress-tls-cr.yaml")
+	h := newReconcileHarness(t, myDeployment)
+
+	// 1. 开启 tls 后创建 Issuer 和 Certificate，owner 为 md
+	h.mustReconcile()
+	for _, gvr := range []schema.GroupVersionResource{issuerGVR, certificateGVR} {
+		obj, err := h.dynamic.Resource(gvr).Namespace(myDeployment.Namespace).Get(ctx, myDeployment.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("get %s: %v", gvr.Resource, err)
+		}
+		if !metav1.IsControlledBy(obj, myDeployment) {
+			t.Errorf("%s owner references = %v, want controlled by %s", gvr.Resource, obj.GetOwnerReferences(), myDeployment.Name)
+		}
+	}
+	ingress := new(networkingV1.Ingress)
+	if !h.exists(ingress) || len(ingress.Spec.TLS) != 1 || ingress.Spec.TLS[0].SecretName != myDeployment.Name {
+		t.Errorf("Ingress tls = %+v, want secret %s", ingress.Spec.TLS, myDeployment.Name)
+	}
+
+	// 2. Issuer 和 Certificate 已经存在时不报错
+	h.mustReconcile()
+
+	// 3. 创建失败时返回错误
+	h.dynamic.PrependReactor("create", "certificates", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("certificate webhook unavailable")
+	})
+	if err := h.dynamic.Resource(certificateGVR).Namespace(myDeployment.Namespace).Delete(ctx, myDeployment.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.reconcile(); err == nil || !strings.Contains(err.Error(), "certificate webhook unavailable") {
+		t.Errorf("Reconcile() error = %v, want certificate create error", err)
+	}
+}
+
+func TestReconcileIdempotent(t *testing.T) {
+	h := newReconcileHarness(t, newFakeMyDeployment("volume-cr.yaml"))
+	h.mustReconcile()
+	h.markDeploymentReady(h.request.Name)
+	// fake client 中没有 pv 控制器，模拟 pvc 已经绑定
+	pvc := &coreV1.PersistentVolumeClaim{}
+	pvc.SetName(persistentVolumeClaimName(h.myDeployment()))
+	pvc.SetNamespace(h.request.Namespace)
+	if err := h.reconciler.Get(context.Background(), client.ObjectKeyFromObject(pvc), pvc); err != nil {
+		t.Fatal(err)
+	}
+	pvc.Status.Phase = coreV1.ClaimBound
+	if err := h.reconciler.Status().Update(context.Background(), pvc); err != nil {
+		t.Fatal(err)
+	}
+	h.mustReconcile()
+
+	// 记录子资源的 resourceVersion，之后的 Reconcile 不应该修改任何子资源
+	myDeployment := h.myDeployment()
+	objectMeta := metav1.ObjectMeta{Name: myDeployment.Name, Namespace: myDeployment.Namespace}
+	children := []client.Object{
+		&appsV1.Deployment{ObjectMeta: objectMeta},
+		&coreV1.Service{ObjectMeta: objectMeta},
+		&networkingV1.Ingress{ObjectMeta: objectMeta},
+		&coreV1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: configMapName(myDeployment), Namespace: myDeployment.Namespace}},
+		pvc,
+	}
+	resourceVersions := map[string]string{}
+	for _, child := range children {
+		if !h.get(child) {
+			t.Fatalf("%s not found", h.kind(child))
+		}
+		resourceVersions[h.kind(child)] = child.GetResourceVersion()
+	}
+	status := myDeployment.Status
+	for i := 0; i < 3; i++ {
+		if result := h.mustReconcile(); result != (ctrl.Result{}) {
+			t.Errorf("Reconcile() #%d result = %+v, want empty", i, result)
+		}
+		for key, count := range h.writes {
+			// md 已经就绪，每次 Reconcile 都会更新 status
+			if key != "update/MyDeployment/status" && count != 0 {
+				t.Errorf("Reconcile() #%d writes = %v, want only status updates", i, h.writes)
+				break
+			}
+		}
+	}
+	for _, child := range children {
+		h.get(child)
+		if got := child.GetResourceVersion(); got != resourceVersions[h.kind(child)] {
+			t.Errorf("%s resourceVersion = %s, want %s", h.kind(child), got, resourceVersions[h.kind(child)])
+		}
+	}
+	got := h.myDeployment().Status
+	if !reflect.DeepEqual(got.Conditions, status.Conditions) || got.Phase != status.Phase || got.CurrentRevision != status.CurrentRevision {
+		t.Errorf("status changed after repeated Reconcile, got %+v, want %+v", got, status)
+	}
+}
+
+func TestReconcileFaults(t *testing.T) {
+	errInjected := fmt.Errorf("injected error")
+	tests := []struct {
+		name string
+		// 使用的 cr，为空时使用 ingress-cr.yaml
+		cr string
+		// 注入错误之前执行的 Reconcile 次数
+		warmup int
+		// 注入错误之前修改 spec
+		mutate func(spec *myApiV1.MyDeploymentSpec)
+		fault  string
+		// 期望 Reconcile 返回错误
+		wantErr bool
+		// 期望 condition 的状态和原因，为空时不检查
+		wantCondition string
+		wantStatus    string
+		wantReason    string
+	}{
+		{
+			name:    "测试获取 MyDeployment 失败，返回错误",
+			fault:   "get/MyDeployment",
+			wantErr: true,
+		},
+		{
+			name:          "测试创建 Deployment 失败，返回错误，Deployment condition 为 False",
+			fault:         "create/Deployment",
+			wantErr:       true,
+			wantCondition: myApiV1.ConditionTypeDeployment,
+			wantStatus:    myApiV1.ConditionStatusFalse,
+			wantReason:    myApiV1.ConditionReasonDeploymentNotReady,
+		},
+		{
+			name:          "测试获取 PersistentVolumeClaim 失败，返回错误，Storage condition 为 False",
+			cr:            "volume-cr.yaml",
+			fault:         "get/PersistentVolumeClaim",
+			wantErr:       true,
+			wantCondition: myApiV1.ConditionTypeStorage,
+			wantStatus:    myApiV1.ConditionStatusFalse,
+			wantReason:    myApiV1.ConditionReasonStorageNotReady,
+		},
+		{
+			name:   "测试更新 Service 失败，返回错误，Service condition 为 False",
+			warmup: 1,
+			mutate: func(spec *myApiV1.MyDeploymentSpec) {
+				spec.Expose.ServicePort = 8080
+			},
+			fault:         "update/Service",
+			wantErr:       true,
+			wantCondition: myApiV1.ConditionTypeService,
+			wantStatus:    myApiV1.ConditionStatusFalse,
+			wantReason:    myApiV1.ConditionReasonServiceNotReady,
+		},
+		{
+			name:          "测试创建 Ingress 失败，返回错误，Ingress condition 为 False",
+			fault:         "create/Ingress",
+			wantErr:       true,
+			wantCondition: myApiV1.ConditionTypeIngress,
+			wantStatus:    myApiV1.ConditionStatusFalse,
+			wantReason:    myApiV1.ConditionReasonIngressNotReady,
+		},
+		{
+			name:    "测试更新 status 失败，返回错误重新入队，status 不变",
+			fault:   "update/MyDeployment/status",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := tt.cr
+			if cr == "" {
+				cr = "ingress-cr.yaml"
+			}
+			h := newReconcileHarness(t, newFakeMyDeployment(cr))
+			for i := 0; i < tt.warmup; i++ {
+				h.mustReconcile()
+			}
+			if tt.mutate != nil {
+				h.updateSpec(tt.mutate)
+			}
+			before := h.myDeployment().Status
+			h.faults[tt.fault] = errInjected
+
+			_, err := h.reconcile()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reconcile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !stderrors.Is(err, errInjected) {
+				t.Errorf("Reconcile() error = %v, want %v", err, errInjected)
+			}
+			if tt.fault == "get/MyDeployment" {
+				return
+			}
+			// 出错时 condition 仍然保存到 status 中
+			delete(h.faults, tt.fault)
+			got := h.myDeployment()
+			if tt.wantCondition != "" {
+				checkCondition(t, got, tt.wantCondition, tt.wantStatus, tt.wantReason)
+				if condition := findCondition(got, tt.wantCondition); condition != nil &&
+					!strings.Contains(condition.Message, errInjected.Error()) {
+					t.Errorf("condition %s message = %s, want to contain %s", tt.wantCondition, condition.Message, errInjected)
+				}
+			}
+			if tt.fault == "update/MyDeployment/status" && !reflect.DeepEqual(got.Status, before) {
+				t.Errorf("status = %+v, want unchanged %+v", got.Status, before)
+			}
+
+			// 错误消失后恢复，condition 中不再有错误信息
+			h.mustReconcile()
+			if tt.wantCondition != "" {
+				condition := findCondition(h.myDeployment(), tt.wantCondition)
+				if condition != nil && strings.Contains(condition.Message, errInjected.Error()) {
+					t.Errorf("condition %s = %+v after recovery, want error cleared", tt.wantCondition, condition)
+				}
+			}
+		})
+	}
+
+	// MyDeployment 已经删除时不返回错误
+	t.Run("测试 MyDeployment 不存在，不返回错误", func(t *testing.T) {
+		h := newReconcileHarness(t, newFakeMyDeployment("ingress-cr.yaml"))
+		h.request.Name = "not-found"
+		if result, err := h.reconcile(); err != nil || result != (ctrl.Result{}) {
+			t.Errorf("Reconcile() = %+v, %v, want empty result and no error", result, err)
+		}
+	})
+}
+
+// 蓝绿切换时先保存 active 颜色，保存失败时 service 不能切换
+func TestReconcileBlueGreenCutover(t *testing.T) {
+	myDeployment := newFakeMyDeployment("bluegreen-cr.yaml")
+	myDeployment.Spec.Strategy.BlueGreen.AutoPromote = nil
+	myDeployment.Status = myApiV1.MyDeploymentStatus{}
+	h := newReconcileHarness(t, myDeployment)
+	errInjected := fmt.Errorf("injected error")
+
+	// 检查 status 中的 active 颜色和 service 的 selector，color 为空时 service 指向滚动更新的 Deployment
+	check := func(step, color string) {
+		t.Helper()
+		if got := h.myDeployment().Status.ActiveColor; got != color {
+			t.Errorf("%s: status.activeColor = %q, want %q", step, got, color)
+		}
+		service := new(coreV1.Service)
+		if !h.exists(service) {
+			t.Fatalf("%s: service not found", step)
+		}
+		want := newLabels(myDeployment)
+		if color != "" {
+			want = newColorLabels(myDeployment, color)
+		}
+		if !reflect.DeepEqual(service.Spec.Selector, want) {
+			t.Errorf("%s: service selector = %v, want %v", step, service.Spec.Selector, want)
+		}
+	}
+	// 注入 status 更新失败，Reconcile 返回错误，颜色和 service 都不变，错误消失后一起切换
+	cutover := func(step, from, to string) {
+		t.Helper()
+		h.faults["update/MyDeployment/status"] = errInjected
+		if _, err := h.reconcile(); !stderrors.Is(err, errInjected) {
+			t.Fatalf("%s: Reconcile() error = %v, want %v", step, err, errInjected)
+		}
+		check(step+" status update failed", from)
+		delete(h.faults, "update/MyDeployment/status")
+		h.mustReconcile()
+		check(step, to)
+	}
+
+	// 1. 第一次部署 blue，就绪前 service 指向滚动更新的 Deployment
+	h.mustReconcile()
+	check("blue not ready", "")
+	h.markDeploymentReady(blueGreenName(myDeployment, myApiV1.ColorBlue))
+	cutover("blue ready", "", myApiV1.ColorBlue)
+
+	// 2. 新版本部署到 green，就绪后自动切换
+	h.updateSpec(func(spec *myApiV1.MyDeploymentSpec) {
+		spec.Image = "nginx:1.27"
+	})
+	h.mustReconcile()
+	check("green not ready", myApiV1.ColorBlue)
+	// 2.1 切换完成之前 phase 不是 Complete，Deployment condition 根据 active 的 blue 是否就绪设置
+	got := h.myDeployment()
+	checkCondition(t, got, myApiV1.ConditionTypeDeployment, myApiV1.ConditionStatusTrue, myApiV1.ConditionReasonDeploymentReady)
+	checkCondition(t, got, myApiV1.ConditionTypeBlueGreen, myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonBlueGreenPreviewNotReady)
+	if got.Status.Phase == myApiV1.StatusPhaseComplete {
+		t.Errorf("green not ready: phase = %s, want not %s", got.Status.Phase, myApiV1.StatusPhaseComplete)
+	}
+	// 2.2 发布过程中 active 的 pod 没有全部就绪，Deployment condition 为 False
+	blue := &appsV1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		Namespace: myDeployment.Namespace, Name: blueGreenName(myDeployment, myApiV1.ColorBlue)}}
+	h.get(blue)
+	blue.Status.ReadyReplicas, blue.Status.AvailableReplicas = 0, 0
+	if err := h.reconciler.Status().Update(context.Background(), blue); err != nil {
+		t.Fatal(err)
+	}
+	h.mustReconcile()
+	checkCondition(t, h.myDeployment(), myApiV1.ConditionTypeDeployment, myApiV1.ConditionStatusFalse, myApiV1.ConditionReasonDeploymentNotReady)
+	h.markDeploymentReady(blueGreenName(myDeployment, myApiV1.ColorBlue))
+
+	h.markDeploymentReady(blueGreenName(myDeployment, myApiV1.ColorGreen))
+	cutover("green ready", myApiV1.ColorBlue, myApiV1.ColorGreen)
+	// 2.3 切换完成后 phase 为 Complete
+	if phase := h.myDeployment().Status.Phase; phase != myApiV1.StatusPhaseComplete {
+		t.Errorf("green ready: phase = %s, want %s", phase, myApiV1.StatusPhaseComplete)
+	}
+}
@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	myApiV1 "deployment/api/v1"
+	"encoding/hex"
+	"encoding/json"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sort"
+)
+
+// 缓存中 MyDeployment 的索引字段，值为 spec 中引用的 ConfigMap / Secret 名称，
+// 在 ConfigMap / Secret 变化的时候，通过索引找到引用它的 MyDeployment
+const (
+	configMapRefIndexField = ".spec.configMapRefs"
+	secretRefIndexField    = ".spec.secretRefs"
+)
+
+// 是否跟随引用对象的变化滚动更新
+// 1. 设置在 MyDeployment 上，这个 MyDeployment 引用的所有对象变化都不再触发滚动更新
+// 2. 设置在 ConfigMap / Secret 上，这个对象的变化不再触发滚动更新
+func rolloutOnChange(obj client.Object) bool {
+	return obj.GetAnnotations()[myApiV1.AnnotationRolloutOnChange] != "false"
+}
+
+// 返回 spec 中通过环境变量和卷引用的 ConfigMap 名称，已去重排序
+func referencedConfigMaps(myDeployment *myApiV1.MyDeployment) []string {
+	names := map[string]struct{}{}
+	for _, env := range myDeployment.Spec.Environments {
+		if env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil {
+			names[env.ValueFrom.ConfigMapKeyRef.Name] = struct{}{}
+		}
+	}
+	for _, volume := range myDeployment.Spec.Volumes {
+		if volume.ConfigMap != nil {
+			names[volume.ConfigMap.Name] = struct{}{}
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					names[source.ConfigMap.Name] = struct{}{}
+				}
+			}
+		}
+	}
+	return sortedKeys(names)
+}
+
+// 返回 spec 中通过环境变量和卷引用的 Secret 名称，已去重排序
+func referencedSecrets(myDeployment *myApiV1.MyDeployment) []string {
+	names := map[string]struct{}{}
+	for _, env := range myDeployment.Spec.Environments {
+		if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+			names[env.ValueFrom.SecretKeyRef.Name] = struct{}{}
+		}
+	}
+	for _, volume := range myDeployment.Spec.Volumes {
+		if volume.Secret != nil {
+			names[volume.Secret.SecretName] = struct{}{}
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil {
+					names[source.Secret.Name] = struct{}{}
+				}
+			}
+		}
+	}
+	return sortedKeys(names)
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 计算引用的 ConfigMap / Secret 内容的摘要，没有引用或者关闭了滚动更新时返回空字符串
+// 引用的对象不存在时跳过，pod 会因为找不到引用而启动失败，由 Deployment 的状态体现
+func (r *MyDeploymentReconciler) referenceChecksum(ctx context.Context, myDeployment *myApiV1.MyDeployment) (string, error) {
+	if !rolloutOnChange(myDeployment) {
+		return "", nil
+	}
+	configMaps := referencedConfigMaps(myDeployment)
+	secrets := referencedSecrets(myDeployment)
+	if len(configMaps) == 0 && len(secrets) == 0 {
+		return "", nil
+	}
+
+	// key 为 <kind>/<name>，json 序列化 map 时 key 是有序的，所以结果是稳定的
+	contents := map[string]interface{}{}
+	for _, name := range configMaps {
+		configMap := new(coreV1.ConfigMap)
+		err := r.Get(ctx, types.NamespacedName{Namespace: myDeployment.Namespace, Name: name}, configMap)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return "", err
+		}
+		if !rolloutOnChange(configMap) {
+			continue
+		}
+		contents["ConfigMap/"+name] = []interface{}{configMap.Data, configMap.BinaryData}
+	}
+	for _, name := range secrets {
+		secret := new(coreV1.Secret)
+		err := r.Get(ctx, types.NamespacedName{Namespace: myDeployment.Namespace, Name: name}, secret)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return "", err
+		}
+		if !rolloutOnChange(secret) {
+			continue
+		}
+		contents["Secret/"+name] = secret.Data
+	}
+	if len(contents) == 0 {
+		return "", nil
+	}
+
+	data, err := json.Marshal(contents)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// 在 manager 的缓存上为 MyDeployment 建立引用索引
+func setupReferenceIndexes(ctx context.Context, mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(ctx, &myApiV1.MyDeployment{}, configMapRefIndexField,
+		func(obj client.Object) []string {
+			return referencedConfigMaps(obj.(*myApiV1.MyDeployment))
+		})
+	if err != nil {
+		return err
+	}
+	return mgr.GetFieldIndexer().IndexField(ctx, &myApiV1.MyDeployment{}, secretRefIndexField,
+		func(obj client.Object) []string {
+			return referencedSecrets(obj.(*myApiV1.MyDeployment))
+		})
+}
+
+// 找到引用了这个 ConfigMap 的 MyDeployment，加入队列
+func (r *MyDeploymentReconciler) findMyDeploymentsForConfigMap(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.findMyDeploymentsByIndex(ctx, configMapRefIndexField, obj)
+}
+
+// 找到引用了这个 Secret 的 MyDeployment，加入队列
+func (r *MyDeploymentReconciler) findMyDeploymentsForSecret(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.findMyDeploymentsByIndex(ctx, secretRefIndexField, obj)
+}
+
+func (r *MyDeploymentReconciler) findMyDeploymentsByIndex(ctx context.Context, indexField string, obj client.Object) []reconcile.Request {
+	myDeployments := new(myApiV1.MyDeploymentList)
+	err := r.List(ctx, myDeployments,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{indexField: obj.GetName()})
+	if err != nil {
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(myDeployments.Items))
+	for i := range myDeployments.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Namespace: myDeployments.Items[i].Namespace,
+				Name:      myDeployments.Items[i].Name,
+			},
+		})
+	}
+	return requests
+}
@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReferencedObjects(t *testing.T) {
+	tests := []struct {
+		name           string
+		filename       string
+		wantConfigMaps []string
+		wantSecrets    []string
+	}{
+		{
+			name:           "测试没有引用，返回空",
+			filename:       "ingress-cr.yaml",
+			wantConfigMaps: []string{},
+			wantSecrets:    []string{},
+		},
+		{
+			name:           "测试环境变量引用 ConfigMap 和 Secret",
+			filename:       "reference-cr.yaml",
+			wantConfigMaps: []string{"app-settings"},
+			wantSecrets:    []string{"app-credentials"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			myDeployment := newMyDeployment(tt.filename)
+			if got := referencedConfigMaps(myDeployment); !reflect.DeepEqual(got, tt.wantConfigMaps) {
+				t.Errorf("referencedConfigMaps() got = %v, want %v", got, tt.wantConfigMaps)
+			}
+			if got := referencedSecrets(myDeployment); !reflect.DeepEqual(got, tt.wantSecrets) {
+				t.Errorf("referencedSecrets() got = %v, want %v", got, tt.wantSecrets)
+			}
+		})
+	}
+}
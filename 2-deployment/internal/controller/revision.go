@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"context"
+	myApiV1 "deployment/api/v1"
+	"encoding/json"
+	appsV1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+)
+
+// spec 历史版本
+// 1. 每个不同的 spec 保存为一个 ControllerRevision，名称为 <name>-<spec 摘要前 10 位>，revision 递增，
+// 修改回之前的 spec 时复用原来的对象，revision 更新为最大
+// 2. 设置了 spec.rollbackTo 时，使用对应版本的 spec 生成子资源，这个版本的 revision 保持不变
+// 3. 超过 revisionHistoryLimit 的旧版本按 revision 从小到大删除，currentRevision 和 updateRevision 不删除
+
+// 获取 md 拥有的 spec 历史版本，按 revision 从小到大排序
+func (r *MyDeploymentReconciler) listSpecRevisions(ctx context.Context, myDeployment *myApiV1.MyDeployment) ([]appsV1.ControllerRevision, error) {
+	labels := newLabels(myDeployment)
+	labels[myApiV1.LabelKeySpecRevision] = "true"
+	list := new(appsV1.ControllerRevisionList)
+	err := r.List(ctx, list, client.InNamespace(myDeployment.Namespace), client.MatchingLabels(labels))
+	if err != nil {
+		return nil, err
+	}
+	var revisions []appsV1.ControllerRevision
+	for i := range list.Items {
+		if metav1.IsControlledBy(&list.Items[i], myDeployment) {
+			revisions = append(revisions, list.Items[i])
+		}
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+	return revisions, nil
+}
+
+// 同步 spec 历史版本，更新 status.updateRevision
+// 设置了 spec.rollbackTo 时，myDeployment.Spec 替换为对应版本的 spec，版本不存在时返回 false
+func (r *MyDeploymentReconciler) reconcileRevisions(ctx context.Context, myDeployment *myApiV1.MyDeployment) (bool, error) {
+	revisions, err := r.listSpecRevisions(ctx, myDeployment)
+	if err != nil {
+		return false, err
+	}
+	// 1. 使用历史版本的 spec，rollbackTo 和 revisionHistoryLimit 保持当前的值
+	if rollbackTo := myDeployment.Spec.RollbackTo; rollbackTo != nil {
+		index := -1
+		for i := range revisions {
+			if revisions[i].Revision == *rollbackTo {
+				index = i
+			}
+		}
+		if index == -1 {
+			return false, nil
+		}
+		spec := myApiV1.MyDeploymentSpec{}
+		err = json.Unmarshal(revisions[index].Data.Raw, &spec)
+		if err != nil {
+			return false, err
+		}
+		spec.RollbackTo = rollbackTo
+		spec.RevisionHistoryLimit = myDeployment.Spec.RevisionHistoryLimit
+		myDeployment.Spec = spec
+	}
+
+	// 2. 保存当前的 spec
+	var latest int64
+	if len(revisions) != 0 {
+		latest = revisions[len(revisions)-1].Revision
+	}
+	revision, err := NewSpecRevision(myDeployment, latest+1)
+	if err != nil {
+		return false, err
+	}
+	// data 相同，只需要在修改回之前的 spec 时更新 revision
+	err = r.syncOwnedObject(ctx, myDeployment, true, &revision, new(appsV1.ControllerRevision),
+		func(prev client.Object) bool {
+			return myDeployment.Spec.RollbackTo != nil || prev.(*appsV1.ControllerRevision).Revision == latest
+		})
+	if err != nil {
+		return false, err
+	}
+	myDeployment.Status.UpdateRevision = revision.Name
+
+	// 3. 清理超过数量的旧版本
+	var old []appsV1.ControllerRevision
+	for i := range revisions {
+		name := revisions[i].Name
+		if name != myDeployment.Status.UpdateRevision && name != myDeployment.Status.CurrentRevision {
+			old = append(old, revisions[i])
+		}
+	}
+	for i := 0; i < len(old)-int(myDeployment.Spec.EffectiveRevisionHistoryLimit()); i++ {
+		err = r.Client.Delete(ctx, &old[i])
+		if client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
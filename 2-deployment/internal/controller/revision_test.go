@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"context"
+	myApiV1 "deployment/api/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+)
+
+func newFakeReconciler(t *testing.T) *MyDeploymentReconciler {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := myApiV1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return &MyDeploymentReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Scheme: scheme,
+	}
+}
+
+// 返回 revision 到名称的映射
+func revisionNames(t *testing.T, r *MyDeploymentReconciler, myDeployment *myApiV1.MyDeployment) map[int64]string {
+	revisions, err := r.listSpecRevisions(context.Background(), myDeployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[int64]string{}
+	for _, revision := range revisions {
+		names[revision.Revision] = revision.Name
+	}
+	return names
+}
+
+func TestReconcileRevisions(t *testing.T) {
+	ctx := context.Background()
+	r := newFakeReconciler(t)
+	myDeployment := newMyDeployment("internal-cr.yaml")
+	myDeployment.Namespace = "default"
+	myDeployment.UID = types.UID("mydeployment-test-uid")
+
+	// 每次 Reconcile 都从 apiserver 获取 md，这里使用副本，避免 rollbackTo 替换后的 spec 影响下一步
+	reconcile := func(spec myApiV1.MyDeploymentSpec) (*myApiV1.MyDeployment, bool) {
+		md := myDeployment.DeepCopy()
+		md.Spec = spec
+		found, err := r.reconcileRevisions(ctx, md)
+		if err != nil {
+			t.Fatalf("reconcileRevisions() error = %v", err)
+		}
+		myDeployment.Status = md.Status
+		return md, found
+	}
+
+	// 1. 第一次 Reconcile，保存为版本 1
+	first := myDeployment.Spec
+	reconcile(first)
+	names := revisionNames(t, r, myDeployment)
+	if len(names) != 1 || names[1] != myDeployment.Status.UpdateRevision {
+		t.Fatalf("first revision got = %v, updateRevision %s", names, myDeployment.Status.UpdateRevision)
+	}
+	firstName := names[1]
+
+	// 2. 修改镜像，保存为版本 2
+	second := *first.DeepCopy()
+	second.Image = "nginx:1.27"
+	reconcile(second)
+	names = revisionNames(t, r, myDeployment)
+	secondName := names[2]
+	if len(names) != 2 || secondName == "" || secondName != myDeployment.Status.UpdateRevision {
+		t.Fatalf("second revision got = %v, updateRevision %s", names, myDeployment.Status.UpdateRevision)
+	}
+
+	// 3. 修改回之前的 spec，复用版本 1 的对象，revision 更新为 3
+	reconcile(first)
+	names = revisionNames(t, r, myDeployment)
+	if want := map[int64]string{2: secondName, 3: firstName}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("revert revision got = %v, want %v", names, want)
+	}
+
+	// 4. 设置 rollbackTo 为 2，spec 替换为版本 2 的 spec，revision 不变
+	rollbackTo := *first.DeepCopy()
+	rollbackTo.RollbackTo = ptr.To(int64(2))
+	md, found := reconcile(rollbackTo)
+	if !found || md.Spec.Image != second.Image || !reflect.DeepEqual(md.Spec.RollbackTo, rollbackTo.RollbackTo) {
+		t.Fatalf("rollbackTo got found = %v, spec = %+v", found, md.Spec)
+	}
+	if myDeployment.Status.UpdateRevision != secondName {
+		t.Errorf("rollbackTo updateRevision = %s, want %s", myDeployment.Status.UpdateRevision, secondName)
+	}
+	if names = revisionNames(t, r, myDeployment); names[2] != secondName {
+		t.Errorf("rollbackTo revision got = %v, want revision 2 unchanged", names)
+	}
+
+	// 5. rollbackTo 的版本不存在
+	rollbackTo.RollbackTo = ptr.To(int64(9))
+	if _, found = reconcile(rollbackTo); found {
+		t.Errorf("rollbackTo a missing revision got found = true")
+	}
+
+	// 6. revisionHistoryLimit 为 0，只保留 currentRevision 和 updateRevision
+	myDeployment.Status.CurrentRevision = secondName
+	third := *first.DeepCopy()
+	third.Image = "nginx:1.28"
+	third.RevisionHistoryLimit = ptr.To(int32(0))
+	reconcile(third)
+	names = revisionNames(t, r, myDeployment)
+	if want := map[int64]string{2: secondName, 4: myDeployment.Status.UpdateRevision}; !reflect.DeepEqual(names, want) {
+		t.Errorf("prune revision got = %v, want %v", names, want)
+	}
+}
+
+func TestNewSpecRevision(t *testing.T) {
+	myDeployment := newMyDeployment("internal-cr.yaml")
+	want, err := NewSpecRevision(myDeployment, 1)
+	if err != nil {
+		t.Fatalf("NewSpecRevision() error = %v", err)
+	}
+	// rollbackTo 和 revisionHistoryLimit 不影响子资源，不产生新的版本
+	myDeployment.Spec.RollbackTo = ptr.To(int64(1))
+	myDeployment.Spec.RevisionHistoryLimit = ptr.To(int32(3))
+	got, err := NewSpecRevision(myDeployment, 1)
+	if err != nil {
+		t.Fatalf("NewSpecRevision() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NewSpecRevision() got = %v, want %v", got, want)
+	}
+	if got.Labels[myApiV1.LabelKeySpecRevision] != "true" {
+		t.Errorf("NewSpecRevision() labels = %v, want %s label", got.Labels, myApiV1.LabelKeySpecRevision)
+	}
+}
@@ -0,0 +1,186 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+
+	appsv1 "deployment/api/v1"
+)
+
+// 缓存中的索引字段，用来在整个集群范围内查找占用了同一个 NodePort 或者域名的对象
+const (
+	nodePortIndexField        = ".spec.expose.nodePort"
+	ingressDomainIndexField   = ".spec.expose.ingressDomain"
+	serviceNodePortIndexField = ".spec.ports.nodePort"
+	ingressHostIndexField     = ".spec.rules.host"
+)
+
+// nodePort 模式的 MyDeployment，索引值为 spec.expose.nodePort
+func indexMyDeploymentNodePort(obj client.Object) []string {
+	mydeployment := obj.(*appsv1.MyDeployment)
+	expose := mydeployment.Spec.Expose
+	if expose == nil || expose.Mode != appsv1.ModeNodePort || expose.NodePort == 0 {
+		return nil
+	}
+	return []string{strconv.Itoa(int(expose.NodePort))}
+}
+
+// ingress 模式的 MyDeployment，索引值为 spec.expose.ingressDomain
+func indexMyDeploymentIngressDomain(obj client.Object) []string {
+	mydeployment := obj.(*appsv1.MyDeployment)
+	expose := mydeployment.Spec.Expose
+	if expose == nil || expose.Mode != appsv1.ModeIngress || expose.IngressDomain == "" {
+		return nil
+	}
+	return []string{expose.IngressDomain}
+}
+
+// Service 所有端口上已经分配的 NodePort
+func indexServiceNodePort(obj client.Object) []string {
+	service := obj.(*corev1.Service)
+	var nodePorts []string
+	for _, port := range service.Spec.Ports {
+		if port.NodePort != 0 {
+			nodePorts = append(nodePorts, strconv.Itoa(int(port.NodePort)))
+		}
+	}
+	return nodePorts
+}
+
+// Ingress 所有规则中的域名
+func indexIngressHost(obj client.Object) []string {
+	ingress := obj.(*networkingv1.Ingress)
+	var hosts []string
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host != "" {
+			hosts = append(hosts, rule.Host)
+		}
+	}
+	return hosts
+}
+
+// 在 manager 的缓存上建立冲突检测使用的索引
+func setupConflictIndexes(ctx context.Context, mgr ctrl.Manager) error {
+	indexer := mgr.GetFieldIndexer()
+	if err := indexer.IndexField(ctx, &appsv1.MyDeployment{}, nodePortIndexField, indexMyDeploymentNodePort); err != nil {
+		return err
+	}
+	if err := indexer.IndexField(ctx, &appsv1.MyDeployment{}, ingressDomainIndexField, indexMyDeploymentIngressDomain); err != nil {
+		return err
+	}
+	if err := indexer.IndexField(ctx, &corev1.Service{}, serviceNodePortIndexField, indexServiceNodePort); err != nil {
+		return err
+	}
+	return indexer.IndexField(ctx, &networkingv1.Ingress{}, ingressHostIndexField, indexIngressHost)
+}
+
+// 检查 spec.expose 中的 NodePort 和域名是否已经被集群中的其他对象占用
+// 1. nodePort 模式，检查其他 MyDeployment 和已经存在的 Service
+// 2. ingress 模式，检查其他 MyDeployment 和已经存在的 Ingress
+// 由这个 MyDeployment 生成的 Service 和 Ingress 不算冲突
+func (v *MyDeploymentCustomValidator) validateConflicts(ctx context.Context, mydeployment *appsv1.MyDeployment) field.ErrorList {
+	errs := field.ErrorList{}
+	expose := mydeployment.Spec.Expose
+	if v.Client == nil || expose == nil {
+		return errs
+	}
+
+	switch expose.Mode {
+	case appsv1.ModeNodePort:
+		nodePortPath := field.NewPath("spec", "expose", "nodePort")
+		nodePort := strconv.Itoa(int(expose.NodePort))
+		// 1.1 其他 MyDeployment
+		mydeployments := new(appsv1.MyDeploymentList)
+		if err := v.Client.List(ctx, mydeployments, client.MatchingFields{nodePortIndexField: nodePort}); err != nil {
+			return append(errs, field.InternalError(nodePortPath, err))
+		}
+		for i := range mydeployments.Items {
+			if isSameMyDeployment(&mydeployments.Items[i], mydeployment) {
+				continue
+			}
+			errs = append(errs, field.Invalid(nodePortPath, expose.NodePort,
+				fmt.Sprintf("NodePort 已经被 MyDeployment %s 使用", client.ObjectKeyFromObject(&mydeployments.Items[i]))))
+		}
+		// 1.2 已经存在的 Service
+		services := new(corev1.ServiceList)
+		if err := v.Client.List(ctx, services, client.MatchingFields{serviceNodePortIndexField: nodePort}); err != nil {
+			return append(errs, field.InternalError(nodePortPath, err))
+		}
+		for i := range services.Items {
+			if isOwnedByMyDeployment(&services.Items[i], mydeployment) {
+				continue
+			}
+			errs = append(errs, field.Invalid(nodePortPath, expose.NodePort,
+				fmt.Sprintf("NodePort 已经被 Service %s 使用", client.ObjectKeyFromObject(&services.Items[i]))))
+		}
+	case appsv1.ModeIngress:
+		domainPath := field.NewPath("spec", "expose", "ingressDomain")
+		// 2.1 其他 MyDeployment
+		mydeployments := new(appsv1.MyDeploymentList)
+		if err := v.Client.List(ctx, mydeployments, client.MatchingFields{ingressDomainIndexField: expose.IngressDomain}); err != nil {
+			return append(errs, field.InternalError(domainPath, err))
+		}
+		for i := range mydeployments.Items {
+			if isSameMyDeployment(&mydeployments.Items[i], mydeployment) {
+				continue
+			}
+			errs = append(errs, field.Invalid(domainPath, expose.IngressDomain,
+				fmt.Sprintf("域名已经被 MyDeployment %s 使用", client.ObjectKeyFromObject(&mydeployments.Items[i]))))
+		}
+		// 2.2 已经存在的 Ingress
+		ingresses := new(networkingv1.IngressList)
+		if err := v.Client.List(ctx, ingresses, client.MatchingFields{ingressHostIndexField: expose.IngressDomain}); err != nil {
+			return append(errs, field.InternalError(domainPath, err))
+		}
+		for i := range ingresses.Items {
+			if isOwnedByMyDeployment(&ingresses.Items[i], mydeployment) {
+				continue
+			}
+			errs = append(errs, field.Invalid(domainPath, expose.IngressDomain,
+				fmt.Sprintf("域名已经被 Ingress %s 使用", client.ObjectKeyFromObject(&ingresses.Items[i]))))
+		}
+	}
+	return errs
+}
+
+func isSameMyDeployment(a, b *appsv1.MyDeployment) bool {
+	return a.Namespace == b.Namespace && a.Name == b.Name
+}
+
+// Service / Ingress 由这个 MyDeployment 控制，只看 controller ownerReference，
+// 生成的对象不一定和 MyDeployment 同名，比如金丝雀的 <name>-canary Ingress 和主 Ingress 使用相同的域名
+// 创建 MyDeployment 时还没有 uid，所以 uid 或者名称相同都算
+func isOwnedByMyDeployment(obj client.Object, mydeployment *appsv1.MyDeployment) bool {
+	if obj.GetNamespace() != mydeployment.Namespace {
+		return false
+	}
+	ref := metav1.GetControllerOf(obj)
+	if ref == nil || ref.Kind != "MyDeployment" {
+		return false
+	}
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil || gv.Group != appsv1.GroupVersion.Group {
+		return false
+	}
+	return (mydeployment.UID != "" && ref.UID == mydeployment.UID) || ref.Name == mydeployment.Name
+}
+
+// 更新时只有 NodePort 或者域名发生了变化才检查冲突，
+// 避免已经存在的冲突（例如在关闭 webhook 期间创建的）阻塞其他字段的更新
+func exposeChanged(mydeployment, old *appsv1.MyDeployment) bool {
+	expose, oldExpose := mydeployment.Spec.Expose, old.Spec.Expose
+	if expose == nil || oldExpose == nil {
+		return expose != oldExpose
+	}
+	return expose.Mode != oldExpose.Mode ||
+		expose.NodePort != oldExpose.NodePort ||
+		expose.IngressDomain != oldExpose.IngressDomain
+}
@@ -0,0 +1,61 @@
+package v1
+
+import (
+	"fmt"
+	"github.com/distribution/reference"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"strings"
+)
+
+// ImagePolicy 镜像地址的校验策略，由 main 中的命令行参数传入，零值只校验镜像地址的格式
+type ImagePolicy struct {
+	// RequireDigest 镜像必须使用 digest 引用，如 nginx@sha256:...
+	RequireDigest bool
+	// ForbidLatest 禁止使用 latest 标签，没有标签也没有 digest 时等同于 latest
+	ForbidLatest bool
+	// AllowedRegistries 允许使用的镜像仓库，可以是仓库域名，如 docker.io，
+	// 也可以带上路径前缀，如 registry.example.com/team，为空时不限制
+	AllowedRegistries []string
+}
+
+// 校验 spec.image 或者 spec.canary.image，imagePath 为镜像地址字段的路径
+// 1. 按照 docker 的规则解析镜像地址，补全默认的仓库，如 nginx 解析为 docker.io/library/nginx
+// 2. 按照策略检查 digest 和 latest 标签
+// 3. 检查镜像仓库是否在允许的列表中
+func (p ImagePolicy) validate(imagePath *field.Path, image string) field.ErrorList {
+	errs := field.ErrorList{}
+
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return append(errs, field.Invalid(imagePath, image, fmt.Sprintf("镜像地址不合法: %s", err.Error())))
+	}
+
+	_, hasDigest := named.(reference.Digested)
+	if p.RequireDigest && !hasDigest {
+		errs = append(errs, field.Invalid(imagePath, image, "镜像必须使用 digest 引用，如 `nginx@sha256:...`"))
+	}
+	if p.ForbidLatest && !hasDigest {
+		tagged, hasTag := named.(reference.Tagged)
+		if !hasTag || tagged.Tag() == "latest" {
+			errs = append(errs, field.Invalid(imagePath, image, "不能使用 `latest` 标签，请指定明确的版本"))
+		}
+	}
+
+	if len(p.AllowedRegistries) != 0 && !p.allowed(named.Name()) {
+		errs = append(errs, field.Invalid(imagePath, image,
+			fmt.Sprintf("镜像仓库 %s 不在允许的列表中: %s",
+				reference.Domain(named), strings.Join(p.AllowedRegistries, ", "))))
+	}
+	return errs
+}
+
+// name 为补全后不带标签和 digest 的镜像名称，和允许的仓库相同或者以 仓库/ 开头时允许
+func (p ImagePolicy) allowed(name string) bool {
+	for _, registry := range p.AllowedRegistries {
+		registry = strings.TrimSuffix(registry, "/")
+		if name == registry || strings.HasPrefix(name, registry+"/") {
+			return true
+		}
+	}
+	return false
+}
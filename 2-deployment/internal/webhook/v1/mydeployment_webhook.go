@@ -19,8 +19,12 @@ package v1
 import (
 	"context"
 	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -32,11 +36,29 @@ import (
 // log is for logging in this package.
 var mydeploymentlog = logf.Log.WithName("mydeployment-resource")
 
+// Options 控制 webhook 的可选行为，由 main 中的命令行参数传入
+type Options struct {
+	// DefaultTopologySpread 副本数大于 1 且没有设置拓扑分布约束时，注入按 zone 和 hostname 打散的约束
+	DefaultTopologySpread bool
+	// ImagePolicy spec.image 的校验策略
+	ImagePolicy ImagePolicy
+}
+
 // SetupMyDeploymentWebhookWithManager registers the webhook for MyDeployment in the manager.
-func SetupMyDeploymentWebhookWithManager(mgr ctrl.Manager) error {
+// 转换 webhook /convert 在 main 中通过 v2 单独注册，不依赖这里的准入 webhook
+func SetupMyDeploymentWebhookWithManager(mgr ctrl.Manager, opts Options) error {
+	// 建立 NodePort 和域名的索引，用于检查集群范围内的冲突
+	if err := setupConflictIndexes(context.Background(), mgr); err != nil {
+		return err
+	}
 	return ctrl.NewWebhookManagedBy(mgr).For(&appsv1.MyDeployment{}).
-		WithValidator(&MyDeploymentCustomValidator{}).
-		WithDefaulter(&MyDeploymentCustomDefaulter{}).
+		WithValidator(&MyDeploymentCustomValidator{
+			Client:      mgr.GetClient(),
+			ImagePolicy: opts.ImagePolicy,
+		}).
+		WithDefaulter(&MyDeploymentCustomDefaulter{
+			DefaultTopologySpread: opts.DefaultTopologySpread,
+		}).
 		Complete()
 }
 
@@ -50,7 +72,8 @@ func SetupMyDeploymentWebhookWithManager(mgr ctrl.Manager) error {
 // NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
 // as it is used only for temporary operations and does not need to be deeply copied.
 type MyDeploymentCustomDefaulter struct {
-	// TODO(user): Add more fields as needed for defaulting
+	// DefaultTopologySpread 副本数大于 1 且没有设置拓扑分布约束时，注入按 zone 和 hostname 打散的约束
+	DefaultTopologySpread bool
 }
 
 var _ webhook.CustomDefaulter = &MyDeploymentCustomDefaulter{}
@@ -71,14 +94,50 @@ func (d *MyDeploymentCustomDefaulter) Default(ctx context.Context, obj runtime.O
 	}
 
 	// 可以允许用户自己指定 service 的 port 值
-	// 如果不指定，则使用服务的 port 值来代替
-	if mydeployment.Spec.Expose.ServicePort == 0 {
+	// 如果不指定，则使用服务的 port 值来代替，没有设置 expose 时只在集群内部访问，生成时同样使用服务的 port
+	if mydeployment.Spec.Expose != nil && mydeployment.Spec.Expose.ServicePort == 0 {
 		mydeployment.Spec.Expose.ServicePort = mydeployment.Spec.Port
 	}
 
+	// 设置了配置文件，但是没有指定挂载目录，使用默认的目录
+	if len(mydeployment.Spec.ConfigFiles) != 0 && mydeployment.Spec.ConfigFilesMountPath == "" {
+		mydeployment.Spec.ConfigFilesMountPath = appsv1.DefaultConfigFilesMountPath
+	}
+
+	// 多个副本的时候，尽量把副本打散到不同的 zone 和节点上，用户自己设置了约束则以用户的为准
+	if d.DefaultTopologySpread && mydeployment.Spec.Replicas > 1 &&
+		(mydeployment.Spec.Scheduling == nil || len(mydeployment.Spec.Scheduling.TopologySpreadConstraints) == 0) {
+		if mydeployment.Spec.Scheduling == nil {
+			mydeployment.Spec.Scheduling = &appsv1.Scheduling{}
+		}
+		mydeployment.Spec.Scheduling.TopologySpreadConstraints = defaultTopologySpreadConstraints(mydeployment)
+	}
+
 	return nil
 }
 
+// 按 zone 和 hostname 打散，使用 ScheduleAnyway，在单 zone 或者节点不足的集群中也不会导致 pod 无法调度
+// 只选择主 Deployment 的 pod，金丝雀 pod 不参与打散的计算
+func defaultTopologySpreadConstraints(mydeployment *appsv1.MyDeployment) []corev1.TopologySpreadConstraint {
+	selector := &metav1.LabelSelector{
+		MatchLabels: mydeployment.StableLabels(),
+	}
+	return []corev1.TopologySpreadConstraint{
+		{
+			MaxSkew:           1,
+			TopologyKey:       corev1.LabelTopologyZone,
+			WhenUnsatisfiable: corev1.ScheduleAnyway,
+			LabelSelector:     selector,
+		},
+		{
+			MaxSkew:           1,
+			TopologyKey:       corev1.LabelHostname,
+			WhenUnsatisfiable: corev1.ScheduleAnyway,
+			LabelSelector:     selector.DeepCopy(),
+		},
+	}
+}
+
 // TODO(user): change verbs to "verbs=create;update;delete" if you want to enable deletion validation.
 // NOTE: The 'path' attribute must follow a specific pattern and should not be modified directly here.
 // Modifying the path for an invalid path can cause API server errors; failing to locate the webhook.
@@ -90,7 +149,10 @@ func (d *MyDeploymentCustomDefaulter) Default(ctx context.Context, obj runtime.O
 // NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
 // as this struct is used only for temporary operations and does not need to be deeply copied.
 type MyDeploymentCustomValidator struct {
-	//TODO(user): Add more fields as needed for validation
+	// Client 读取 manager 的缓存，检查 NodePort 和域名是否和集群中的其他对象冲突，为空时不检查
+	Client client.Client
+	// ImagePolicy spec.image 的校验策略
+	ImagePolicy ImagePolicy
 }
 
 var _ webhook.CustomValidator = &MyDeploymentCustomValidator{}
@@ -103,7 +165,15 @@ func (v *MyDeploymentCustomValidator) ValidateCreate(ctx context.Context, obj ru
 	}
 	mydeploymentlog.Info("Validation for MyDeployment upon creation", "name", mydeployment.GetName())
 
-	return nil, mydeployment.ValidateCreateAndUpdate()
+	if err := mydeployment.ValidateCreateAndUpdate(); err != nil {
+		return nil, err
+	}
+	errs := v.ImagePolicy.validate(field.NewPath("spec", "image"), mydeployment.Spec.Image)
+	if mydeployment.Spec.Canary != nil {
+		errs = append(errs, v.ImagePolicy.validate(field.NewPath("spec", "canary", "image"), mydeployment.Spec.Canary.Image)...)
+	}
+	errs = append(errs, v.validateConflicts(ctx, mydeployment)...)
+	return nil, errs.ToAggregate()
 }
 
 // ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type MyDeployment.
@@ -112,9 +182,30 @@ func (v *MyDeploymentCustomValidator) ValidateUpdate(ctx context.Context, oldObj
 	if !ok {
 		return nil, fmt.Errorf("expected a MyDeployment object for the newObj but got %T", newObj)
 	}
+	oldMyDeployment, ok := oldObj.(*appsv1.MyDeployment)
+	if !ok {
+		return nil, fmt.Errorf("expected a MyDeployment object for the oldObj but got %T", oldObj)
+	}
 	mydeploymentlog.Info("Validation for MyDeployment upon update", "name", mydeployment.GetName())
 
-	return nil, mydeployment.ValidateCreateAndUpdate()
+	// 更新时需要和旧对象比较，拒绝不可修改的字段，对会造成服务中断的修改给出警告
+	warnings, err := mydeployment.ValidateUpdate(oldMyDeployment)
+	if err != nil {
+		return warnings, err
+	}
+	// 和冲突检测一样，只在镜像修改时校验，策略收紧后不影响已有对象其他字段的更新
+	errs := field.ErrorList{}
+	if mydeployment.Spec.Image != oldMyDeployment.Spec.Image {
+		errs = append(errs, v.ImagePolicy.validate(field.NewPath("spec", "image"), mydeployment.Spec.Image)...)
+	}
+	if canary := mydeployment.Spec.Canary; canary != nil &&
+		(oldMyDeployment.Spec.Canary == nil || canary.Image != oldMyDeployment.Spec.Canary.Image) {
+		errs = append(errs, v.ImagePolicy.validate(field.NewPath("spec", "canary", "image"), canary.Image)...)
+	}
+	if exposeChanged(mydeployment, oldMyDeployment) {
+		errs = append(errs, v.validateConflicts(ctx, mydeployment)...)
+	}
+	return warnings, errs.ToAggregate()
 }
 
 // ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type MyDeployment.
@@ -17,11 +17,22 @@ limitations under the License.
 package v1
 
 import (
+	"strings"
+
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apimachineryruntime "k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
 	appsv1 "deployment/api/v1"
-	// TODO (user): Add any additional imports if needed
 )
 
 var _ = Describe("MyDeployment Webhook", func() {
@@ -49,39 +60,532 @@ var _ = Describe("MyDeployment Webhook", func() {
 	})
 
 	Context("When creating MyDeployment under Defaulting Webhook", func() {
-		// TODO (user): Add logic for defaulting webhooks
-		// Example:
-		// It("Should apply defaults when a required field is empty", func() {
-		//     By("simulating a scenario where defaults should be applied")
-		//     obj.SomeFieldWithDefault = ""
-		//     By("calling the Default method to apply defaults")
-		//     defaulter.Default(ctx, obj)
-		//     By("checking that the default values are set")
-		//     Expect(obj.SomeFieldWithDefault).To(Equal("default_value"))
-		// })
+		BeforeEach(func() {
+			obj.Name = "mydeployment-test"
+			obj.Spec.Image = "nginx"
+			obj.Spec.Port = 80
+			obj.Spec.Expose = &appsv1.Expose{
+				Mode:          appsv1.ModeIngress,
+				IngressDomain: "www.shudong-test.com",
+			}
+		})
+
+		It("Should inject topology spread constraints when replicas > 1", func() {
+			By("enabling the default topology spread option")
+			defaulter.DefaultTopologySpread = true
+			obj.Spec.Replicas = 3
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Scheduling).NotTo(BeNil())
+			Expect(obj.Spec.Scheduling.TopologySpreadConstraints).To(HaveLen(2))
+			Expect(obj.Spec.Scheduling.TopologySpreadConstraints[0].LabelSelector.MatchLabels).
+				To(HaveKeyWithValue(appsv1.LabelKeyApp, obj.Name))
+			By("selecting only the stable pods, not the canary pods")
+			for _, constraint := range obj.Spec.Scheduling.TopologySpreadConstraints {
+				Expect(constraint.LabelSelector.MatchLabels).To(Equal(obj.StableLabels()))
+				Expect(constraint.LabelSelector.MatchLabels).
+					To(HaveKeyWithValue(appsv1.LabelKeyTrack, appsv1.LabelValueStable))
+			}
+		})
+
+		It("Should not inject topology spread constraints when replicas is 1", func() {
+			defaulter.DefaultTopologySpread = true
+			obj.Spec.Replicas = 1
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Scheduling).To(BeNil())
+		})
+
+		It("Should keep user defined topology spread constraints", func() {
+			defaulter.DefaultTopologySpread = true
+			obj.Spec.Replicas = 3
+			obj.Spec.Scheduling = &appsv1.Scheduling{
+				TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+					{MaxSkew: 2, TopologyKey: corev1.LabelHostname, WhenUnsatisfiable: corev1.DoNotSchedule},
+				},
+			}
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Scheduling.TopologySpreadConstraints).To(HaveLen(1))
+			Expect(obj.Spec.Scheduling.TopologySpreadConstraints[0].MaxSkew).To(Equal(int32(2)))
+		})
+
+		It("Should not inject topology spread constraints when the option is disabled", func() {
+			obj.Spec.Replicas = 3
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Scheduling).To(BeNil())
+		})
+
+		It("Should default the service port to the container port", func() {
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Expose.ServicePort).To(Equal(int32(80)))
+		})
+
+		It("Should leave expose empty when it is not set", func() {
+			obj.Spec.Expose = nil
+			Expect(defaulter.Default(ctx, obj)).To(Succeed())
+			Expect(obj.Spec.Expose).To(BeNil())
+			Expect(obj.Spec.ServicePort()).To(Equal(int32(80)))
+		})
 	})
 
 	Context("When creating or updating MyDeployment under Validating Webhook", func() {
-		// TODO (user): Add logic for validating webhooks
-		// Example:
-		// It("Should deny creation if a required field is missing", func() {
-		//     By("simulating an invalid creation scenario")
-		//     obj.SomeRequiredField = ""
-		//     Expect(validator.ValidateCreate(ctx, obj)).Error().To(HaveOccurred())
-		// })
-		//
-		// It("Should admit creation if all required fields are present", func() {
-		//     By("simulating an invalid creation scenario")
-		//     obj.SomeRequiredField = "valid_value"
-		//     Expect(validator.ValidateCreate(ctx, obj)).To(BeNil())
-		// })
-		//
-		// It("Should validate updates correctly", func() {
-		//     By("simulating a valid update scenario")
-		//     oldObj.SomeRequiredField = "updated_value"
-		//     obj.SomeRequiredField = "updated_value"
-		//     Expect(validator.ValidateUpdate(ctx, oldObj, obj)).To(BeNil())
-		// })
+		BeforeEach(func() {
+			oldObj.Name = "mydeployment-test"
+			oldObj.Spec.Image = "nginx"
+			oldObj.Spec.Port = 80
+			oldObj.Spec.Expose = &appsv1.Expose{
+				Mode:          appsv1.ModeIngress,
+				IngressDomain: "www.shudong-test.com",
+				ServicePort:   80,
+			}
+			oldObj.Spec.Storage = &appsv1.Storage{
+				Size:      resource.MustParse("1Gi"),
+				MountPath: "/data",
+			}
+			obj = oldObj.DeepCopy()
+		})
+
+		It("Should admit an update without changes", func() {
+			warnings, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+		})
+
+		It("Should deny shrinking the storage", func() {
+			obj.Spec.Storage.Size = resource.MustParse("512Mi")
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.storage.size"))
+		})
+
+		It("Should allow expanding the storage", func() {
+			obj.Spec.Storage.Size = resource.MustParse("2Gi")
+			Expect(validator.ValidateUpdate(ctx, oldObj, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should deny changing the storage class and access modes", func() {
+			obj.Spec.Storage.StorageClassName = ptr.To("fast")
+			obj.Spec.Storage.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.storage.storageClassName"))
+			Expect(err.Error()).To(ContainSubstring("spec.storage.accessModes"))
+		})
+
+		It("Should treat empty access modes as ReadWriteOnce", func() {
+			obj.Spec.Storage.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+			Expect(validator.ValidateUpdate(ctx, oldObj, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should warn when the storage is removed", func() {
+			obj.Spec.Storage = nil
+			warnings, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(HaveLen(1))
+		})
+
+		It("Should warn when rolling back to a revision", func() {
+			obj.Spec.RollbackTo = ptr.To(int64(2))
+			warnings, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(HaveLen(1))
+			Expect(warnings[0]).To(ContainSubstring("spec.rollbackTo"))
+		})
+
+		It("Should warn when changing the spec while rolled back", func() {
+			oldObj.Spec.RollbackTo = ptr.To(int64(2))
+			obj = oldObj.DeepCopy()
+			obj.Spec.Image = "nginx:1.27"
+			warnings, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(HaveLen(1))
+			Expect(warnings[0]).To(ContainSubstring("spec.rollbackTo"))
+		})
+
+		It("Should deny renaming a generated service account", func() {
+			oldObj.Spec.ServiceAccount = &appsv1.ServiceAccount{Create: true}
+			obj.Spec.ServiceAccount = &appsv1.ServiceAccount{Create: true, Name: "other"}
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.serviceAccount.name"))
+		})
+
+		It("Should warn when switching the expose mode", func() {
+			obj.Spec.Expose = &appsv1.Expose{
+				Mode:        appsv1.ModeNodePort,
+				NodePort:    30080,
+				ServicePort: 80,
+			}
+			warnings, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(HaveLen(1))
+			Expect(warnings[0]).To(ContainSubstring("spec.expose.mode"))
+		})
+
+		It("Should warn when changing the node port", func() {
+			oldObj.Spec.Expose = &appsv1.Expose{Mode: appsv1.ModeNodePort, NodePort: 30080, ServicePort: 80}
+			obj.Spec.Expose = &appsv1.Expose{Mode: appsv1.ModeNodePort, NodePort: 30081, ServicePort: 80}
+			warnings, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(HaveLen(1))
+			Expect(warnings[0]).To(ContainSubstring("spec.expose.nodePort"))
+		})
+
+		It("Should warn when toggling tls", func() {
+			obj.Spec.Expose.Tls = true
+			warnings, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(HaveLen(1))
+			Expect(warnings[0]).To(ContainSubstring("spec.expose.tls"))
+		})
+
+		It("Should admit creating without expose", func() {
+			obj.Spec.Expose = nil
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should admit an expose with only the service port", func() {
+			obj.Spec.Expose = &appsv1.Expose{ServicePort: 8080}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should warn when expose is removed", func() {
+			obj.Spec.Expose = nil
+			warnings, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(HaveLen(1))
+			Expect(warnings[0]).To(ContainSubstring("spec.expose"))
+		})
+
+		It("Should not warn when expose is added", func() {
+			oldObj.Spec.Expose = nil
+			warnings, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+		})
+
+		It("Should still run the create validation on update", func() {
+			obj.Spec.Expose.IngressDomain = ""
+			_, err := validator.ValidateUpdate(ctx, oldObj, obj)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("When validating NodePort and IngressDomain conflicts", func() {
+		var existing []client.Object
+
+		BeforeEach(func() {
+			obj.Namespace = "default"
+			obj.Name = "mydeployment-test"
+			obj.Spec.Image = "nginx"
+			obj.Spec.Port = 80
+			existing = nil
+		})
+
+		JustBeforeEach(func() {
+			scheme := apimachineryruntime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+			validator.Client = fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(existing...).
+				WithIndex(&appsv1.MyDeployment{}, nodePortIndexField, indexMyDeploymentNodePort).
+				WithIndex(&appsv1.MyDeployment{}, ingressDomainIndexField, indexMyDeploymentIngressDomain).
+				WithIndex(&corev1.Service{}, serviceNodePortIndexField, indexServiceNodePort).
+				WithIndex(&networkingv1.Ingress{}, ingressHostIndexField, indexIngressHost).
+				Build()
+		})
+
+		Context("with a NodePort already used", func() {
+			BeforeEach(func() {
+				obj.Spec.Expose = &appsv1.Expose{Mode: appsv1.ModeNodePort, NodePort: 30080, ServicePort: 80}
+				other := obj.DeepCopy()
+				other.Namespace = "other"
+				service := &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "dashboard"},
+					Spec: corev1.ServiceSpec{
+						Type:  corev1.ServiceTypeNodePort,
+						Ports: []corev1.ServicePort{{Port: 443, NodePort: 30080}},
+					},
+				}
+				existing = []client.Object{other, service}
+			})
+
+			It("Should deny creation and report every owner of the port", func() {
+				_, err := validator.ValidateCreate(ctx, obj)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("spec.expose.nodePort"))
+				Expect(err.Error()).To(ContainSubstring("other/mydeployment-test"))
+				Expect(err.Error()).To(ContainSubstring("kube-system/dashboard"))
+			})
+
+			It("Should not check conflicts when the expose is unchanged on update", func() {
+				Expect(validator.ValidateUpdate(ctx, obj.DeepCopy(), obj)).Error().NotTo(HaveOccurred())
+			})
+		})
+
+		Context("with the Service generated by itself", func() {
+			BeforeEach(func() {
+				obj.Spec.Expose = &appsv1.Expose{Mode: appsv1.ModeNodePort, NodePort: 30080, ServicePort: 80}
+				service := &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: obj.Namespace,
+						Name:      obj.Name,
+						OwnerReferences: []metav1.OwnerReference{{
+							APIVersion: appsv1.GroupVersion.String(),
+							Kind:       "MyDeployment",
+							Name:       obj.Name,
+							Controller: ptr.To(true),
+						}},
+					},
+					Spec: corev1.ServiceSpec{
+						Type:  corev1.ServiceTypeNodePort,
+						Ports: []corev1.ServicePort{{Port: 80, NodePort: 30080}},
+					},
+				}
+				existing = []client.Object{obj.DeepCopy(), service}
+			})
+
+			It("Should admit the update", func() {
+				oldObj = obj.DeepCopy()
+				oldObj.Spec.Expose.NodePort = 30081
+				Expect(validator.ValidateUpdate(ctx, oldObj, obj)).Error().NotTo(HaveOccurred())
+			})
+		})
+
+		Context("with the canary Ingress generated by itself", func() {
+			BeforeEach(func() {
+				obj.Spec.Expose = &appsv1.Expose{Mode: appsv1.ModeIngress, IngressDomain: "www.shudong-test.com"}
+				// 金丝雀 Ingress 和主 Ingress 使用相同的域名，名称为 <name>-canary
+				canaryIngress := &networkingv1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: obj.Namespace,
+						Name:      obj.Name + "-canary",
+						OwnerReferences: []metav1.OwnerReference{{
+							APIVersion: appsv1.GroupVersion.String(),
+							Kind:       "MyDeployment",
+							Name:       obj.Name,
+							Controller: ptr.To(true),
+						}},
+					},
+					Spec: networkingv1.IngressSpec{
+						Rules: []networkingv1.IngressRule{{Host: "www.shudong-test.com"}},
+					},
+				}
+				existing = []client.Object{canaryIngress}
+			})
+
+			It("Should admit the update", func() {
+				oldObj = obj.DeepCopy()
+				oldObj.Spec.Expose.IngressDomain = "api.shudong-test.com"
+				Expect(validator.ValidateUpdate(ctx, oldObj, obj)).Error().NotTo(HaveOccurred())
+			})
+
+			It("Should deny another MyDeployment with the same name in another namespace", func() {
+				obj.Namespace = "other"
+				_, err := validator.ValidateCreate(ctx, obj)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("mydeployment-test-canary"))
+			})
+		})
+
+		Context("with an Ingress of the same name not controlled by it", func() {
+			BeforeEach(func() {
+				obj.Spec.Expose = &appsv1.Expose{Mode: appsv1.ModeIngress, IngressDomain: "www.shudong-test.com"}
+				ingress := &networkingv1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{Namespace: obj.Namespace, Name: obj.Name},
+					Spec: networkingv1.IngressSpec{
+						Rules: []networkingv1.IngressRule{{Host: "www.shudong-test.com"}},
+					},
+				}
+				existing = []client.Object{ingress}
+			})
+
+			It("Should deny creation", func() {
+				_, err := validator.ValidateCreate(ctx, obj)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("default/mydeployment-test"))
+			})
+		})
+
+		Context("with an IngressDomain already used", func() {
+			BeforeEach(func() {
+				obj.Spec.Expose = &appsv1.Expose{Mode: appsv1.ModeIngress, IngressDomain: "www.shudong-test.com"}
+				ingress := &networkingv1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "web"},
+					Spec: networkingv1.IngressSpec{
+						Rules: []networkingv1.IngressRule{{Host: "www.shudong-test.com"}},
+					},
+				}
+				existing = []client.Object{ingress}
+			})
+
+			It("Should deny creation", func() {
+				_, err := validator.ValidateCreate(ctx, obj)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("spec.expose.ingressDomain"))
+				Expect(err.Error()).To(ContainSubstring("other/web"))
+			})
+
+			It("Should admit a different domain", func() {
+				obj.Spec.Expose.IngressDomain = "api.shudong-test.com"
+				Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+			})
+
+			It("Should admit creation without expose", func() {
+				obj.Spec.Expose = nil
+				Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+			})
+		})
+	})
+
+	Context("When validating the image reference", func() {
+		BeforeEach(func() {
+			obj.Name = "mydeployment-test"
+			obj.Spec.Port = 80
+			obj.Spec.Expose = &appsv1.Expose{
+				Mode:          appsv1.ModeIngress,
+				IngressDomain: "www.shudong-test.com",
+			}
+		})
+
+		It("Should deny an invalid image reference", func() {
+			obj.Spec.Image = "Nginx:1.27"
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.image"))
+		})
+
+		It("Should admit any valid image without a policy", func() {
+			obj.Spec.Image = "nginx"
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should deny latest and untagged images when latest is forbidden", func() {
+			validator.ImagePolicy.ForbidLatest = true
+			for _, image := range []string{"nginx", "nginx:latest"} {
+				obj.Spec.Image = image
+				Expect(validator.ValidateCreate(ctx, obj)).Error().To(HaveOccurred(), image)
+			}
+			obj.Spec.Image = "nginx:1.27"
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should deny tagged images when a digest is required", func() {
+			validator.ImagePolicy.RequireDigest = true
+			obj.Spec.Image = "nginx:1.27"
+			Expect(validator.ValidateCreate(ctx, obj)).Error().To(HaveOccurred())
+			obj.Spec.Image = "nginx@sha256:" + strings.Repeat("a", 64)
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should only admit images from allowed registries", func() {
+			validator.ImagePolicy.AllowedRegistries = []string{"docker.io/library", "registry.example.com"}
+			for _, image := range []string{"nginx:1.27", "registry.example.com/team/app:v1"} {
+				obj.Spec.Image = image
+				Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred(), image)
+			}
+			for _, image := range []string{"bitnami/nginx:1.27", "registry.example.com.evil.io/app:v1", "quay.io/app:v1"} {
+				obj.Spec.Image = image
+				Expect(validator.ValidateCreate(ctx, obj)).Error().To(HaveOccurred(), image)
+			}
+		})
+
+		It("Should not check an unchanged image on update", func() {
+			obj.Spec.Image = "nginx"
+			oldObj = obj.DeepCopy()
+			obj.Spec.Replicas = 2
+			validator.ImagePolicy.ForbidLatest = true
+			Expect(validator.ValidateUpdate(ctx, oldObj, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should check the canary image with the same policy", func() {
+			obj.Spec.Image = "nginx:1.26"
+			obj.Spec.Canary = &appsv1.Canary{Image: "nginx"}
+			validator.ImagePolicy.ForbidLatest = true
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.canary.image"))
+		})
+	})
+
+	Context("When validating the canary release", func() {
+		BeforeEach(func() {
+			obj.Name = "mydeployment-test"
+			obj.Spec.Image = "nginx:1.26"
+			obj.Spec.Port = 80
+			obj.Spec.Expose = &appsv1.Expose{
+				Mode:          appsv1.ModeIngress,
+				IngressDomain: "www.shudong-test.com",
+			}
+			obj.Spec.Canary = &appsv1.Canary{Image: "nginx:1.27", Weight: ptr.To(int32(20))}
+		})
+
+		It("Should admit a weighted canary in ingress mode", func() {
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should deny a canary without an image", func() {
+			obj.Spec.Canary.Image = ""
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.canary.image"))
+		})
+
+		It("Should deny the canary weight outside ingress mode", func() {
+			obj.Spec.Expose = &appsv1.Expose{Mode: appsv1.ModeNodePort, NodePort: 30080}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.canary.weight"))
+		})
+
+		It("Should admit a canary by replicas outside ingress mode", func() {
+			obj.Spec.Expose = nil
+			obj.Spec.Canary.Weight = nil
+			obj.Spec.Canary.Replicas = ptr.To(int32(1))
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+	})
+
+	Context("When validating the blue/green strategy", func() {
+		BeforeEach(func() {
+			obj.Name = "mydeployment-test"
+			obj.Spec.Image = "nginx:1.26"
+			obj.Spec.Port = 80
+			obj.Spec.Strategy = &appsv1.Strategy{
+				Type:      appsv1.StrategyBlueGreen,
+				BlueGreen: &appsv1.BlueGreenStrategy{AutoPromote: ptr.To(false)},
+			}
+		})
+
+		It("Should admit the blue/green strategy", func() {
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
+
+		It("Should deny the blue/green config with the rolling update strategy", func() {
+			obj.Spec.Strategy.Type = appsv1.StrategyRollingUpdate
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.strategy.blueGreen"))
+		})
+
+		It("Should deny a canary with the blue/green strategy", func() {
+			obj.Spec.Canary = &appsv1.Canary{Image: "nginx:1.27"}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.canary"))
+		})
+
+		It("Should deny the automatic rollback with the blue/green strategy", func() {
+			obj.Spec.Rollback = &appsv1.Rollback{Enabled: true}
+			_, err := validator.ValidateCreate(ctx, obj)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("spec.rollback.enabled"))
+		})
+
+		It("Should admit the automatic rollback with the rolling update strategy", func() {
+			obj.Spec.Strategy = &appsv1.Strategy{Type: appsv1.StrategyRollingUpdate}
+			obj.Spec.Rollback = &appsv1.Rollback{Enabled: true, ProgressDeadlineSeconds: ptr.To(int32(120))}
+			Expect(validator.ValidateCreate(ctx, obj)).Error().NotTo(HaveOccurred())
+		})
 	})
 
 })
@@ -32,9 +32,11 @@ import (
 	admissionv1 "k8s.io/api/admission/v1"
 
 	appsv1 "deployment/api/v1"
+	appsv2 "deployment/api/v2"
 
 	// +kubebuilder:scaffold:imports
 	apimachineryruntime "k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -95,9 +97,17 @@ var _ = BeforeSuite(func() {
 	err = appsv1.AddToScheme(scheme)
 	Expect(err).NotTo(HaveOccurred())
 
+	// v2 是存储版本，通过 v2 注册 /convert
+	err = appsv2.AddToScheme(scheme)
+	Expect(err).NotTo(HaveOccurred())
+
 	err = admissionv1.AddToScheme(scheme)
 	Expect(err).NotTo(HaveOccurred())
 
+	// 冲突检测需要在缓存中索引 Service 和 Ingress
+	err = clientgoscheme.AddToScheme(scheme)
+	Expect(err).NotTo(HaveOccurred())
+
 	// +kubebuilder:scaffold:scheme
 
 	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme})
@@ -118,7 +128,11 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).NotTo(HaveOccurred())
 
-	err = SetupMyDeploymentWebhookWithManager(mgr)
+	// 和 main 相同，转换 webhook 单独注册
+	err = ctrl.NewWebhookManagedBy(mgr).For(&appsv2.MyDeployment{}).Complete()
+	Expect(err).NotTo(HaveOccurred())
+
+	err = SetupMyDeploymentWebhookWithManager(mgr, Options{})
 	Expect(err).NotTo(HaveOccurred())
 
 	// +kubebuilder:scaffold:webhook
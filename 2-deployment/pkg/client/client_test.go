@@ -0,0 +1,98 @@
+package client_test
+
+import (
+	"context"
+	myApiV1 "deployment/api/v1"
+	"deployment/pkg/client/clientset/versioned/fake"
+	"deployment/pkg/client/informers/externalversions"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"testing"
+	"time"
+)
+
+func newMyDeployment(namespace, name string) *myApiV1.MyDeployment {
+	return &myApiV1.MyDeployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       myApiV1.MyDeploymentSpec{Image: "nginx", Port: 80, Replicas: 1},
+	}
+}
+
+// 使用 fake clientset 验证 typed client 的增删改查
+func TestFakeClientset(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset(newMyDeployment("default", "existing"))
+	client := clientset.AppsV1().MyDeployments("default")
+
+	// 1. 获取初始化的对象
+	if _, err := client.Get(ctx, "existing", metav1.GetOptions{}); err != nil {
+		t.Fatalf("get existing: %v", err)
+	}
+	// 2. 创建、更新
+	created, err := client.Create(ctx, newMyDeployment("default", "created"), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	created.Spec.Replicas = 3
+	if _, err := client.Update(ctx, created, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	got, err := client.Get(ctx, "created", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get created: %v", err)
+	}
+	if got.Spec.Replicas != 3 {
+		t.Errorf("replicas = %d, want 3", got.Spec.Replicas)
+	}
+	// 3. 只列出 namespace 中的对象
+	if _, err := clientset.AppsV1().MyDeployments("other").Create(ctx, newMyDeployment("other", "other"), metav1.CreateOptions{}); err != nil {
+		t.Fatalf("create in other namespace: %v", err)
+	}
+	list, err := client.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(list.Items) != 2 {
+		t.Errorf("list returned %d items, want 2", len(list.Items))
+	}
+	// 4. 删除
+	if err := client.Delete(ctx, "created", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := client.Get(ctx, "created", metav1.GetOptions{}); err == nil {
+		t.Errorf("get after delete: expected not found")
+	}
+}
+
+// informer 同步 fake clientset 中的对象，lister 从缓存中读取
+func TestInformerAndLister(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clientset := fake.NewSimpleClientset(newMyDeployment("default", "existing"))
+	factory := externalversions.NewSharedInformerFactory(clientset, 0)
+	informer := factory.Apps().V1().MyDeployments()
+	lister := informer.Lister()
+	factory.Start(ctx.Done())
+	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			t.Fatalf("%v not synced", informerType)
+		}
+	}
+
+	if _, err := lister.MyDeployments("default").Get("existing"); err != nil {
+		t.Fatalf("lister get existing: %v", err)
+	}
+	// 通过 watch 同步新创建的对象
+	_, err := clientset.AppsV1().MyDeployments("default").Create(ctx, newMyDeployment("default", "created"), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		items, err := lister.List(labels.Everything())
+		return len(items) == 2, err
+	})
+	if err != nil {
+		t.Fatalf("wait for lister to observe created object: %v", err)
+	}
+}
@@ -2,15 +2,15 @@ package create
 
 import (
 	"context"
+	myApiV1 "deployment/api/v1"
+	myclientv1 "deployment/pkg/client/clientset/versioned/typed/apps/v1"
 	"deployment/test/framework"
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"time"
 )
 
 // 真正的测试，测试创建 ingress 模式
@@ -18,74 +18,76 @@ func CreateIngressMyDeployment(ctx *framework.TestContext, f *framework.Framewor
 
 	var (
 		// 1. 准备测试数据
-		crFilePath    = "create/testdata/create-ingress.yaml"
-		obj           = &unstructured.Unstructured{Object: make(map[string]interface{})}
-		dynamicClient dynamic.Interface
-		clientSet     *kubernetes.Clientset
+		crFilePath         = "create/testdata/create-ingress.yaml"
+		obj                = &myApiV1.MyDeployment{}
+		myDeploymentClient myclientv1.MyDeploymentInterface
+		clientSet          *kubernetes.Clientset
 		// 3. 准备测试用到的全局变量
-		myGVR = schema.GroupVersionResource{
-			Group:    "apps.shudong.com",
-			Version:  "v1",
-			Resource: "mydeployments",
-		}
 		err error
 	)
 
 	ginkgo.BeforeEach(func() {
 		// 2. 加载测试数据
-		err = f.LoadYamlToUnstructured(crFilePath, obj)
+		err = f.LoadYaml(crFilePath, obj)
 		gomega.Expect(err).Should(gomega.BeNil())
 		// 4. 初始化测试用到的全局变量
-		dynamicClient = ctx.CreateDynamicClient()
+		myDeploymentClient = ctx.CreateMyDeploymentClient()
 		clientSet = ctx.CreateClientSet()
 	})
 
 	ginkgo.Context("create mode ingress mydeployment", func() {
 		ginkgo.It("should be create mode ingress success", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Create(context.TODO(), obj, metav1.CreateOptions{})
+			_, err = myDeploymentClient.Create(context.TODO(), obj, metav1.CreateOptions{})
+			gomega.Expect(err).Should(gomega.BeNil())
+			err = ctx.WaitForChild(ctx.Namespace, "Ingress", obj.GetName(), true, framework.DefaultWaitTimeout)
 			gomega.Expect(err).Should(gomega.BeNil())
-			ginkgo.By("sleep 10 second wait creating done")
-			time.Sleep(10 * time.Second)
 		})
 		ginkgo.It("should be exist mydeployment", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err = myDeploymentClient.Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
 		})
 		ginkgo.It("should be exist deployment", func() {
-			_, err := clientSet.AppsV1().Deployments("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.AppsV1().Deployments(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
 		})
 		ginkgo.It("should be exist service", func() {
-			_, err := clientSet.CoreV1().Services("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.CoreV1().Services(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
 		})
 		ginkgo.It("should be exist ingress", func() {
-			_, err := clientSet.NetworkingV1().Ingresses("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.NetworkingV1().Ingresses(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
 		})
 	})
 
 	ginkgo.Context("delete mode ingress mydeployment", func() {
+		// 子资源由垃圾回收级联删除，envtest 等没有垃圾回收的集群跳过
+		ginkgo.BeforeEach(func() {
+			f.RequireGarbageCollector()
+		})
 		ginkgo.It("should be delete mode ingress success", func() {
-			err := dynamicClient.Resource(myGVR).Namespace("default").Delete(context.TODO(), obj.GetName(), metav1.DeleteOptions{})
+			err := myDeploymentClient.Delete(context.TODO(), obj.GetName(), metav1.DeleteOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
-			ginkgo.By("sleep 10 second wait deleting done")
-			time.Sleep(10 * time.Second)
+			// 等待 MyDeployment 及其子资源被删除
+			for _, kind := range []string{"MyDeployment", "Deployment", "Service", "Ingress"} {
+				err = ctx.WaitForChild(ctx.Namespace, kind, obj.GetName(), false, framework.DefaultWaitTimeout)
+				gomega.Expect(err).Should(gomega.BeNil())
+			}
 		})
 		ginkgo.It("should not be exist mydeployment", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err = myDeploymentClient.Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 		ginkgo.It("should not be exist deployment", func() {
-			_, err := clientSet.AppsV1().Deployments("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.AppsV1().Deployments(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 		ginkgo.It("should not be exist service", func() {
-			_, err := clientSet.CoreV1().Services("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.CoreV1().Services(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 		ginkgo.It("should not be exist ingress", func() {
-			_, err := clientSet.NetworkingV1().Ingresses("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.NetworkingV1().Ingresses(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 	})
@@ -95,83 +97,76 @@ func CreateIngressMyDeploymentDefaultValue(ctx *framework.TestContext, f *framew
 	var (
 		// 1. 准备测试数据
 		crNoReplicasFilePath    = "create/testdata/create-ingress-default-no-replicas.yaml"
-		noReplicasObj           = &unstructured.Unstructured{Object: make(map[string]interface{})}
+		noReplicasObj           = &myApiV1.MyDeployment{}
 		crNoServiceportFilePath = "create/testdata/create-ingress-default-no-serviceport.yaml"
-		noServiceportObj        = &unstructured.Unstructured{Object: make(map[string]interface{})}
-		dynamicClient           dynamic.Interface
+		noServiceportObj        = &myApiV1.MyDeployment{}
+		myDeploymentClient      myclientv1.MyDeploymentInterface
 		// 3. 准备测试用到的全局变量
-		myGVR = schema.GroupVersionResource{
-			Group:    "apps.shudong.com",
-			Version:  "v1",
-			Resource: "mydeployments",
-		}
 		err error
 	)
 
 	ginkgo.BeforeEach(func() {
 		// 2. 加载测试数据
-		err = f.LoadYamlToUnstructured(crNoReplicasFilePath, noReplicasObj)
+		err = f.LoadYaml(crNoReplicasFilePath, noReplicasObj)
 		gomega.Expect(err).Should(gomega.BeNil())
-		err = f.LoadYamlToUnstructured(crNoServiceportFilePath, noServiceportObj)
+		err = f.LoadYaml(crNoServiceportFilePath, noServiceportObj)
 		gomega.Expect(err).Should(gomega.BeNil())
 		// 4. 初始化测试用到的全局变量
-		dynamicClient = ctx.CreateDynamicClient()
+		myDeploymentClient = ctx.CreateMyDeploymentClient()
 	})
 
 	ginkgo.Context("create mode ingress mydeployment, but on replicas", func() {
 		ginkgo.It("should be create mode ingress but on replicas success", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Create(context.TODO(), noReplicasObj, metav1.CreateOptions{})
+			_, err = myDeploymentClient.Create(context.TODO(), noReplicasObj, metav1.CreateOptions{})
+			gomega.Expect(err).Should(gomega.BeNil())
+			err = ctx.WaitForChild(ctx.Namespace, "Deployment", noReplicasObj.GetName(), true, framework.DefaultWaitTimeout)
 			gomega.Expect(err).Should(gomega.BeNil())
-			ginkgo.By("sleep 10 second wait creating done")
-			time.Sleep(10 * time.Second)
 		})
 		ginkgo.It("should be exist mydeployment, and replicas eq 1", func() {
-			md, err := dynamicClient.Resource(myGVR).Namespace("default").Get(context.TODO(), noReplicasObj.GetName(), metav1.GetOptions{})
+			md, err := myDeploymentClient.Get(context.TODO(), noReplicasObj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
-			data := md.UnstructuredContent()
-			replicas, ok := data["spec"].(map[string]interface{})["replicas"].(int64)
-			gomega.Expect(ok).Should(gomega.BeTrue())
-			gomega.Expect(int(replicas)).Should(gomega.Equal(1))
+			gomega.Expect(int(md.Spec.Replicas)).Should(gomega.Equal(1))
 		})
 
 		ginkgo.It("should be delete mode ingress but on replicas success", func() {
-			err := dynamicClient.Resource(myGVR).Namespace("default").Delete(context.TODO(), noReplicasObj.GetName(), metav1.DeleteOptions{})
+			err := myDeploymentClient.Delete(context.TODO(), noReplicasObj.GetName(), metav1.DeleteOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
-			ginkgo.By("sleep 10 second wait deleting done")
-			time.Sleep(10 * time.Second)
+			// 等待 MyDeployment 及其子资源被删除
+			for _, kind := range []string{"MyDeployment"} {
+				err = ctx.WaitForChild(ctx.Namespace, kind, noReplicasObj.GetName(), false, framework.DefaultWaitTimeout)
+				gomega.Expect(err).Should(gomega.BeNil())
+			}
 		})
 		ginkgo.It("should not be exist mydeployment", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Get(context.TODO(), noReplicasObj.GetName(), metav1.GetOptions{})
+			_, err = myDeploymentClient.Get(context.TODO(), noReplicasObj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 	})
 
 	ginkgo.Context("create mode ingress mydeployment, but on serviceport", func() {
 		ginkgo.It("should be create mode ingress but on serviceport success", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Create(context.TODO(), noServiceportObj, metav1.CreateOptions{})
+			_, err = myDeploymentClient.Create(context.TODO(), noServiceportObj, metav1.CreateOptions{})
+			gomega.Expect(err).Should(gomega.BeNil())
+			err = ctx.WaitForChild(ctx.Namespace, "Deployment", noServiceportObj.GetName(), true, framework.DefaultWaitTimeout)
 			gomega.Expect(err).Should(gomega.BeNil())
-			ginkgo.By("sleep 10 second wait creating done")
-			time.Sleep(10 * time.Second)
 		})
 		ginkgo.It("should be exist mydeployment, and have a default serviceport", func() {
-			md, err := dynamicClient.Resource(myGVR).Namespace("default").Get(context.TODO(), noServiceportObj.GetName(), metav1.GetOptions{})
+			md, err := myDeploymentClient.Get(context.TODO(), noServiceportObj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
-			data := md.UnstructuredContent()
-			port, ok := data["spec"].(map[string]interface{})["port"].(int64)
-			gomega.Expect(ok).Should(gomega.BeTrue())
-			servicePort, ok := data["spec"].(map[string]interface{})["expose"].(map[string]interface{})["servicePort"].(int64)
-			gomega.Expect(ok).Should(gomega.BeTrue())
-			gomega.Expect(int(servicePort)).Should(gomega.Equal(int(port)))
+			gomega.Expect(md.Spec.Expose.ServicePort).Should(gomega.Equal(md.Spec.Port))
 		})
 
 		ginkgo.It("should be delete mode ingress but on serviceport success", func() {
-			err := dynamicClient.Resource(myGVR).Namespace("default").Delete(context.TODO(), noServiceportObj.GetName(), metav1.DeleteOptions{})
+			err := myDeploymentClient.Delete(context.TODO(), noServiceportObj.GetName(), metav1.DeleteOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
-			ginkgo.By("sleep 10 second wait deleting done")
-			time.Sleep(10 * time.Second)
+			// 等待 MyDeployment 及其子资源被删除
+			for _, kind := range []string{"MyDeployment"} {
+				err = ctx.WaitForChild(ctx.Namespace, kind, noServiceportObj.GetName(), false, framework.DefaultWaitTimeout)
+				gomega.Expect(err).Should(gomega.BeNil())
+			}
 		})
 		ginkgo.It("should not be exist mydeployment", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Get(context.TODO(), noServiceportObj.GetName(), metav1.GetOptions{})
+			_, err = myDeploymentClient.Get(context.TODO(), noServiceportObj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 	})
@@ -181,29 +176,24 @@ func CreateIngressMyDeploymentDefaultValue(ctx *framework.TestContext, f *framew
 func CreateIngressMyDeploymentMustFailed(ctx *framework.TestContext, f *framework.Framework) {
 	var (
 		// 1. 准备测试数据
-		crFilePath    = "create/testdata/create-ingress-error-no-domain.yaml"
-		obj           = &unstructured.Unstructured{Object: make(map[string]interface{})}
-		dynamicClient dynamic.Interface
+		crFilePath         = "create/testdata/create-ingress-error-no-domain.yaml"
+		obj                = &myApiV1.MyDeployment{}
+		myDeploymentClient myclientv1.MyDeploymentInterface
 		// 3. 准备测试用到的全局变量
-		myGVR = schema.GroupVersionResource{
-			Group:    "apps.shudong.com",
-			Version:  "v1",
-			Resource: "mydeployments",
-		}
 		err error
 	)
 
 	ginkgo.BeforeEach(func() {
 		// 2. 加载测试数据
-		err = f.LoadYamlToUnstructured(crFilePath, obj)
+		err = f.LoadYaml(crFilePath, obj)
 		gomega.Expect(err).Should(gomega.BeNil())
 		// 4. 初始化测试用到的全局变量
-		dynamicClient = ctx.CreateDynamicClient()
+		myDeploymentClient = ctx.CreateMyDeploymentClient()
 	})
 
 	ginkgo.Context("create mode ingress mydeployment, but error no domain", func() {
 		ginkgo.It("should be create mode ingress but on replicas failed", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Create(context.TODO(), obj, metav1.CreateOptions{})
+			_, err = myDeploymentClient.Create(context.TODO(), obj, metav1.CreateOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 	})
@@ -212,16 +202,12 @@ func CreateIngressMyDeploymentMustFailed(ctx *framework.TestContext, f *framewor
 func CreateIngressMyDeploymentWithTls(ctx *framework.TestContext, f *framework.Framework) {
 	var (
 		// 1. 准备测试数据
-		crFilePath    = "create/testdata/create-ingress-with-tls.yaml"
-		obj           = &unstructured.Unstructured{Object: make(map[string]interface{})}
-		dynamicClient dynamic.Interface
-		clientSet     *kubernetes.Clientset
+		crFilePath         = "create/testdata/create-ingress-with-tls.yaml"
+		obj                = &myApiV1.MyDeployment{}
+		myDeploymentClient myclientv1.MyDeploymentInterface
+		dynamicClient      dynamic.Interface
+		clientSet          *kubernetes.Clientset
 		// 3. 准备测试用到的全局变量
-		myGVR = schema.GroupVersionResource{
-			Group:    "apps.shudong.com",
-			Version:  "v1",
-			Resource: "mydeployments",
-		}
 		issuerGVR = schema.GroupVersionResource{
 			Group:    "cert-manager.io",
 			Version:  "v1",
@@ -238,61 +224,74 @@ func CreateIngressMyDeploymentWithTls(ctx *framework.TestContext, f *framework.F
 
 	ginkgo.BeforeEach(func() {
 		// 2. 加载测试数据
-		err = f.LoadYamlToUnstructured(crFilePath, obj)
+		err = f.LoadYaml(crFilePath, obj)
 		gomega.Expect(err).Should(gomega.BeNil())
 		// 4. 初始化测试用到的全局变量
+		myDeploymentClient = ctx.CreateMyDeploymentClient()
 		dynamicClient = ctx.CreateDynamicClient()
 		clientSet = ctx.CreateClientSet()
 	})
 
+	// Issuer 和 Certificate 由 cert-manager 提供，集群中没有安装时跳过
+	ginkgo.BeforeEach(func() {
+		f.RequireCRD("certificates.cert-manager.io")
+	})
+
 	ginkgo.Context("create mode ingress mydeployment with tls", func() {
 		ginkgo.It("should be create mode ingress with tls success", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Create(context.TODO(), obj, metav1.CreateOptions{})
+			_, err = myDeploymentClient.Create(context.TODO(), obj, metav1.CreateOptions{})
+			gomega.Expect(err).Should(gomega.BeNil())
+			err = ctx.WaitForChild(ctx.Namespace, "Certificate", obj.GetName(), true, framework.DefaultWaitTimeout)
 			gomega.Expect(err).Should(gomega.BeNil())
-			ginkgo.By("sleep 10 second wait creating done")
-			time.Sleep(10 * time.Second)
 		})
 		ginkgo.It("should be exist mydeployment", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err = myDeploymentClient.Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
 		})
 
 		ginkgo.It("should be exist ingress, and have a tls setting", func() {
-			ingress, err := clientSet.NetworkingV1().Ingresses("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			ingress, err := clientSet.NetworkingV1().Ingresses(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
 			gomega.Expect(len(ingress.Spec.TLS)).Should(gomega.Equal(1))
 		})
 		ginkgo.It("should be exist issuer", func() {
-			_, err = dynamicClient.Resource(issuerGVR).Namespace("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err = dynamicClient.Resource(issuerGVR).Namespace(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
 		})
 		ginkgo.It("should be exist certificate", func() {
-			_, err = dynamicClient.Resource(certificateGVR).Namespace("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err = dynamicClient.Resource(certificateGVR).Namespace(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
 		})
 	})
 
 	ginkgo.Context("delete mode ingress mydeployment with tls", func() {
+		// 子资源由垃圾回收级联删除，envtest 等没有垃圾回收的集群跳过
+		ginkgo.BeforeEach(func() {
+			f.RequireGarbageCollector()
+		})
 		ginkgo.It("should be delete mode ingress with tls success", func() {
-			err := dynamicClient.Resource(myGVR).Namespace("default").Delete(context.TODO(), obj.GetName(), metav1.DeleteOptions{})
+			err := myDeploymentClient.Delete(context.TODO(), obj.GetName(), metav1.DeleteOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
-			ginkgo.By("sleep 10 second wait deleting done")
-			time.Sleep(10 * time.Second)
+			// 等待 MyDeployment 及其子资源被删除
+			for _, kind := range []string{"MyDeployment", "Ingress", "Issuer", "Certificate"} {
+				err = ctx.WaitForChild(ctx.Namespace, kind, obj.GetName(), false, framework.DefaultWaitTimeout)
+				gomega.Expect(err).Should(gomega.BeNil())
+			}
 		})
 		ginkgo.It("should not be exist mydeployment", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err = myDeploymentClient.Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 		ginkgo.It("should not be exist ingress", func() {
-			_, err := clientSet.NetworkingV1().Ingresses("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.NetworkingV1().Ingresses(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 		ginkgo.It("should not be exist issuer", func() {
-			_, err = dynamicClient.Resource(issuerGVR).Namespace("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err = dynamicClient.Resource(issuerGVR).Namespace(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 		ginkgo.It("should not be exist certificate", func() {
-			_, err = dynamicClient.Resource(certificateGVR).Namespace("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err = dynamicClient.Resource(certificateGVR).Namespace(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 	})
@@ -2,88 +2,88 @@ package create
 
 import (
 	"context"
+	myApiV1 "deployment/api/v1"
+	myclientv1 "deployment/pkg/client/clientset/versioned/typed/apps/v1"
 	"deployment/test/framework"
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"time"
 )
 
 // 真正的测试，测试创建 nodeport 模式
 func CreateNodePortMyDeployment(ctx *framework.TestContext, f *framework.Framework) {
 	var (
 		// 1. 准备测试数据
-		crFilePath    = "create/testdata/create-nodeport.yaml"
-		obj           = &unstructured.Unstructured{Object: make(map[string]interface{})}
-		dynamicClient dynamic.Interface
-		clientSet     *kubernetes.Clientset
+		crFilePath         = "create/testdata/create-nodeport.yaml"
+		obj                = &myApiV1.MyDeployment{}
+		myDeploymentClient myclientv1.MyDeploymentInterface
+		clientSet          *kubernetes.Clientset
 		// 3. 准备测试用到的全局变量
-		myGVR = schema.GroupVersionResource{
-			Group:    "apps.shudong.com",
-			Version:  "v1",
-			Resource: "mydeployments",
-		}
 		err error
 	)
 
 	ginkgo.BeforeEach(func() {
 		// 2. 加载测试数据
-		err = f.LoadYamlToUnstructured(crFilePath, obj)
+		err = f.LoadYaml(crFilePath, obj)
 		gomega.Expect(err).Should(gomega.BeNil())
 		// 4. 初始化测试用到的全局变量
-		dynamicClient = ctx.CreateDynamicClient()
+		myDeploymentClient = ctx.CreateMyDeploymentClient()
 		clientSet = ctx.CreateClientSet()
 	})
 	ginkgo.Context("create mode nodeport mydeployment", func() {
 		ginkgo.It("should be create mode nodeport success", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Create(context.TODO(), obj, metav1.CreateOptions{})
+			_, err = myDeploymentClient.Create(context.TODO(), obj, metav1.CreateOptions{})
+			gomega.Expect(err).Should(gomega.BeNil())
+			err = ctx.WaitForCondition(ctx.Namespace, obj.GetName(), myApiV1.ConditionTypeService, myApiV1.ConditionStatusTrue, framework.DefaultWaitTimeout)
 			gomega.Expect(err).Should(gomega.BeNil())
-			ginkgo.By("sleep 10 second wait creating done")
-			time.Sleep(10 * time.Second)
 		})
 		ginkgo.It("should be exist mydeployment", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err = myDeploymentClient.Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
 		})
 		ginkgo.It("should be exist deployment", func() {
-			_, err := clientSet.AppsV1().Deployments("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.AppsV1().Deployments(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
 		})
 		ginkgo.It("should be exist service", func() {
-			_, err := clientSet.CoreV1().Services("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.CoreV1().Services(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
 		})
 		ginkgo.It("should not be exist ingress", func() {
-			_, err := clientSet.NetworkingV1().Ingresses("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.NetworkingV1().Ingresses(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 	})
 
 	ginkgo.Context("delete mode nodeport mydeployment", func() {
+		// 子资源由垃圾回收级联删除，envtest 等没有垃圾回收的集群跳过
+		ginkgo.BeforeEach(func() {
+			f.RequireGarbageCollector()
+		})
 		ginkgo.It("should be delete mode nodeport success", func() {
-			err := dynamicClient.Resource(myGVR).Namespace("default").Delete(context.TODO(), obj.GetName(), metav1.DeleteOptions{})
+			err := myDeploymentClient.Delete(context.TODO(), obj.GetName(), metav1.DeleteOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
-			ginkgo.By("sleep 10 second wait deleting done")
-			time.Sleep(10 * time.Second)
+			// 等待 MyDeployment 及其子资源被删除
+			for _, kind := range []string{"MyDeployment", "Deployment", "Service"} {
+				err = ctx.WaitForChild(ctx.Namespace, kind, obj.GetName(), false, framework.DefaultWaitTimeout)
+				gomega.Expect(err).Should(gomega.BeNil())
+			}
 		})
 		ginkgo.It("should not be exist mydeployment", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err = myDeploymentClient.Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 		ginkgo.It("should not be exist deployment", func() {
-			_, err := clientSet.AppsV1().Deployments("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.AppsV1().Deployments(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 		ginkgo.It("should not be exist service", func() {
-			_, err := clientSet.CoreV1().Services("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.CoreV1().Services(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 		ginkgo.It("should not be exist ingress", func() {
-			_, err := clientSet.NetworkingV1().Ingresses("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.NetworkingV1().Ingresses(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 	})
@@ -93,51 +93,46 @@ func CreateNodePortMyDeploymentMustFailed(ctx *framework.TestContext, f *framewo
 	var (
 		// 1. 准备测试数据
 		crGt32767FilePath    = "create/testdata/create-nodeport-error-gt-32767.yaml"
-		gt32767obj           = &unstructured.Unstructured{Object: make(map[string]interface{})}
+		gt32767obj           = &myApiV1.MyDeployment{}
 		crLt30000FilePath    = "create/testdata/create-nodeport-error-lt-30000.yaml"
-		lt30000obj           = &unstructured.Unstructured{Object: make(map[string]interface{})}
+		lt30000obj           = &myApiV1.MyDeployment{}
 		crNoNodePortFilePath = "create/testdata/create-nodeport-error-no-nodeport.yaml"
-		noNodePortobj        = &unstructured.Unstructured{Object: make(map[string]interface{})}
+		noNodePortobj        = &myApiV1.MyDeployment{}
 
-		dynamicClient dynamic.Interface
+		myDeploymentClient myclientv1.MyDeploymentInterface
 		// 3. 准备测试用到的全局变量
-		myGVR = schema.GroupVersionResource{
-			Group:    "apps.shudong.com",
-			Version:  "v1",
-			Resource: "mydeployments",
-		}
 		err error
 	)
 
 	ginkgo.BeforeEach(func() {
 		// 2. 加载测试数据
-		err = f.LoadYamlToUnstructured(crGt32767FilePath, gt32767obj)
+		err = f.LoadYaml(crGt32767FilePath, gt32767obj)
 		gomega.Expect(err).Should(gomega.BeNil())
-		err = f.LoadYamlToUnstructured(crLt30000FilePath, lt30000obj)
+		err = f.LoadYaml(crLt30000FilePath, lt30000obj)
 		gomega.Expect(err).Should(gomega.BeNil())
-		err = f.LoadYamlToUnstructured(crNoNodePortFilePath, noNodePortobj)
+		err = f.LoadYaml(crNoNodePortFilePath, noNodePortobj)
 		gomega.Expect(err).Should(gomega.BeNil())
 		// 4. 初始化测试用到的全局变量
-		dynamicClient = ctx.CreateDynamicClient()
+		myDeploymentClient = ctx.CreateMyDeploymentClient()
 	})
 
 	ginkgo.Context("create mode nodeport mydeployment, but nodeport gt 32767", func() {
 		ginkgo.It("should be create mode ingress but nodeport gt 32767 failed", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Create(context.TODO(), gt32767obj, metav1.CreateOptions{})
+			_, err = myDeploymentClient.Create(context.TODO(), gt32767obj, metav1.CreateOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 	})
 
 	ginkgo.Context("create mode nodeport mydeployment, but nodeport lt 30000", func() {
 		ginkgo.It("should be create mode ingress but nodeport lt 30000 failed", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Create(context.TODO(), lt30000obj, metav1.CreateOptions{})
+			_, err = myDeploymentClient.Create(context.TODO(), lt30000obj, metav1.CreateOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 	})
 
 	ginkgo.Context("create mode nodeport mydeployment, but no nodeport", func() {
 		ginkgo.It("should be create mode ingress but no nodeport", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Create(context.TODO(), noNodePortobj, metav1.CreateOptions{})
+			_, err = myDeploymentClient.Create(context.TODO(), noNodePortobj, metav1.CreateOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 	})
@@ -2,15 +2,13 @@ package update
 
 import (
 	"context"
+	myApiV1 "deployment/api/v1"
+	myclientv1 "deployment/pkg/client/clientset/versioned/typed/apps/v1"
 	"deployment/test/framework"
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"time"
 )
 
 // 真正的测试函数，测试从 ingress 模式更新为 nodePort 模式
@@ -19,91 +17,93 @@ func UpdateI2NMyDeployment(ctx *framework.TestContext, f *framework.Framework) {
 		// 1. 准备测试数据
 		crFilePath       = "update/testdata/update-ingress.yaml"
 		crUpdateFilePath = "update/testdata/update-i2n.yaml"
-		obj              = &unstructured.Unstructured{Object: make(map[string]interface{})}
-		updateObj        = &unstructured.Unstructured{Object: make(map[string]interface{})}
+		obj              = &myApiV1.MyDeployment{}
+		updateObj        = &myApiV1.MyDeployment{}
 
-		dynamicClient dynamic.Interface
-		clientSet     *kubernetes.Clientset
+		myDeploymentClient myclientv1.MyDeploymentInterface
+		clientSet          *kubernetes.Clientset
 		// 3. 准备测试用到的全局变量
-		myGVR = schema.GroupVersionResource{
-			Group:    "apps.shudong.com",
-			Version:  "v1",
-			Resource: "mydeployments",
-		}
 		err error
 	)
 
 	ginkgo.BeforeEach(func() {
 		// 2. 加载测试数据
-		err = f.LoadYamlToUnstructured(crFilePath, obj)
+		err = f.LoadYaml(crFilePath, obj)
 		gomega.Expect(err).Should(gomega.BeNil())
-		err = f.LoadYamlToUnstructured(crUpdateFilePath, updateObj)
+		err = f.LoadYaml(crUpdateFilePath, updateObj)
 		gomega.Expect(err).Should(gomega.BeNil())
 		// 4. 初始化测试用到的全局变量
-		dynamicClient = ctx.CreateDynamicClient()
+		myDeploymentClient = ctx.CreateMyDeploymentClient()
 		clientSet = ctx.CreateClientSet()
 	})
 	ginkgo.Context("update mode ingress to mode nodeport mydeployment", func() {
 		ginkgo.It("should be create mode ingress success", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Create(context.TODO(), obj, metav1.CreateOptions{})
+			_, err = myDeploymentClient.Create(context.TODO(), obj, metav1.CreateOptions{})
+			gomega.Expect(err).Should(gomega.BeNil())
+			err = ctx.WaitForChild(ctx.Namespace, "Ingress", obj.GetName(), true, framework.DefaultWaitTimeout)
 			gomega.Expect(err).Should(gomega.BeNil())
-			ginkgo.By("sleep 10 second wait creating done")
-			time.Sleep(10 * time.Second)
 		})
 		ginkgo.It("should be exist mydeployment", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err = myDeploymentClient.Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
 		})
 		ginkgo.It("should be exist deployment", func() {
-			_, err := clientSet.AppsV1().Deployments("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.AppsV1().Deployments(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
 		})
 		ginkgo.It("should be exist service", func() {
-			_, err := clientSet.CoreV1().Services("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.CoreV1().Services(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
 		})
 		ginkgo.It("should be exist ingress", func() {
-			_, err := clientSet.NetworkingV1().Ingresses("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.NetworkingV1().Ingresses(ctx.Namespace).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
 		})
 
 		ginkgo.It("should be update to mode nodeport success", func() {
-			myDeployment, err := dynamicClient.Resource(myGVR).Namespace("default").Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+			myDeployment, err := myDeploymentClient.Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
 
 			updateObj.SetResourceVersion(myDeployment.GetResourceVersion())
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Update(context.TODO(), updateObj, metav1.UpdateOptions{})
+			_, err = myDeploymentClient.Update(context.TODO(), updateObj, metav1.UpdateOptions{})
+			gomega.Expect(err).Should(gomega.BeNil())
+			err = ctx.WaitForChild(ctx.Namespace, "Ingress", updateObj.GetName(), false, framework.DefaultWaitTimeout)
 			gomega.Expect(err).Should(gomega.BeNil())
-			ginkgo.By("sleep 10 second wait deleting done")
-			time.Sleep(20 * time.Second)
 		})
 		ginkgo.It("should not be exist ingress", func() {
-			_, err := clientSet.NetworkingV1().Ingresses("default").Get(context.TODO(), updateObj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.NetworkingV1().Ingresses(ctx.Namespace).Get(context.TODO(), updateObj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 	})
 
 	ginkgo.Context("delete mode i2n mydeployment", func() {
+		// 子资源由垃圾回收级联删除，envtest 等没有垃圾回收的集群跳过
+		ginkgo.BeforeEach(func() {
+			f.RequireGarbageCollector()
+		})
 		ginkgo.It("should be delete mode i2n success", func() {
-			err := dynamicClient.Resource(myGVR).Namespace("default").Delete(context.TODO(), updateObj.GetName(), metav1.DeleteOptions{})
+			err := myDeploymentClient.Delete(context.TODO(), updateObj.GetName(), metav1.DeleteOptions{})
 			gomega.Expect(err).Should(gomega.BeNil())
-			ginkgo.By("sleep 10 second wait deleting done")
-			time.Sleep(10 * time.Second)
+			// 等待 MyDeployment 及其子资源被删除
+			for _, kind := range []string{"MyDeployment", "Deployment", "Service", "Ingress"} {
+				err = ctx.WaitForChild(ctx.Namespace, kind, updateObj.GetName(), false, framework.DefaultWaitTimeout)
+				gomega.Expect(err).Should(gomega.BeNil())
+			}
 		})
 		ginkgo.It("should not be exist mydeployment", func() {
-			_, err = dynamicClient.Resource(myGVR).Namespace("default").Get(context.TODO(), updateObj.GetName(), metav1.GetOptions{})
+			_, err = myDeploymentClient.Get(context.TODO(), updateObj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 		ginkgo.It("should not be exist deployment", func() {
-			_, err := clientSet.AppsV1().Deployments("default").Get(context.TODO(), updateObj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.AppsV1().Deployments(ctx.Namespace).Get(context.TODO(), updateObj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 		ginkgo.It("should not be exist service", func() {
-			_, err := clientSet.CoreV1().Services("default").Get(context.TODO(), updateObj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.CoreV1().Services(ctx.Namespace).Get(context.TODO(), updateObj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 		ginkgo.It("should not be exist ingress", func() {
-			_, err := clientSet.NetworkingV1().Ingresses("default").Get(context.TODO(), updateObj.GetName(), metav1.GetOptions{})
+			_, err := clientSet.NetworkingV1().Ingresses(ctx.Namespace).Get(context.TODO(), updateObj.GetName(), metav1.GetOptions{})
 			gomega.Expect(err).ShouldNot(gomega.BeNil())
 		})
 	})
@@ -0,0 +1,403 @@
+package framework
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/wait"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sort"
+	"strings"
+	"time"
+)
+
+// apply 等待资源就绪的默认超时时间，安装 cert-manager 这类依赖时需要拉取镜像，比 DefaultWaitTimeout 长
+const DefaultApplyWaitTimeout = 5 * time.Minute
+
+var (
+	crdGVR        = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+	deploymentGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+)
+
+// Apply 通过 server-side apply 安装 yaml，不需要 kubectl
+type Apply struct {
+	// yaml 文件、目录或者 URL，相对路径基于步骤的 path
+	// 目录中有 kustomization.yaml 时使用 kustomize 渲染，否则读取目录下所有的 yaml 文件
+	// URL 支持 file://、http:// 和 https://
+	Files []string `json:"files"`
+	// 替换 Deployment 中容器的镜像，格式为 name=newImage，和 kustomize edit set image 相同
+	Images []string `json:"images"`
+	// 是否等待 CRD 可以使用、Deployment 可用，默认为 true
+	Wait *bool `json:"wait"`
+	// 等待的超时时间，默认 DefaultApplyWaitTimeout
+	WaitTimeout time.Duration `json:"waitTimeout"`
+}
+
+func (a *Apply) validate(root *field.Path) field.ErrorList {
+	errs := field.ErrorList{}
+	if len(a.Files) == 0 {
+		errs = append(errs, field.Invalid(root.Child("files"), a.Files, "files is required"))
+	}
+	for index, image := range a.Images {
+		if name, newImage, ok := strings.Cut(image, "="); !ok || name == "" || newImage == "" {
+			errs = append(errs, field.Invalid(root.Child("images").Index(index), image, "image must be name=newImage"))
+		}
+	}
+	if a.WaitTimeout < 0 {
+		errs = append(errs, field.Invalid(root.Child("waitTimeout"), a.WaitTimeout.String(), "waitTimeout must not be negative"))
+	}
+	if a.Wait == nil {
+		enabled := true
+		a.Wait = &enabled
+	}
+	if a.WaitTimeout == 0 {
+		a.WaitTimeout = DefaultApplyWaitTimeout
+	}
+	return errs
+}
+
+// 加载、apply 所有的对象，然后等待就绪
+func (a *Apply) apply(ctx context.Context, clusterConfig *ClusterConfig, dir string, out io.Writer) error {
+	// 1. 加载对象
+	if clusterConfig == nil || clusterConfig.Rest == nil {
+		return errors.New("cluster config is required to apply")
+	}
+	objs := make([]*unstructured.Unstructured, 0)
+	for _, file := range a.Files {
+		items, err := loadObjects(dir, file)
+		if err != nil {
+			return fmt.Errorf("load %s: %w", file, err)
+		}
+		objs = append(objs, items...)
+	}
+	if err := setImages(objs, a.Images); err != nil {
+		return err
+	}
+	// 2. 创建 client，CRD 安装后需要重新发现资源
+	client, err := dynamic.NewForConfig(clusterConfig.Rest)
+	if err != nil {
+		return err
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(clusterConfig.Rest)
+	if err != nil {
+		return err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+	// 3. 先 apply namespace 和 CRD，其他对象可能依赖它们
+	first, rest := splitObjects(objs)
+	if err := applyObjects(ctx, client, mapper, first, out); err != nil {
+		return err
+	}
+	if *a.Wait {
+		for _, obj := range first {
+			if obj.GetKind() == "CustomResourceDefinition" {
+				if err := a.waitFor(ctx, client, crdGVR, obj, crdEstablished); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	mapper.Reset()
+	// 4. apply 其他对象
+	if err := applyObjects(ctx, client, mapper, rest, out); err != nil {
+		return err
+	}
+	// 5. 等待 Deployment 可用
+	if *a.Wait {
+		for _, obj := range rest {
+			if obj.GroupVersionKind().GroupKind() == (schema.GroupKind{Group: "apps", Kind: "Deployment"}) {
+				if err := a.waitFor(ctx, client, deploymentGVR, obj, deploymentAvailable); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// 轮询对象，直到 ready 返回 true
+func (a *Apply) waitFor(ctx context.Context, client dynamic.Interface, gvr schema.GroupVersionResource,
+	obj *unstructured.Unstructured, ready func(obj *unstructured.Unstructured) bool) error {
+	var last *unstructured.Unstructured
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, a.WaitTimeout, true, func(ctx context.Context) (bool, error) {
+		current, err := client.Resource(gvr).Namespace(obj.GetNamespace()).Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if err != nil {
+			// 对象刚创建时可能还无法获取，继续等待
+			return false, nil
+		}
+		last = current
+		return ready(current), nil
+	})
+	if err != nil {
+		return fmt.Errorf("wait for %s %s failed: %w, last observed: %s",
+			gvr.Resource, objectKey(obj), err, observedStatus(last))
+	}
+	return nil
+}
+
+func applyObjects(ctx context.Context, client dynamic.Interface, mapper meta.RESTMapper,
+	objs []*unstructured.Unstructured, out io.Writer) error {
+	for _, obj := range objs {
+		gvk := obj.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", gvk.Kind, objectKey(obj), err)
+		}
+		var resource dynamic.ResourceInterface = client.Resource(mapping.Resource)
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			if obj.GetNamespace() == "" {
+				obj.SetNamespace(metav1.NamespaceDefault)
+			}
+			resource = client.Resource(mapping.Resource).Namespace(obj.GetNamespace())
+		}
+		_, err = resource.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{FieldManager: FieldManager, Force: true})
+		if err != nil {
+			return fmt.Errorf("apply %s %s: %w", gvk.Kind, objectKey(obj), err)
+		}
+		_, _ = fmt.Fprintf(out, "%s/%s serverside-applied\n", strings.ToLower(gvk.Kind), objectKey(obj))
+	}
+	return nil
+}
+
+// 把 namespace 和 CRD 放在前面
+func splitObjects(objs []*unstructured.Unstructured) (first, rest []*unstructured.Unstructured) {
+	for _, obj := range objs {
+		switch obj.GroupVersionKind().GroupKind() {
+		case schema.GroupKind{Kind: "Namespace"}, schema.GroupKind{Group: crdGVR.Group, Kind: "CustomResourceDefinition"}:
+			first = append(first, obj)
+		default:
+			rest = append(rest, obj)
+		}
+	}
+	return first, rest
+}
+
+// 根据路径的类型加载对象
+func loadObjects(dir, file string) ([]*unstructured.Unstructured, error) {
+	// 1. URL
+	if u, err := url.Parse(file); err == nil && u.Scheme != "" {
+		switch u.Scheme {
+		case "file":
+			file = u.Path
+		case "http", "https":
+			return loadURL(file)
+		default:
+			return nil, fmt.Errorf("unsupported scheme %s", u.Scheme)
+		}
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	// 2. 文件
+	if !info.IsDir() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return decodeObjects(data)
+	}
+	// 3. kustomize 目录
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		if _, err := os.Stat(filepath.Join(file, name)); err == nil {
+			return kustomizeBuild(file)
+		}
+	}
+	// 4. 普通目录，按文件名排序读取 yaml 文件
+	entries, err := os.ReadDir(file)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if !entry.IsDir() && (ext == ".yaml" || ext == ".yml" || ext == ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	objs := make([]*unstructured.Unstructured, 0)
+	for _, name := range names {
+		items, err := loadObjects(file, name)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, items...)
+	}
+	return objs, nil
+}
+
+func loadURL(rawURL string) ([]*unstructured.Unstructured, error) {
+	resp, err := http.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %s: %s", rawURL, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeObjects(data)
+}
+
+// 和 kustomize build 相同
+func kustomizeBuild(dir string) ([]*unstructured.Unstructured, error) {
+	resMap, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(filesys.MakeFsOnDisk(), dir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := resMap.AsYaml()
+	if err != nil {
+		return nil, err
+	}
+	return decodeObjects(data)
+}
+
+// 解析多个文档的 yaml 或者 json，展开 List
+func decodeObjects(data []byte) ([]*unstructured.Unstructured, error) {
+	objs := make([]*unstructured.Unstructured, 0)
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objs, nil
+			}
+			return nil, err
+		}
+		// 空的文档
+		if len(obj.Object) == 0 {
+			continue
+		}
+		if obj.IsList() {
+			list, err := obj.ToList()
+			if err != nil {
+				return nil, err
+			}
+			for index := range list.Items {
+				objs = append(objs, &list.Items[index])
+			}
+			continue
+		}
+		if obj.GetKind() == "" || obj.GetName() == "" {
+			return nil, fmt.Errorf("kind and metadata.name are required: %v", obj.Object)
+		}
+		objs = append(objs, obj)
+	}
+}
+
+// 替换 Deployment 中容器的镜像，name 不包含 tag 和 digest
+func setImages(objs []*unstructured.Unstructured, images []string) error {
+	if len(images) == 0 {
+		return nil
+	}
+	replace := make(map[string]string, len(images))
+	for _, image := range images {
+		name, newImage, _ := strings.Cut(image, "=")
+		replace[name] = newImage
+	}
+	for _, obj := range objs {
+		if obj.GetKind() != "Deployment" {
+			continue
+		}
+		for _, key := range []string{"containers", "initContainers"} {
+			path := []string{"spec", "template", "spec", key}
+			containers, found, err := unstructured.NestedSlice(obj.Object, path...)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			for _, item := range containers {
+				container, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				image, _ := container["image"].(string)
+				if newImage, ok := replace[imageName(image)]; ok {
+					container["image"] = newImage
+				}
+			}
+			if err := unstructured.SetNestedSlice(obj.Object, containers, path...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 去掉镜像的 tag 和 digest
+func imageName(image string) string {
+	if index := strings.Index(image, "@"); index >= 0 {
+		image = image[:index]
+	}
+	// registry 中可能包含端口，只有最后一个 / 之后的 : 是 tag
+	if index := strings.LastIndex(image, ":"); index > strings.LastIndex(image, "/") {
+		image = image[:index]
+	}
+	return image
+}
+
+func crdEstablished(obj *unstructured.Unstructured) bool {
+	return conditionTrue(obj, "Established")
+}
+
+// 和 kubectl rollout status 相同，新的 ReplicaSet 全部更新并且可用
+func deploymentAvailable(obj *unstructured.Unstructured) bool {
+	generation := obj.GetGeneration()
+	observedGeneration, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if observedGeneration < generation {
+		return false
+	}
+	replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !found {
+		replicas = 1
+	}
+	updated, _, _ := unstructured.NestedInt64(obj.Object, "status", "updatedReplicas")
+	available, _, _ := unstructured.NestedInt64(obj.Object, "status", "availableReplicas")
+	total, _, _ := unstructured.NestedInt64(obj.Object, "status", "replicas")
+	return updated == replicas && available == replicas && total == replicas && conditionTrue(obj, "Available")
+}
+
+func conditionTrue(obj *unstructured.Unstructured, conditionType string) bool {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, item := range conditions {
+		condition, ok := item.(map[string]interface{})
+		if ok && condition["type"] == conditionType {
+			return condition["status"] == string(metav1.ConditionTrue)
+		}
+	}
+	return false
+}
+
+func objectKey(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
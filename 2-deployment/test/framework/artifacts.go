@@ -0,0 +1,170 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/spf13/viper"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+var artifactsNameRegex = regexp.MustCompile("[^a-z0-9-]+")
+
+// 收集 namespace 中的事件
+var eventGVR = schema.GroupVersionResource{Version: "v1", Resource: "events"}
+
+type ArtifactsConfig struct {
+	// 保存测试结果的目录，相对路径基于执行测试的目录，每个失败的测试任务使用一个子目录
+	Dir string `json:"dir"`
+	// junit 报告的文件名，保存在 dir 中，为空时不生成
+	Junit string `json:"junit"`
+	// 是否在测试失败时收集集群中的对象和日志
+	Collect *bool `json:"collect"`
+	// operator 所在的 namespace
+	OperatorNamespace string `json:"operatorNamespace"`
+	// 选择 operator pod 的标签
+	OperatorLabelSelector string `json:"operatorLabelSelector"`
+}
+
+// 读取 artifacts 配置，没有配置时使用默认值
+func getArtifactsConfig(config *viper.Viper) (ArtifactsConfig, error) {
+	artifactsConfig := ArtifactsConfig{}
+	if config != nil {
+		if err := config.Unmarshal(&artifactsConfig); err != nil {
+			return artifactsConfig, err
+		}
+	}
+	if artifactsConfig.Dir == "" {
+		artifactsConfig.Dir = "_artifacts"
+	}
+	if artifactsConfig.Collect == nil {
+		collect := true
+		artifactsConfig.Collect = &collect
+	}
+	if artifactsConfig.OperatorNamespace == "" {
+		artifactsConfig.OperatorNamespace = "deployment-system"
+	}
+	if artifactsConfig.OperatorLabelSelector == "" {
+		artifactsConfig.OperatorLabelSelector = "control-plane=controller-manager"
+	}
+	return artifactsConfig, nil
+}
+
+// 测试任务的目录，把任务的完整名字转换为合法的目录名
+func (a ArtifactsConfig) specDir(report ginkgo.SpecReport) string {
+	name := strings.Trim(artifactsNameRegex.ReplaceAllString(strings.ToLower(report.FullText()), "-"), "-")
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	if name == "" {
+		name = "spec"
+	}
+	return filepath.Join(a.Dir, name)
+}
+
+// 测试失败时把集群中的状态保存到文件中，方便定位问题
+type artifactsCollector struct {
+	config    ArtifactsConfig
+	client    kubernetes.Interface
+	dynamic   dynamic.Interface
+	namespace string
+	dir       string
+	errs      []error
+}
+
+// 收集 namespace 中的 MyDeployment 及子资源、事件、pod 日志，以及 operator 的日志
+func (a *artifactsCollector) collect() error {
+	// 1. 创建目录
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		return err
+	}
+	// 2. MyDeployment 和子资源，集群中没有安装的资源，比如 cert-manager，忽略错误
+	kinds := make([]string, 0, len(childGVRs))
+	for kind := range childGVRs {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		a.dumpList(strings.ToLower(kind)+".yaml", childGVRs[kind], a.namespace)
+	}
+	// 3. 事件
+	a.dumpList("events.yaml", eventGVR, a.namespace)
+	// 4. 测试 namespace 中的 pod 日志
+	a.dumpLogs("pods", a.namespace, "")
+	// 5. operator 的日志，envtest 中 operator 在测试进程中运行，没有 pod
+	a.dumpLogs("operator", a.config.OperatorNamespace, a.config.OperatorLabelSelector)
+	return utilerrors.NewAggregate(a.errs)
+}
+
+// 把 namespace 中的 gvr 对象保存为 yaml
+func (a *artifactsCollector) dumpList(file string, gvr schema.GroupVersionResource, namespace string) {
+	list, err := a.dynamic.Resource(gvr).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		a.write(file+".error", []byte(err.Error()))
+		return
+	}
+	if len(list.Items) == 0 {
+		return
+	}
+	data, err := yaml.Marshal(list)
+	if err != nil {
+		a.errs = append(a.errs, err)
+		return
+	}
+	a.write(file, data)
+}
+
+// 保存 pod 中每个容器的日志，容器重启过时同时保存上一次的日志
+func (a *artifactsCollector) dumpLogs(dir, namespace, labelSelector string) {
+	pods, err := a.client.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		a.write(dir+".error", []byte(err.Error()))
+		return
+	}
+	for _, pod := range pods.Items {
+		statuses := make([]corev1.ContainerStatus, 0, len(pod.Status.InitContainerStatuses)+len(pod.Status.ContainerStatuses))
+		statuses = append(statuses, pod.Status.InitContainerStatuses...)
+		statuses = append(statuses, pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			file := filepath.Join(dir, pod.Name+"-"+status.Name+".log")
+			a.dumpLog(file, namespace, pod.Name, status.Name, false)
+			if status.RestartCount > 0 {
+				file = filepath.Join(dir, pod.Name+"-"+status.Name+".previous.log")
+				a.dumpLog(file, namespace, pod.Name, status.Name, true)
+			}
+		}
+	}
+}
+
+func (a *artifactsCollector) dumpLog(file, namespace, pod, container string, previous bool) {
+	data, err := a.client.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{
+		Container: container,
+		Previous:  previous,
+	}).DoRaw(context.TODO())
+	if err != nil {
+		data = []byte(err.Error())
+	}
+	a.write(file, data)
+}
+
+func (a *artifactsCollector) write(file string, data []byte) {
+	path := filepath.Join(a.dir, file)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		a.errs = append(a.errs, err)
+		return
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		a.errs = append(a.errs, fmt.Errorf("write %s: %w", path, err))
+	}
+}
@@ -1,12 +1,16 @@
 package framework
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/spf13/viper"
 	"io"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"os"
 	"path/filepath"
 )
@@ -76,4 +80,80 @@ type ClusterConfig struct {
 	Rest *rest.Config `json:"-"`
 	// 集群的 master ip，在一些需要直接和集群通讯测试的时候使用
 	MasterIP string
+	// kubectl 已经可以使用的 context，不为空时直接切换到这个 context，不需要根据 Rest 创建新的 context
+	Context string
+	// 集群是否运行垃圾回收，envtest 只有 etcd 和 kube-apiserver，删除 owner 后子资源不会被级联删除
+	GarbageCollector bool
+}
+
+// 在 ginkgo 的并行进程之间传递的 ClusterConfig，Rest 转换为 kubeconfig
+type clusterConfigData struct {
+	Name       string `json:"name"`
+	MasterIP   string `json:"masterIP"`
+	Context    string `json:"context"`
+	Kubeconfig []byte `json:"kubeconfig"`
+
+	GarbageCollector bool `json:"garbageCollector"`
+}
+
+// Marshal 序列化 ClusterConfig，只有第一个进程创建集群，其他进程通过反序列化得到访问集群的配置
+func (c *ClusterConfig) Marshal() ([]byte, error) {
+	if c.Rest == nil {
+		return nil, field.Invalid(field.NewPath("clusterConfig", "rest"), nil, "rest config is required")
+	}
+	// 1. 把 rest.Config 转换为只有一个 context 的 kubeconfig
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters[c.Name] = &clientcmdapi.Cluster{
+		Server:                   c.Rest.Host,
+		TLSServerName:            c.Rest.ServerName,
+		InsecureSkipTLSVerify:    c.Rest.Insecure,
+		CertificateAuthority:     c.Rest.CAFile,
+		CertificateAuthorityData: c.Rest.CAData,
+	}
+	kubeconfig.AuthInfos[c.Name] = &clientcmdapi.AuthInfo{
+		ClientCertificate:     c.Rest.CertFile,
+		ClientCertificateData: c.Rest.CertData,
+		ClientKey:             c.Rest.KeyFile,
+		ClientKeyData:         c.Rest.KeyData,
+		Token:                 c.Rest.BearerToken,
+		TokenFile:             c.Rest.BearerTokenFile,
+		Username:              c.Rest.Username,
+		Password:              c.Rest.Password,
+		Exec:                  c.Rest.ExecProvider,
+		AuthProvider:          c.Rest.AuthProvider,
+	}
+	kubeconfig.Contexts[c.Name] = &clientcmdapi.Context{Cluster: c.Name, AuthInfo: c.Name}
+	kubeconfig.CurrentContext = c.Name
+	data, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	// 2. 序列化
+	return json.Marshal(clusterConfigData{
+		Name:       c.Name,
+		MasterIP:   c.MasterIP,
+		Context:    c.Context,
+		Kubeconfig: data,
+
+		GarbageCollector: c.GarbageCollector,
+	})
+}
+
+// UnmarshalClusterConfig 反序列化 ClusterConfig.Marshal 的结果
+func UnmarshalClusterConfig(data []byte) (ClusterConfig, error) {
+	clusterConfig := ClusterConfig{}
+	configData := clusterConfigData{}
+	if err := json.Unmarshal(data, &configData); err != nil {
+		return clusterConfig, err
+	}
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(configData.Kubeconfig)
+	if err != nil {
+		return clusterConfig, err
+	}
+	clusterConfig.Name = configData.Name
+	clusterConfig.MasterIP = configData.MasterIP
+	clusterConfig.Context = configData.Context
+	clusterConfig.GarbageCollector = configData.GarbageCollector
+	clusterConfig.Rest = restConfig
+	return clusterConfig, nil
 }
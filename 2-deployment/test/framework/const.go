@@ -1,10 +1,21 @@
 package framework
 
+import "time"
+
+// 等待 MyDeployment 和子资源达到期望状态的默认超时时间
+const DefaultWaitTimeout = 2 * time.Minute
+
 const (
 	KubeconfigTempFile = "/tmp/kind.kubeconfig"
 	KindConfigTempFile = "/tmp/kind-config.yaml"
 )
 
+// 框架创建的 namespace 上的标签，用来清理测试失败时没有删除的 namespace
+const LabelKeyTestNamespace = "e2e.shudong.com/test-namespace"
+
+// installer 中 apply 步骤使用的 field manager
+const FieldManager = "e2e-framework"
+
 const (
 	KeyTempFleFmt   = "/tmp/%s.key"
 	CertTempFileFmt = "/tmp/%s.crt"
@@ -0,0 +1,248 @@
+package framework
+
+import (
+	"context"
+	"crypto/tls"
+	myApiV1 "deployment/api/v1"
+	myApiV2 "deployment/api/v2"
+	"deployment/internal/controller"
+	webhookappsv1 "deployment/internal/webhook/v1"
+	"fmt"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"net"
+	"os"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"time"
+)
+
+type EnvtestConfig struct {
+	// 集群的名字，只用于生成 kubectl 的 context 名字
+	Name string `json:"name"`
+	// 安装的 CRD 所在的目录，相对于执行测试的目录
+	CRDPaths []string `json:"crdPaths"`
+	// etcd 和 kube-apiserver 所在的目录，为空时使用 KUBEBUILDER_ASSETS 环境变量
+	BinaryAssetsDirectory string `json:"binaryAssetsDirectory"`
+	// 是否在进程内启动 webhook 服务，v1 和 v2 之间的转换依赖 webhook，默认启动
+	Webhook *bool `json:"webhook"`
+	// webhook 配置所在的目录，相对于执行测试的目录
+	WebhookPaths []string `json:"webhookPaths"`
+}
+
+// EnvtestProvider 使用 controller-runtime 的 envtest 在本地启动 etcd 和 kube-apiserver，
+// 安装 CRD，并在测试进程内运行 manager，不需要 kind 和 docker
+// envtest 中没有 kubelet 和其他 controller，Deployment 不会产生 pod，只适合测试 MyDeployment 生成的对象
+type EnvtestProvider struct {
+	testEnv *envtest.Environment
+	// 停止 manager
+	cancel context.CancelFunc
+	// manager 退出时的错误
+	done chan error
+}
+
+var _ ClusterProvider = &EnvtestProvider{}
+
+func (e *EnvtestProvider) Validate(config *Config) error {
+	// 1. 获取配置
+	if config == nil {
+		return field.Invalid(field.NewPath("config"), nil, "config is required")
+	}
+	envtestConfig := config.Sub("cluster").Sub("envtest")
+	root := field.NewPath("cluster", "envtest")
+	if envtestConfig == nil {
+		return field.Invalid(root, nil, "envtest config is required")
+	}
+	// 2. 设置默认项
+	if envtestConfig.GetString("name") == "" {
+		envtestConfig.Set("name", "envtest")
+	}
+	// 3. 检查 etcd 和 kube-apiserver 的目录，没有指定时 envtest 使用 KUBEBUILDER_ASSETS 环境变量或者默认目录
+	if dir := envtestConfig.GetString("binaryAssetsDirectory"); dir != "" {
+		if _, err := os.Stat(dir); err != nil {
+			return field.Invalid(root.Child("binaryAssetsDirectory"), dir, err.Error())
+		}
+	}
+	return nil
+}
+
+func (e *EnvtestProvider) Deploy(config *Config) (ClusterConfig, error) {
+	clusterConfig := ClusterConfig{}
+	// 1. 获取配置
+	envtestConfig, err := getEnvtestConfig(config.Sub("cluster").Sub("envtest"))
+	if err != nil {
+		return clusterConfig, err
+	}
+	logf.SetLogger(zap.New(zap.WriteTo(config.Stdout), zap.UseDevMode(true)))
+
+	// 2. 准备 scheme，v2 是存储版本，注册后 webhook 服务同时提供 /convert
+	scheme := runtime.NewScheme()
+	for _, addToScheme := range []func(*runtime.Scheme) error{
+		clientgoscheme.AddToScheme, myApiV1.AddToScheme, myApiV2.AddToScheme,
+	} {
+		if err := addToScheme(scheme); err != nil {
+			return clusterConfig, err
+		}
+	}
+
+	// 3. 启动 etcd 和 kube-apiserver，安装 CRD
+	// 设置了 CRDInstallOptions.Scheme 时，envtest 会把 CRD 的转换策略改为进程内的 webhook
+	e.testEnv = &envtest.Environment{
+		CRDDirectoryPaths:     envtestConfig.CRDPaths,
+		ErrorIfCRDPathMissing: true,
+		BinaryAssetsDirectory: envtestConfig.BinaryAssetsDirectory,
+	}
+	if *envtestConfig.Webhook {
+		e.testEnv.CRDInstallOptions.Scheme = scheme
+		e.testEnv.WebhookInstallOptions.Paths = envtestConfig.WebhookPaths
+	}
+	restConfig, err := e.testEnv.Start()
+	if err != nil {
+		return clusterConfig, err
+	}
+	// 后续步骤出错时停止已经启动的环境
+	defer func() {
+		if err != nil {
+			_ = e.Destroy(config)
+		}
+	}()
+
+	// 4. 在进程内启动 manager，和 cmd/main.go 中的注册相同
+	err = e.startManager(restConfig, scheme, *envtestConfig.Webhook)
+	if err != nil {
+		return clusterConfig, err
+	}
+
+	// 5. 创建ClusterConfig
+	clusterConfig.Name = envtestConfig.Name
+	clusterConfig.Rest = restConfig
+	clusterConfig.MasterIP = restConfig.Host
+	// envtest 没有 kube-controller-manager，不会级联删除子资源
+	clusterConfig.GarbageCollector = false
+	return clusterConfig, nil
+}
+
+func (e *EnvtestProvider) Destroy(*Config) error {
+	// 1. 停止 manager，等待退出
+	var errs []error
+	if e.cancel != nil {
+		e.cancel()
+		if err := <-e.done; err != nil {
+			errs = append(errs, err)
+		}
+		e.cancel = nil
+	}
+	// 2. 停止 etcd 和 kube-apiserver
+	if e.testEnv != nil {
+		if err := e.testEnv.Stop(); err != nil {
+			errs = append(errs, err)
+		}
+		e.testEnv = nil
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("destroy envtest failed: %v", errs)
+	}
+	return nil
+}
+
+func (e *EnvtestProvider) startManager(restConfig *rest.Config, scheme *runtime.Scheme, enableWebhook bool) error {
+	options := ctrl.Options{
+		Scheme:         scheme,
+		LeaderElection: false,
+		Metrics:        metricsserver.Options{BindAddress: "0"},
+		// 同一个进程中可能多次创建 manager，关闭健康检查避免端口冲突
+		HealthProbeBindAddress: "0",
+	}
+	webhookInstallOptions := &e.testEnv.WebhookInstallOptions
+	if enableWebhook {
+		options.WebhookServer = webhook.NewServer(webhook.Options{
+			Host:    webhookInstallOptions.LocalServingHost,
+			Port:    webhookInstallOptions.LocalServingPort,
+			CertDir: webhookInstallOptions.LocalServingCertDir,
+		})
+	}
+	mgr, err := ctrl.NewManager(restConfig, options)
+	if err != nil {
+		return err
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	if err := (&controller.MyDeploymentReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		DynamicClient: dynamicClient,
+		APIReader:     mgr.GetAPIReader(),
+		Recorder:      mgr.GetEventRecorderFor("mydeployment-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		return err
+	}
+	if enableWebhook {
+		// 和 main 相同，/convert 通过存储版本 v2 单独注册
+		if err := ctrl.NewWebhookManagedBy(mgr).For(&myApiV2.MyDeployment{}).Complete(); err != nil {
+			return err
+		}
+		if err := webhookappsv1.SetupMyDeploymentWebhookWithManager(mgr, webhookappsv1.Options{}); err != nil {
+			return err
+		}
+	}
+
+	// 启动 manager，Destroy 时通过 cancel 停止
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.done = make(chan error, 1)
+	go func() {
+		e.done <- mgr.Start(ctx)
+	}()
+
+	// 等待 webhook 服务就绪，否则创建 MyDeployment 时 apiserver 调用 webhook 会失败
+	if !enableWebhook {
+		return nil
+	}
+	dialer := &net.Dialer{Timeout: time.Second}
+	addrPort := fmt.Sprintf("%s:%d", webhookInstallOptions.LocalServingHost, webhookInstallOptions.LocalServingPort)
+	return wait.PollUntilContextTimeout(ctx, time.Second, time.Minute, true, func(context.Context) (bool, error) {
+		conn, err := tls.DialWithDialer(dialer, "tcp", addrPort, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			return false, nil
+		}
+		return true, conn.Close()
+	})
+}
+
+func getEnvtestConfig(config *viper.Viper) (EnvtestConfig, error) {
+	envtestConfig := EnvtestConfig{}
+	if config == nil {
+		return envtestConfig, field.Invalid(field.NewPath("cluster", "envtest"),
+			nil, "envtest config is required")
+	}
+	if err := config.Unmarshal(&envtestConfig); err != nil {
+		return envtestConfig, err
+	}
+	if envtestConfig.Name == "" {
+		envtestConfig.Name = "envtest"
+	}
+	// 默认在 test/e2e 目录下执行测试
+	if len(envtestConfig.CRDPaths) == 0 {
+		envtestConfig.CRDPaths = []string{"../../config/crd/bases"}
+	}
+	if envtestConfig.Webhook == nil {
+		enabled := true
+		envtestConfig.Webhook = &enabled
+	}
+	if len(envtestConfig.WebhookPaths) == 0 {
+		envtestConfig.WebhookPaths = []string{"../../config/webhook"}
+	}
+	return envtestConfig, nil
+}
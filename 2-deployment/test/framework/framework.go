@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/ginkgo/v2/reporters"
 	"github.com/onsi/gomega"
 	"golang.org/x/exp/rand"
 	"io"
@@ -14,9 +15,12 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
@@ -92,32 +96,54 @@ func (f *Framework) SynchronizedBeforeSuite(initFunc func()) *Framework {
 				panic(err)
 			}
 			// 2. 初始化环境访问的授权，也就是创建 kubectl 访问需要的 config
-			ginkgo.By("kubectl switch context")
-			kubectlConfig := NewKubectlConfig(f.Config)
-			if err := kubectlConfig.SetContext(f.ClusterConfig); err != nil {
-				panic(err)
-			}
-			// 退出前清理 context
-			defer func() {
-				ginkgo.By("kubectl reverting context")
-				if !f.Config.Sub("cluster").Sub("kind").GetBool("retain") {
-					_ = kubectlConfig.DeleteContext(f.ClusterConfig)
+			// 使用 envtest 时不一定安装了 kubectl，没有时跳过
+			if _, err := exec.LookPath("kubectl"); err == nil {
+				ginkgo.By("kubectl switch context")
+				kubectlConfig := NewKubectlConfig(f.Config)
+				if err := kubectlConfig.SetContext(f.ClusterConfig); err != nil {
+					panic(err)
 				}
-			}()
+				// 退出前清理 context
+				defer func() {
+					ginkgo.By("kubectl reverting context")
+					// 只有 kind 支持保留集群，其他 provider 没有 cluster.kind 配置
+					if !f.Config.GetBool("cluster.kind.retain") {
+						_ = kubectlConfig.DeleteContext(f.ClusterConfig)
+					}
+				}()
+			}
 			// 3. 安装依赖和自己的程序
 			ginkgo.By("Preparing install steps")
-			installer := NewInstaller(f.Config)
+			installer := NewInstaller(f.Config).WithClusterConfig(f.ClusterConfig)
 			ginkgo.By("Executing install steps")
 			if err := installer.Install(); err != nil {
 				panic(err)
 			}
 		}
 	}
+	// 只在第一个进程中创建集群，通过序列化的 ClusterConfig 让并行的其他进程也可以访问集群
 	ginkgo.SynchronizedBeforeSuite(func() []byte {
 		initFunc()
-		return nil
-	}, func(_ []byte) {
-
+		if f.ClusterConfig == nil {
+			return nil
+		}
+		data, err := f.ClusterConfig.Marshal()
+		if err != nil {
+			panic(err)
+		}
+		return data
+	}, func(data []byte) {
+		// 第一个进程已经有 ClusterConfig 和 client
+		if f.client != nil || len(data) == 0 {
+			return
+		}
+		clusterConfig, err := UnmarshalClusterConfig(data)
+		if err != nil {
+			panic(err)
+		}
+		if err := f.WithClusterConfig(&clusterConfig); err != nil {
+			panic(err)
+		}
 	}, f.initTimeout)
 	return f
 }
@@ -146,10 +172,19 @@ func (f *Framework) MRun(m *testing.M) {
 
 func (f *Framework) Run(t *testing.T) {
 	gomega.RegisterFailHandler(ginkgo.Fail)
-	// TODO
-	//var r []ginkgo.Reporter
-	//r = append(r, reporters.NewJUnitReporter("e2e.xml"))
-	//ginkgo.RunSpecsWithDefaultAndCustomReporters(t, "e2e", r)
+	// 生成 junit 报告，并行执行时 ReportAfterSuite 只在第一个进程中执行，报告中包括所有进程的结果
+	artifactsConfig, err := f.artifactsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if artifactsConfig.Junit != "" {
+		ginkgo.ReportAfterSuite("junit", func(report ginkgo.Report) {
+			path := filepath.Join(artifactsConfig.Dir, artifactsConfig.Junit)
+			if err := reporters.GenerateJUnitReport(report, path); err != nil {
+				_, _ = fmt.Fprintf(ginkgo.GinkgoWriter, "failed to generate junit report %s: %s\n", path, err.Error())
+			}
+		})
+	}
 	ginkgo.RunSpecs(t, "framework")
 }
 
@@ -186,15 +221,19 @@ func (f *Framework) DeployTestEnvironment() error {
 	if err != nil {
 		return err
 	}
-	f.ClusterConfig = &clusterConfig
 	// 5. 创建 client 用于执行测试用例的时候使用
-	client, err := kubernetes.NewForConfig(f.ClusterConfig.Rest)
+	return f.WithClusterConfig(&clusterConfig)
+}
+
+// 设置访问集群的配置，并创建执行测试用例时使用的 client
+func (f *Framework) WithClusterConfig(clusterConfig *ClusterConfig) error {
+	client, err := kubernetes.NewForConfig(clusterConfig.Rest)
 	if err != nil {
 		return err
 	}
+	f.ClusterConfig = clusterConfig
 	f.client = client
 	return nil
-
 }
 
 // 销毁测试环境，此方法要在执行过 DeployTestEnvironment 方法后执行
@@ -223,31 +262,114 @@ func (f *Framework) DestroyTestEnvironment() error {
 
 func (f *Framework) Describe(name string, ctxFunc ContextFunc) bool {
 	// 整个函数，实际上是调用 ginkgo 的 Describe
-	return ginkgo.Describe(name, func() {
-		// 1. 创建 testContext
-		ctx, err := f.createTestContext(name, false)
-		if err != nil {
-			ginkgo.Fail("cannot create test context for " + name)
-			return
-		}
-		// 2. 执行每次测试任务前，来执行一些期望的动作，如创建 namespace 就放在这里
-		ginkgo.BeforeEach(func() {
-			ctx2, err := f.createTestContext(name, true)
-			if err != nil {
-				ginkgo.Fail("cannot create test context for " + name + " namespace = " + ctx2.Namespace)
+	// 测试函数中的 It 依赖前一个 It 创建的对象，所以按顺序执行，并共享同一个 namespace
+	// 并行执行时，ginkgo 把整个 Describe 分配给同一个进程，不同的 Describe 使用不同的 namespace，互不影响
+	return ginkgo.Describe(name, ginkgo.Ordered, func() {
+		// 1. 创建 testContext，构建测试树的时候集群还没有创建，所以只填充名字
+		ctx := TestContext{Name: name}
+		// 2. 执行所有测试任务前，来执行一些期望的动作，如创建 namespace 就放在这里
+		ginkgo.BeforeAll(func() {
+			testContext, err := f.createTestContext(name, true)
+			gomega.Expect(err).Should(gomega.BeNil(), "cannot create test context for "+name)
+			ctx = testContext
+		})
+		// 3. 执行所有测试任务后，来执行一些期望的动作，如删除 testContext
+		// 测试失败时需要先收集 namespace 中的对象，ReportAfterEach 在 AfterAll 之后执行，所以收集完再删除
+		deleteAfterReport := false
+		ginkgo.AfterAll(func() {
+			if ginkgo.CurrentSpecReport().Failed() && f.collectArtifacts() {
+				deleteAfterReport = true
 				return
 			}
-			ctx = ctx2
+			err := f.deleteTestContext(ctx)
+			gomega.Expect(err).Should(gomega.BeNil(), "cannot delete test context for "+name)
 		})
-		// 3. 执行每次测试任务后，来执行一些期望的动作，如删除 testContext
-		ginkgo.AfterEach(func() {
-			_ = f.deleteTestContext(ctx)
+		// 4. 测试失败时收集集群的状态，保存到 artifacts 目录中
+		ginkgo.ReportAfterEach(func(report ginkgo.SpecReport) {
+			if !report.Failed() {
+				return
+			}
+			f.dumpArtifacts(ctx, report)
+			if deleteAfterReport {
+				deleteAfterReport = false
+				if err := f.deleteTestContext(ctx); err != nil {
+					_, _ = fmt.Fprintf(ginkgo.GinkgoWriter, "cannot delete test context for %s: %s\n", name, err.Error())
+				}
+			}
 		})
-		// 4. 执行用户的测试函数
+		// 5. 执行用户的测试函数
 		ctxFunc(&ctx, f)
 	})
 }
 
+// RequireGarbageCollector 集群没有垃圾回收时跳过当前用例，在等待子资源被级联删除的用例中使用
+func (f *Framework) RequireGarbageCollector() {
+	if f.ClusterConfig == nil || !f.ClusterConfig.GarbageCollector {
+		ginkgo.Skip("cluster has no garbage collector, owned objects are not deleted")
+	}
+}
+
+// RequireCRD 集群中没有这个 CRD 时跳过当前用例，格式为 <plural>.<group>，比如 certificates.cert-manager.io
+func (f *Framework) RequireCRD(name string) {
+	if f.client == nil {
+		ginkgo.Skip("cluster is not ready, cannot check crd " + name)
+	}
+	served, err := crdServed(f.client.Discovery(), name)
+	gomega.Expect(err).Should(gomega.BeNil(), "cannot check crd "+name)
+	if !served {
+		ginkgo.Skip("crd " + name + " is not installed")
+	}
+}
+
+// 读取 artifacts 配置
+func (f *Framework) artifactsConfig() (ArtifactsConfig, error) {
+	if f.Config == nil {
+		return getArtifactsConfig(nil)
+	}
+	return getArtifactsConfig(f.Config.Sub("artifacts"))
+}
+
+// 测试失败时是否收集集群的状态
+func (f *Framework) collectArtifacts() bool {
+	artifactsConfig, err := f.artifactsConfig()
+	return err == nil && *artifactsConfig.Collect
+}
+
+// 把测试任务失败时集群的状态保存到这个任务的 artifacts 目录中，失败时只输出错误，不影响测试结果
+func (f *Framework) dumpArtifacts(ctx TestContext, report ginkgo.SpecReport) {
+	// 1. 检查配置，namespace 没有创建成功时没有可以收集的内容
+	artifactsConfig, err := f.artifactsConfig()
+	if err != nil || !*artifactsConfig.Collect || ctx.Namespace == "" || f.ClusterConfig == nil {
+		return
+	}
+	dynamicClient, err := dynamic.NewForConfig(f.ClusterConfig.Rest)
+	if err != nil {
+		_, _ = fmt.Fprintf(ginkgo.GinkgoWriter, "failed to collect artifacts: %s\n", err.Error())
+		return
+	}
+	// 2. 收集
+	collector := &artifactsCollector{
+		config:    artifactsConfig,
+		client:    f.client,
+		dynamic:   dynamicClient,
+		namespace: ctx.Namespace,
+		dir:       artifactsConfig.specDir(report),
+	}
+	if err := collector.collect(); err != nil {
+		_, _ = fmt.Fprintf(ginkgo.GinkgoWriter, "failed to collect artifacts: %s\n", err.Error())
+	}
+	_, _ = fmt.Fprintf(ginkgo.GinkgoWriter, "artifacts of %q are saved in %s\n", report.FullText(), collector.dir)
+}
+
+// 加载测试文件内容到 typed 对象中，比如 *myApiV1.MyDeployment
+func (f *Framework) LoadYaml(path string, obj interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, obj)
+}
+
 // 加载测试文件内容到对象中
 func (f *Framework) LoadYamlToUnstructured(path string, obj *unstructured.Unstructured) error {
 	data, err := os.ReadFile(path)
@@ -262,9 +384,7 @@ func (f *Framework) createTestContext(name string, nsCreate bool) (TestContext,
 	testContext := TestContext{}
 	// 2. 检查 f 是否为空
 	if f.Config == nil || f.ClusterConfig == nil {
-		// TODO
-		return testContext, nil
-		//return testContext, field.Invalid(field.NewPath("config/clusterConfig"), nil, "config is required")
+		return testContext, field.Invalid(field.NewPath("config/clusterConfig"), nil, "config is required")
 	}
 
 	// 3. 填充字段
@@ -287,10 +407,11 @@ func (f *Framework) createTestContext(name string, nsCreate bool) (TestContext,
 		namespace, err := f.client.CoreV1().Namespaces().Create(context.TODO(), &corev1.Namespace{
 			ObjectMeta: metav1.ObjectMeta{
 				GenerateName: prefix + "-",
+				Labels:       map[string]string{LabelKeyTestNamespace: "true"},
 			},
 		}, metav1.CreateOptions{})
 		if err != nil {
-			return testContext, nil
+			return testContext, err
 		}
 		testContext.Namespace = namespace.GetName()
 	}
@@ -1,21 +1,54 @@
 package framework
 
 import (
+	"context"
+	"fmt"
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/discovery"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
+// 重试的默认间隔
+const DefaultRetryInterval = 5 * time.Second
+
 type Install struct {
-	Name       string   `json:"name"`
-	Cmd        string   `json:"cmd"`
-	Args       []string `json:"args"`
-	Path       string   `json:"path"`
-	IgnoreFail bool     `json:"ignoreFail"`
-	Config     *Config  `json:"-"`
+	Name string `json:"name"`
+	// 执行命令，和 apply 只能设置一个
+	Cmd  string   `json:"cmd"`
+	Args []string `json:"args"`
+	// 通过 server-side apply 安装 yaml，不需要 kubectl
+	Apply *Apply `json:"apply"`
+	Path  string `json:"path"`
+	// 执行失败时是否继续执行后面的步骤
+	IgnoreFail bool `json:"ignoreFail"`
+	// 每次执行的超时时间，比如 5m，为 0 时不限制
+	Timeout time.Duration `json:"timeout"`
+	// 失败后的重试次数，为 0 时不重试
+	Retries int `json:"retries"`
+	// 重试的间隔，默认 DefaultRetryInterval
+	RetryInterval time.Duration `json:"retryInterval"`
+	// 额外的环境变量，格式为 KEY=value，viper 会把 map 的 key 转为小写，所以使用列表
+	Env []string `json:"env"`
+	// 满足条件时才执行，不满足时跳过
+	When *When `json:"when"`
+	// 是否和相邻的同样设置了 parallel 的步骤并行执行
+	Parallel bool    `json:"parallel"`
+	Config   *Config `json:"-"`
+}
+
+// When 步骤的执行条件，设置了多个条件时需要全部满足
+type When struct {
+	// 集群中存在这个 CRD 时执行，格式为 <plural>.<group>，比如 certificates.cert-manager.io
+	CRDExists string `json:"crdExists"`
+	// 集群中不存在这个 CRD 时执行，比如已经安装了 cert-manager 时跳过安装
+	CRDNotExists string `json:"crdNotExists"`
+	// 设置了这个环境变量，并且不为空时执行
+	Env string `json:"env"`
 }
 
 func (i *Install) validate(root *field.Path) error {
@@ -24,56 +57,132 @@ func (i *Install) validate(root *field.Path) error {
 	if strings.TrimSpace(i.Name) == "" {
 		errs = append(errs, field.Invalid(root.Child("name"), i.Name, "name is required"))
 	}
-	// 2. 验证 cmd 字段
-	if strings.TrimSpace(i.Cmd) == "" {
-		errs = append(errs, field.Invalid(root.Child("cmd"), i.Cmd, "cmd is required"))
+	// 2. 验证 cmd 和 apply 字段
+	switch {
+	case strings.TrimSpace(i.Cmd) == "" && i.Apply == nil:
+		errs = append(errs, field.Invalid(root.Child("cmd"), i.Cmd, "one of cmd and apply is required"))
+	case strings.TrimSpace(i.Cmd) != "" && i.Apply != nil:
+		errs = append(errs, field.Invalid(root.Child("apply"), i.Apply.Files, "cmd and apply are mutually exclusive"))
+	case i.Apply != nil:
+		errs = append(errs, i.Apply.validate(root.Child("apply"))...)
 	}
 	// 3. 检查并设置 path 默认值
 	if strings.TrimSpace(i.Path) == "" {
 		i.Path = "."
 	}
+	// 4. 验证超时和重试
+	if i.Timeout < 0 {
+		errs = append(errs, field.Invalid(root.Child("timeout"), i.Timeout.String(), "timeout must not be negative"))
+	}
+	if i.Retries < 0 {
+		errs = append(errs, field.Invalid(root.Child("retries"), i.Retries, "retries must not be negative"))
+	}
+	if i.RetryInterval <= 0 {
+		i.RetryInterval = DefaultRetryInterval
+	}
+	// 5. 验证环境变量格式
+	for index, env := range i.Env {
+		if key, _, ok := strings.Cut(env, "="); !ok || strings.TrimSpace(key) == "" {
+			errs = append(errs, field.Invalid(root.Child("env").Index(index), env, "env must be KEY=value"))
+		}
+	}
 	return errs.ToAggregate()
 }
 
-func (i *Install) install() error {
-	// 1. 获取当前路径
-	currentDir, err := os.Getwd()
+// 根据 when 判断是否需要执行
+func (i *Install) shouldRun(clusterConfig *ClusterConfig) (bool, error) {
+	if i.When == nil {
+		return true, nil
+	}
+	if i.When.Env != "" && os.Getenv(i.When.Env) == "" {
+		return false, nil
+	}
+	if i.When.CRDExists == "" && i.When.CRDNotExists == "" {
+		return true, nil
+	}
+	// 检查 CRD 需要访问集群
+	if clusterConfig == nil || clusterConfig.Rest == nil {
+		return false, fmt.Errorf("step %s: cluster config is required to check crd", i.Name)
+	}
+	client, err := discovery.NewDiscoveryClientForConfig(clusterConfig.Rest)
 	if err != nil {
-		return err
+		return false, err
 	}
-	absPath := i.Path
-	if !filepath.IsAbs(absPath) {
-		if absPath, err = filepath.Abs(absPath); err != nil {
-			return err
+	if i.When.CRDExists != "" {
+		exists, err := crdServed(client, i.When.CRDExists)
+		if err != nil || !exists {
+			return false, err
 		}
 	}
-	// 2. 切换路径
-	// 2.1 判断路径是否相同
-	if currentDir != absPath {
-		// 2.1.1 不同，则切换路径
-		if err := os.Chdir(absPath); err != nil {
-			return err
+	if i.When.CRDNotExists != "" {
+		exists, err := crdServed(client, i.When.CRDNotExists)
+		if err != nil || exists {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// 按照 retries 重试执行命令
+func (i *Install) install(clusterConfig *ClusterConfig) error {
+	var err error
+	for attempt := 0; attempt <= i.Retries; attempt++ {
+		if attempt > 0 {
+			_, _ = fmt.Fprintf(i.Config.Stderr, "step %s failed: %s, retry %d/%d after %s\n",
+				i.Name, err.Error(), attempt, i.Retries, i.RetryInterval)
+			time.Sleep(i.RetryInterval)
+		}
+		if err = i.run(clusterConfig); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("step %s: %w", i.Name, err)
+}
+
+// 执行一次命令
+func (i *Install) run(clusterConfig *ClusterConfig) error {
+	// 1. 计算执行目录，使用 cmd.Dir 而不是切换进程的目录，多个步骤可以同时执行
+	dir, err := filepath.Abs(i.Path)
+	if err != nil {
+		return err
+	}
+	// 2. 设置超时
+	ctx := context.Background()
+	if i.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.Timeout)
+		defer cancel()
+	}
+	// 3. apply yaml
+	if i.Apply != nil {
+		err = i.Apply.apply(ctx, clusterConfig, dir, i.Config.Stdout)
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("timed out after %s: %w", i.Timeout, err)
 		}
+		return err
 	}
-	// 3. defer 退出前回到之前路径
-	defer func() {
-		_ = os.Chdir(currentDir)
-	}()
 	// 4. 执行命令
-	cmd := exec.Command(i.Cmd, i.Args...)
+	cmd := exec.CommandContext(ctx, i.Cmd, i.Args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), i.Env...)
 	cmd.Stderr = i.Config.Stderr
 	cmd.Stdout = i.Config.Stdout
-	return cmd.Run()
+	err = cmd.Run()
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s: %w", i.Timeout, err)
+	}
+	return err
 }
 
 // 使用步骤
-// 1. installer := NewInstaller(config)
+// 1. installer := NewInstaller(config).WithClusterConfig(clusterConfig)
 // 2. installer.Install()
 type Installer struct {
 	Steps []Install `json:"steps"`
 
-	config *Config
-	once   sync.Once
+	config        *Config
+	clusterConfig *ClusterConfig
+	once          sync.Once
 }
 
 func NewInstaller(config *Config) *Installer {
@@ -82,6 +191,12 @@ func NewInstaller(config *Config) *Installer {
 	}
 }
 
+// 设置访问集群的配置，when 中检查 CRD 和 apply 时使用
+func (i *Installer) WithClusterConfig(clusterConfig *ClusterConfig) *Installer {
+	i.clusterConfig = clusterConfig
+	return i
+}
+
 // 加载配置到 installer 对象
 func (i *Installer) init() error {
 	var error error
@@ -115,18 +230,89 @@ func (i *Installer) Install() error {
 	}
 	// 3. 遍历这个队列，执行 validate
 	root := field.NewPath("install")
-	for index, step := range i.Steps {
+	for index := range i.Steps {
 		fld := root.Index(index)
-		if err := step.validate(fld); err != nil {
+		if err := i.Steps[index].validate(fld); err != nil {
+			return err
+		}
+	}
+	// 4. 按顺序执行，相邻的 parallel 步骤作为一批同时执行
+	for start := 0; start < len(i.Steps); {
+		end := start + 1
+		if i.Steps[start].Parallel {
+			for end < len(i.Steps) && i.Steps[end].Parallel {
+				end++
+			}
+		}
+		if err := i.installBatch(i.Steps[start:end]); err != nil {
 			return err
 		}
+		start = end
 	}
-	// 3. 遍历这个队列，执行 install
-	for _, step := range i.Steps {
+	return nil
+}
+
+// 同时执行一批步骤，等待全部完成，返回没有设置 ignoreFail 的步骤的错误
+func (i *Installer) installBatch(steps []Install) error {
+	errs := make([]error, len(steps))
+	wg := sync.WaitGroup{}
+	for index := range steps {
+		step := &steps[index]
 		step.Config = i.config
-		if err := step.install(); err != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[index] = i.installStep(step)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+func (i *Installer) installStep(step *Install) error {
+	// 1. 判断是否满足执行条件
+	run, err := step.shouldRun(i.clusterConfig)
+	if err == nil && !run {
+		_, _ = fmt.Fprintf(i.config.Stdout, "step %s skipped\n", step.Name)
+		return nil
+	}
+	// 2. 执行
+	if err == nil {
+		err = step.install(i.clusterConfig)
+	}
+	// 3. 忽略失败时只输出错误
+	if err != nil && step.IgnoreFail {
+		_, _ = fmt.Fprintf(i.config.Stderr, "step %s failed and ignored: %s\n", step.Name, err.Error())
+		return nil
+	}
+	return err
+}
+
+// 集群中是否提供了 CRD 定义的资源，name 格式为 <plural>.<group>
+func crdServed(client discovery.DiscoveryInterface, name string) (bool, error) {
+	resource, group, ok := strings.Cut(name, ".")
+	if !ok {
+		return false, fmt.Errorf("invalid crd name %s, must be <plural>.<group>", name)
+	}
+	_, resourceLists, err := client.ServerGroupsAndResources()
+	// 部分 group 不可用时，只要找到了需要的资源就可以
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return false, err
+	}
+	for _, resourceList := range resourceLists {
+		if !strings.HasPrefix(resourceList.GroupVersion, group+"/") {
+			continue
+		}
+		for _, apiResource := range resourceList.APIResources {
+			if apiResource.Name == resource {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -108,6 +108,7 @@ func (KindProvider *KindProvider) Deploy(config *Config) (ClusterConfig, error)
 		return clusterConfig, err
 	}
 	clusterConfig.MasterIP = clusterConfig.Rest.Host
+	clusterConfig.GarbageCollector = true
 	return clusterConfig, nil
 }
 
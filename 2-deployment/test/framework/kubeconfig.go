@@ -0,0 +1,172 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/viper"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+)
+
+// 测试需要集群中已经安装的资源
+var requiredResources = map[string]string{
+	"apps.shudong.com/v1": "mydeployments",
+}
+
+type KubeconfigConfig struct {
+	// kubeconfig 文件的路径，为空时和 kubectl 相同，使用 KUBECONFIG 环境变量或者 ~/.kube/config
+	Path string `json:"path"`
+	// 使用的 context，为空时使用 kubeconfig 中的 current-context
+	Context string `json:"context"`
+	// 执行完测试任务后，是否删除框架创建的 namespace，集群本身不会被删除
+	CleanupNamespaces bool `json:"cleanupNamespaces"`
+}
+
+// KubeconfigProvider 使用已有的集群，比如团队的开发集群
+// 集群中需要已经安装了 CRD 和 operator，Deploy 不创建任何资源，Destroy 最多只清理测试用的 namespace
+type KubeconfigProvider struct {
+}
+
+var _ ClusterProvider = &KubeconfigProvider{}
+
+func (k *KubeconfigProvider) Validate(config *Config) error {
+	// 1. 获取配置
+	if config == nil {
+		return field.Invalid(field.NewPath("config"), nil, "config is required")
+	}
+	root := field.NewPath("cluster", "kubeconfig")
+	kubeconfigConfig, err := getKubeconfigConfig(config.Sub("cluster").Sub("kubeconfig"))
+	if err != nil {
+		return err
+	}
+	if kubeconfigConfig.Path != "" {
+		if _, err := os.Stat(kubeconfigConfig.Path); err != nil {
+			return field.Invalid(root.Child("path"), kubeconfigConfig.Path, err.Error())
+		}
+	}
+	restConfig, err := kubeconfigConfig.restConfig()
+	if err != nil {
+		return field.Invalid(root.Child("context"), kubeconfigConfig.Context, err.Error())
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	// 2. 检查能否连接到集群
+	if _, err := client.Discovery().ServerVersion(); err != nil {
+		return field.Invalid(root, restConfig.Host, fmt.Sprintf("cluster is unreachable: %s", err.Error()))
+	}
+	// 3. 检查集群中是否安装了 CRD
+	for groupVersion, resource := range requiredResources {
+		if err := checkResource(client, groupVersion, resource); err != nil {
+			return field.Invalid(root, restConfig.Host, err.Error())
+		}
+	}
+	return nil
+}
+
+func (k *KubeconfigProvider) Deploy(config *Config) (ClusterConfig, error) {
+	clusterConfig := ClusterConfig{}
+	// 1. 获取配置
+	kubeconfigConfig, err := getKubeconfigConfig(config.Sub("cluster").Sub("kubeconfig"))
+	if err != nil {
+		return clusterConfig, err
+	}
+	// 2. 安装步骤中的 kubectl 和 make 使用同一个 kubeconfig 文件
+	if kubeconfigConfig.Path != "" {
+		if err := os.Setenv(clientcmd.RecommendedConfigPathEnvVar, kubeconfigConfig.Path); err != nil {
+			return clusterConfig, err
+		}
+	}
+	// 3. 创建ClusterConfig
+	clusterConfig.Rest, err = kubeconfigConfig.restConfig()
+	if err != nil {
+		return clusterConfig, err
+	}
+	clusterConfig.Name = kubeconfigConfig.Context
+	if clusterConfig.Name == "" {
+		clusterConfig.Name = "kubeconfig"
+	}
+	clusterConfig.Context = kubeconfigConfig.Context
+	clusterConfig.MasterIP = clusterConfig.Rest.Host
+	clusterConfig.GarbageCollector = true
+	return clusterConfig, nil
+}
+
+func (k *KubeconfigProvider) Destroy(config *Config) error {
+	// 1. 获取配置
+	kubeconfigConfig, err := getKubeconfigConfig(config.Sub("cluster").Sub("kubeconfig"))
+	if err != nil {
+		return err
+	}
+	// 2. 没有配置清理时，不做任何事情，集群不属于测试框架
+	if !kubeconfigConfig.CleanupNamespaces {
+		return nil
+	}
+	// 3. 删除框架创建的 namespace，包括测试失败时没有删除的
+	restConfig, err := kubeconfigConfig.restConfig()
+	if err != nil {
+		return err
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	namespaces, err := client.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{
+		LabelSelector: LabelKeyTestNamespace + "=true",
+	})
+	if err != nil {
+		return err
+	}
+	errs := field.ErrorList{}
+	for _, namespace := range namespaces.Items {
+		if namespace.DeletionTimestamp != nil {
+			continue
+		}
+		err := client.CoreV1().Namespaces().Delete(context.TODO(), namespace.Name, metav1.DeleteOptions{})
+		if err != nil {
+			errs = append(errs, field.InternalError(field.NewPath("namespace").Key(namespace.Name), err))
+		}
+	}
+	return errs.ToAggregate()
+}
+
+// 按照 kubectl 的规则加载 kubeconfig，并切换到指定的 context
+func (k KubeconfigConfig) restConfig() (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if k.Path != "" {
+		loadingRules.ExplicitPath = k.Path
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: k.Context}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+}
+
+// 检查集群中是否提供了 groupVersion 下的资源
+func checkResource(client kubernetes.Interface, groupVersion, resource string) error {
+	resources, err := client.Discovery().ServerResourcesForGroupVersion(groupVersion)
+	if err != nil {
+		return fmt.Errorf("%s is not installed: %s", groupVersion, err.Error())
+	}
+	for _, apiResource := range resources.APIResources {
+		if apiResource.Name == resource {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not installed in %s", resource, groupVersion)
+}
+
+func getKubeconfigConfig(config *viper.Viper) (KubeconfigConfig, error) {
+	kubeconfigConfig := KubeconfigConfig{}
+	if config == nil {
+		return kubeconfigConfig, field.Invalid(field.NewPath("cluster", "kubeconfig"),
+			nil, "kubeconfig config is required")
+	}
+	if err := config.Unmarshal(&kubeconfigConfig); err != nil {
+		return kubeconfigConfig, err
+	}
+	return kubeconfigConfig, nil
+}
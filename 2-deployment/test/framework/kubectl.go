@@ -49,7 +49,10 @@ func (k *KubectlConfig) SetContext(config *ClusterConfig) error {
 			k.previousContext = strings.TrimSpace(currentContext.String())
 		}
 	}()
-	// 2. 从 ClusterConfig 创建 context
+	// 2. 从 ClusterConfig 创建 context，已经存在的 context 直接切换
+	if config.Context != "" {
+		return k.Command("kubectl", "config", "use-context", config.Context).Run()
+	}
 	// 2.1 设置 cluster，
 	// 命令为 kubectl config set-cluster <contextName> --server <MasterIP> --insecure-skip-tls-verify=true
 	if err := k.Command("kubectl", "config", "set-cluster", contextName,
@@ -101,6 +104,13 @@ func (k *KubectlConfig) SetContext(config *ClusterConfig) error {
 }
 
 func (k *KubectlConfig) DeleteContext(config *ClusterConfig) error {
+	// 已经存在的 context 不是框架创建的，只恢复到之前的 context
+	if config.Context != "" {
+		if k.previousContext != "" {
+			_ = k.Command("kubectl", "config", "use-context", k.previousContext).Run()
+		}
+		return nil
+	}
 	contextName := k.GetContextName(config)
 	// 1. 删除 cluster
 	// 命令为 kubectl config delete-cluster <contextName>
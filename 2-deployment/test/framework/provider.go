@@ -34,6 +34,12 @@ func (f Factory) Provider(config *Config) (ClusterProvider, error) {
 	case cluster.Sub("kind") != nil:
 		kind := new(KindProvider)
 		return kind, nil
+	case cluster.Sub("kubeconfig") != nil:
+		kubeconfigProvider := new(KubeconfigProvider)
+		return kubeconfigProvider, nil
+	case cluster.Sub("envtest") != nil:
+		envtestProvider := new(EnvtestProvider)
+		return envtestProvider, nil
 	default:
 		return clusterProvider, fmt.Errorf("not support provider: %#v", cluster.AllSettings())
 	}
@@ -1,6 +1,8 @@
 package framework
 
 import (
+	"deployment/pkg/client/clientset/versioned"
+	myclientv1 "deployment/pkg/client/clientset/versioned/typed/apps/v1"
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
 	"k8s.io/client-go/dynamic"
@@ -46,3 +48,13 @@ func (tc *TestContext) CreateClientSet() *kubernetes.Clientset {
 	}
 	return client
 }
+
+// 创建访问测试 namespace 中 MyDeployment 的 typed client
+func (tc *TestContext) CreateMyDeploymentClient() myclientv1.MyDeploymentInterface {
+	ginkgo.By("Creating MyDeployment client")
+	client, err := versioned.NewForConfig(tc.Config)
+	if err != nil {
+		gomega.Expect(err).Should(gomega.BeNil())
+	}
+	return client.AppsV1().MyDeployments(tc.Namespace)
+}
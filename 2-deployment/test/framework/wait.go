@@ -0,0 +1,151 @@
+package framework
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/onsi/ginkgo/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+	"time"
+)
+
+// MyDeploymentGVR MyDeployment 的 GVR，供 DynamicClient 调用
+var MyDeploymentGVR = schema.GroupVersionResource{
+	Group:    "apps.shudong.com",
+	Version:  "v1",
+	Resource: "mydeployments",
+}
+
+// WaitForChild 支持的对象类型
+var childGVRs = map[string]schema.GroupVersionResource{
+	"MyDeployment":          MyDeploymentGVR,
+	"Deployment":            {Group: "apps", Version: "v1", Resource: "deployments"},
+	"Service":               {Version: "v1", Resource: "services"},
+	"ConfigMap":             {Version: "v1", Resource: "configmaps"},
+	"PersistentVolumeClaim": {Version: "v1", Resource: "persistentvolumeclaims"},
+	"ServiceAccount":        {Version: "v1", Resource: "serviceaccounts"},
+	"Ingress":               {Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"},
+	"Issuer":                {Group: "cert-manager.io", Version: "v1", Resource: "issuers"},
+	"Certificate":           {Group: "cert-manager.io", Version: "v1", Resource: "certificates"},
+}
+
+// WaitForMyDeploymentPhase 等待 MyDeployment 的 status.phase 变为 phase
+func (tc *TestContext) WaitForMyDeploymentPhase(namespace, name, phase string, timeout time.Duration) error {
+	ginkgo.By(fmt.Sprintf("Waiting for mydeployment %s/%s phase %s", namespace, name, phase))
+	return tc.waitFor(MyDeploymentGVR, namespace, name, timeout, func(obj *unstructured.Unstructured) bool {
+		if obj == nil {
+			return false
+		}
+		current, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+		return current == phase
+	})
+}
+
+// WaitForCondition 等待 MyDeployment 类型为 conditionType 的 condition 的状态变为 status
+func (tc *TestContext) WaitForCondition(namespace, name, conditionType, status string, timeout time.Duration) error {
+	ginkgo.By(fmt.Sprintf("Waiting for mydeployment %s/%s condition %s=%s", namespace, name, conditionType, status))
+	return tc.waitFor(MyDeploymentGVR, namespace, name, timeout, func(obj *unstructured.Unstructured) bool {
+		if obj == nil {
+			return false
+		}
+		conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+		for _, item := range conditions {
+			condition, ok := item.(map[string]interface{})
+			if ok && condition["type"] == conditionType {
+				return condition["status"] == status
+			}
+		}
+		return false
+	})
+}
+
+// WaitForChild 等待 kind 类型的对象存在或者被删除，kind 为 childGVRs 中的类型
+func (tc *TestContext) WaitForChild(namespace, kind, name string, exists bool, timeout time.Duration) error {
+	gvr, ok := childGVRs[kind]
+	if !ok {
+		return fmt.Errorf("unsupported kind %s", kind)
+	}
+	ginkgo.By(fmt.Sprintf("Waiting for %s %s/%s exists=%t", kind, namespace, name, exists))
+	return tc.waitFor(gvr, namespace, name, timeout, func(obj *unstructured.Unstructured) bool {
+		// 正在删除的对象视为已经删除
+		return (obj != nil && obj.GetDeletionTimestamp() == nil) == exists
+	})
+}
+
+// 通过 watch 等待对象满足 check，对象不存在时 check 的参数为 nil
+// 超时时返回最后一次观察到的 status，方便定位失败的原因
+func (tc *TestContext) waitFor(gvr schema.GroupVersionResource, namespace, name string, timeout time.Duration,
+	check func(obj *unstructured.Unstructured) bool) error {
+	// 1. 创建只关注这个对象的 ListWatch
+	client, err := dynamic.NewForConfig(tc.Config)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector
+			return client.Resource(gvr).Namespace(namespace).List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			return client.Resource(gvr).Namespace(namespace).Watch(ctx, options)
+		},
+	}
+
+	// 2. 先检查 list 的结果，再检查 watch 的事件
+	var last *unstructured.Unstructured
+	precondition := func(store cache.Store) (bool, error) {
+		item, exists, err := store.GetByKey(namespace + "/" + name)
+		if err != nil {
+			return false, err
+		}
+		if exists {
+			last = item.(*unstructured.Unstructured)
+		}
+		return check(last), nil
+	}
+	condition := func(event watch.Event) (bool, error) {
+		switch event.Type {
+		case watch.Deleted:
+			last = nil
+		case watch.Added, watch.Modified:
+			last = event.Object.(*unstructured.Unstructured)
+		default:
+			return false, nil
+		}
+		return check(last), nil
+	}
+	_, err = watchtools.UntilWithSync(ctx, lw, &unstructured.Unstructured{}, precondition, condition)
+	if err != nil {
+		return fmt.Errorf("wait for %s %s/%s failed: %w, last observed: %s",
+			gvr.Resource, namespace, name, err, observedStatus(last))
+	}
+	return nil
+}
+
+// 对象的 status，对象不存在时返回 not found
+func observedStatus(obj *unstructured.Unstructured) string {
+	if obj == nil {
+		return "not found"
+	}
+	status, ok := obj.Object["status"]
+	if !ok {
+		return "no status"
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}